@@ -11,6 +11,9 @@ import (
 
 var DB *sql.DB
 
+// defaultAdminPasswordHash 默认管理员初始密码 admin123 的bcrypt哈希
+const defaultAdminPasswordHash = "$2a$10$7kCbgG3FCL5wfatLw7RnL.qefBo7t1OwiGxfWma3vGHZSkYy67k12"
+
 func InitDB(dataSourceName string) error {
 	var err error
 	DB, err = sql.Open("mysql", dataSourceName)
@@ -95,7 +98,12 @@ func ensureTablesExist() error {
 		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
 		container_id VARCHAR(64),
 		base_port INT UNIQUE,
-		last_login TIMESTAMP NULL
+		last_login TIMESTAMP NULL,
+		must_change_password BOOLEAN DEFAULT FALSE,
+		expires_at TIMESTAMP NULL,
+		expiry_warned_at TIMESTAMP NULL,
+		deprovisioned_at TIMESTAMP NULL,
+		deleted_at TIMESTAMP NULL
 	)`)
 	if err != nil {
 		return fmt.Errorf("failed to create users table: %v", err)
@@ -109,6 +117,16 @@ func ensureTablesExist() error {
 	}
 	fmt.Printf("DEBUG: Users table exists: %v\n", userTableExists > 0)
 
+	// 为已有部署补充用户表新增的列
+	if err := ensureColumnExists("users", "must_change_password", "BOOLEAN DEFAULT FALSE"); err != nil {
+		return fmt.Errorf("failed to add users.must_change_password column: %v", err)
+	}
+	for _, column := range []string{"expires_at", "expiry_warned_at", "deprovisioned_at", "deleted_at"} {
+		if err := ensureColumnExists("users", column, "TIMESTAMP NULL"); err != nil {
+			return fmt.Errorf("failed to add users.%s column: %v", column, err)
+		}
+	}
+
 	// 确保容器表存在
 	fmt.Printf("DEBUG: Creating containers table\n")
 	_, err = DB.Exec(`CREATE TABLE IF NOT EXISTS containers (
@@ -119,7 +137,10 @@ func ensureTablesExist() error {
 		image_name VARCHAR(200) DEFAULT 'connermo/ai4s-env:latest',
 		cpu_limit VARCHAR(20) DEFAULT '2',
 		memory_limit VARCHAR(20) DEFAULT '4g',
-		gpu_devices VARCHAR(100),
+		swap_limit VARCHAR(20),
+		pids_limit INT,
+		shm_size VARCHAR(20),
+		gpu_devices TEXT,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
 		last_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
@@ -129,6 +150,18 @@ func ensureTablesExist() error {
 		return fmt.Errorf("failed to create containers table: %v", err)
 	}
 
+	// 旧容器创建时没有资源限制，新增列留空表示不限制
+	for column, definition := range map[string]string{"swap_limit": "VARCHAR(20)", "pids_limit": "INT", "shm_size": "VARCHAR(20)"} {
+		if err := ensureColumnExists("containers", column, definition); err != nil {
+			return fmt.Errorf("failed to add containers.%s column: %v", column, err)
+		}
+	}
+
+	// 自动分配的 GPU 以 UUID 记录，多块时超过原来的 VARCHAR(100)
+	if err := ensureColumnType("containers", "gpu_devices", "text", "TEXT"); err != nil {
+		return fmt.Errorf("failed to widen containers.gpu_devices column: %v", err)
+	}
+
 	// 确保容器统计表存在
 	fmt.Printf("DEBUG: Creating container_stats table\n")
 	_, err = DB.Exec(`CREATE TABLE IF NOT EXISTS container_stats (
@@ -144,19 +177,382 @@ func ensureTablesExist() error {
 		return fmt.Errorf("failed to create container_stats table: %v", err)
 	}
 
+	// 确保登录会话表存在
+	fmt.Printf("DEBUG: Creating sessions table\n")
+	_, err = DB.Exec(`CREATE TABLE IF NOT EXISTS sessions (
+		id VARCHAR(64) PRIMARY KEY,
+		user_id INT NOT NULL,
+		refresh_token_hash CHAR(64) NOT NULL,
+		user_agent VARCHAR(255),
+		ip_address VARCHAR(64),
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		last_used_at TIMESTAMP NULL,
+		expires_at TIMESTAMP NOT NULL,
+		revoked_at TIMESTAMP NULL,
+		UNIQUE KEY uk_sessions_refresh_token_hash (refresh_token_hash),
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create sessions table: %v", err)
+	}
+
+	// 确保两步验证密钥表存在
+	fmt.Printf("DEBUG: Creating user_totp table\n")
+	_, err = DB.Exec(`CREATE TABLE IF NOT EXISTS user_totp (
+		user_id INT PRIMARY KEY,
+		secret VARCHAR(64) NOT NULL,
+		enabled BOOLEAN DEFAULT FALSE,
+		last_used_step BIGINT DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		confirmed_at TIMESTAMP NULL,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create user_totp table: %v", err)
+	}
+
+	// 确保两步验证恢复码表存在
+	fmt.Printf("DEBUG: Creating user_recovery_codes table\n")
+	_, err = DB.Exec(`CREATE TABLE IF NOT EXISTS user_recovery_codes (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		user_id INT NOT NULL,
+		code_hash CHAR(64) NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		used_at TIMESTAMP NULL,
+		UNIQUE KEY uk_recovery_codes_user_code (user_id, code_hash),
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create user_recovery_codes table: %v", err)
+	}
+
+	// 确保平台设置表存在
+	fmt.Printf("DEBUG: Creating platform_settings table\n")
+	_, err = DB.Exec(`CREATE TABLE IF NOT EXISTS platform_settings (
+		name VARCHAR(100) PRIMARY KEY,
+		value TEXT NOT NULL,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create platform_settings table: %v", err)
+	}
+
+	// 确保登录失败计数表存在
+	fmt.Printf("DEBUG: Creating login_attempts table\n")
+	_, err = DB.Exec(`CREATE TABLE IF NOT EXISTS login_attempts (
+		scope VARCHAR(16) NOT NULL,
+		subject VARCHAR(128) NOT NULL,
+		failures INT DEFAULT 0,
+		window_start TIMESTAMP NOT NULL,
+		locked_until TIMESTAMP NULL,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (scope, subject)
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create login_attempts table: %v", err)
+	}
+
+	// 确保个人访问令牌表存在
+	fmt.Printf("DEBUG: Creating api_tokens table\n")
+	_, err = DB.Exec(`CREATE TABLE IF NOT EXISTS api_tokens (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		user_id INT NOT NULL,
+		name VARCHAR(100) NOT NULL,
+		token_prefix VARCHAR(16) NOT NULL,
+		token_hash CHAR(64) NOT NULL,
+		scopes VARCHAR(255) NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		expires_at TIMESTAMP NOT NULL,
+		last_used_at TIMESTAMP NULL,
+		last_used_ip VARCHAR(64),
+		revoked_at TIMESTAMP NULL,
+		UNIQUE KEY uk_api_tokens_token_hash (token_hash),
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create api_tokens table: %v", err)
+	}
+
+	// 确保角色表存在
+	fmt.Printf("DEBUG: Creating roles table\n")
+	_, err = DB.Exec(`CREATE TABLE IF NOT EXISTS roles (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(50) NOT NULL,
+		description VARCHAR(255) DEFAULT '',
+		is_builtin BOOLEAN DEFAULT FALSE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE KEY uk_roles_name (name)
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create roles table: %v", err)
+	}
+
+	// 确保角色权限表存在
+	fmt.Printf("DEBUG: Creating role_permissions table\n")
+	_, err = DB.Exec(`CREATE TABLE IF NOT EXISTS role_permissions (
+		role_id INT NOT NULL,
+		permission VARCHAR(50) NOT NULL,
+		PRIMARY KEY (role_id, permission),
+		FOREIGN KEY (role_id) REFERENCES roles (id) ON DELETE CASCADE
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create role_permissions table: %v", err)
+	}
+
+	// 确保用户角色表存在
+	fmt.Printf("DEBUG: Creating user_roles table\n")
+	_, err = DB.Exec(`CREATE TABLE IF NOT EXISTS user_roles (
+		user_id INT NOT NULL,
+		role_id INT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, role_id),
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+		FOREIGN KEY (role_id) REFERENCES roles (id) ON DELETE CASCADE
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create user_roles table: %v", err)
+	}
+
+	// 确保用户组表存在
+	fmt.Printf("DEBUG: Creating user_groups table\n")
+	_, err = DB.Exec(`CREATE TABLE IF NOT EXISTS user_groups (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		name VARCHAR(50) NOT NULL,
+		description VARCHAR(255) DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE KEY uk_user_groups_name (name)
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create user_groups table: %v", err)
+	}
+
+	// 确保用户组成员表存在
+	fmt.Printf("DEBUG: Creating group_members table\n")
+	_, err = DB.Exec(`CREATE TABLE IF NOT EXISTS group_members (
+		group_id INT NOT NULL,
+		user_id INT NOT NULL,
+		is_admin BOOLEAN DEFAULT FALSE,
+		joined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (group_id, user_id),
+		FOREIGN KEY (group_id) REFERENCES user_groups (id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create group_members table: %v", err)
+	}
+
+	// 确保审计日志表存在
+	fmt.Printf("DEBUG: Creating audit_events table\n")
+	_, err = DB.Exec(`CREATE TABLE IF NOT EXISTS audit_events (
+		id BIGINT AUTO_INCREMENT PRIMARY KEY,
+		actor_id INT NOT NULL DEFAULT 0,
+		actor_name VARCHAR(100) NOT NULL DEFAULT '',
+		action VARCHAR(64) NOT NULL,
+		target_type VARCHAR(32) NOT NULL,
+		target_id VARCHAR(100) NOT NULL DEFAULT '',
+		source_ip VARCHAR(64) NOT NULL DEFAULT '',
+		result VARCHAR(16) NOT NULL,
+		error TEXT,
+		changes TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		KEY idx_audit_events_created_at (created_at),
+		KEY idx_audit_events_actor (actor_id, created_at),
+		KEY idx_audit_events_target (target_type, target_id)
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create audit_events table: %v", err)
+	}
+
+	// 确保批量导入任务表存在
+	fmt.Printf("DEBUG: Creating import_jobs table\n")
+	_, err = DB.Exec(`CREATE TABLE IF NOT EXISTS import_jobs (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		created_by INT NOT NULL DEFAULT 0,
+		created_by_name VARCHAR(100) NOT NULL DEFAULT '',
+		status VARCHAR(16) NOT NULL,
+		dry_run BOOLEAN DEFAULT FALSE,
+		create_containers BOOLEAN DEFAULT FALSE,
+		total INT NOT NULL DEFAULT 0,
+		succeeded INT NOT NULL DEFAULT 0,
+		failed INT NOT NULL DEFAULT 0,
+		results MEDIUMTEXT,
+		error TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		finished_at TIMESTAMP NULL,
+		KEY idx_import_jobs_created_at (created_at)
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create import_jobs table: %v", err)
+	}
+
+	// 确保后台操作表存在
+	fmt.Printf("DEBUG: Creating operations table\n")
+	_, err = DB.Exec(`CREATE TABLE IF NOT EXISTS operations (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		type VARCHAR(64) NOT NULL,
+		target_type VARCHAR(32) NOT NULL,
+		target_id VARCHAR(100) NOT NULL DEFAULT '',
+		target_name VARCHAR(100) NOT NULL DEFAULT '',
+		status VARCHAR(16) NOT NULL,
+		steps TEXT,
+		created_by INT NOT NULL DEFAULT 0,
+		created_by_name VARCHAR(100) NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		finished_at TIMESTAMP NULL,
+		KEY idx_operations_target (target_type, target_id),
+		KEY idx_operations_created_at (created_at)
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create operations table: %v", err)
+	}
+
+	// 确保GPU清单表存在
+	fmt.Printf("DEBUG: Creating gpus table\n")
+	_, err = DB.Exec(`CREATE TABLE IF NOT EXISTS gpus (
+		uuid VARCHAR(64) PRIMARY KEY,
+		gpu_index INT NOT NULL,
+		model VARCHAR(100),
+		memory_mb INT DEFAULT 0,
+		present BOOLEAN DEFAULT TRUE,
+		last_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create gpus table: %v", err)
+	}
+
+	// 确保GPU分配表存在
+	fmt.Printf("DEBUG: Creating gpu_assignments table\n")
+	_, err = DB.Exec(`CREATE TABLE IF NOT EXISTS gpu_assignments (
+		container_id VARCHAR(64) NOT NULL,
+		gpu_uuid VARCHAR(64) NOT NULL,
+		mode VARCHAR(20) NOT NULL DEFAULT 'exclusive',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (container_id, gpu_uuid),
+		INDEX idx_gpu_assignments_gpu (gpu_uuid),
+		FOREIGN KEY (container_id) REFERENCES containers (id) ON DELETE CASCADE,
+		FOREIGN KEY (gpu_uuid) REFERENCES gpus (uuid)
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create gpu_assignments table: %v", err)
+	}
+
+	// 确保GPU租约表存在
+	fmt.Printf("DEBUG: Creating gpu_leases table\n")
+	_, err = DB.Exec(`CREATE TABLE IF NOT EXISTS gpu_leases (
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		user_id INT NOT NULL,
+		mode VARCHAR(20) NOT NULL DEFAULT 'exclusive',
+		starts_at TIMESTAMP NOT NULL,
+		ends_at TIMESTAMP NOT NULL,
+		status VARCHAR(20) NOT NULL DEFAULT 'scheduled',
+		note VARCHAR(255),
+		previous_gpu_devices TEXT,
+		previous_gpu_mode VARCHAR(20),
+		previous_container_id VARCHAR(64),
+		last_error TEXT,
+		start_warned_at TIMESTAMP NULL,
+		end_warned_at TIMESTAMP NULL,
+		attached_at TIMESTAMP NULL,
+		detached_at TIMESTAMP NULL,
+		created_by INT,
+		created_by_name VARCHAR(100),
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX idx_gpu_leases_status_starts (status, starts_at),
+		INDEX idx_gpu_leases_status_ends (status, ends_at),
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create gpu_leases table: %v", err)
+	}
+	// 为已有部署补充租约表新增的列
+	if err := ensureColumnExists("gpu_leases", "previous_container_id", "VARCHAR(64)"); err != nil {
+		return fmt.Errorf("failed to add gpu_leases.previous_container_id column: %v", err)
+	}
+
+	// 确保GPU租约设备表存在
+	fmt.Printf("DEBUG: Creating gpu_lease_gpus table\n")
+	_, err = DB.Exec(`CREATE TABLE IF NOT EXISTS gpu_lease_gpus (
+		lease_id INT NOT NULL,
+		gpu_uuid VARCHAR(64) NOT NULL,
+		PRIMARY KEY (lease_id, gpu_uuid),
+		INDEX idx_gpu_lease_gpus_gpu (gpu_uuid),
+		FOREIGN KEY (lease_id) REFERENCES gpu_leases (id) ON DELETE CASCADE,
+		FOREIGN KEY (gpu_uuid) REFERENCES gpus (uuid)
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create gpu_lease_gpus table: %v", err)
+	}
+
+	// 确保外部身份关联表存在
+	fmt.Printf("DEBUG: Creating user_identities table\n")
+	_, err = DB.Exec(`CREATE TABLE IF NOT EXISTS user_identities (
+		provider VARCHAR(255) NOT NULL,
+		subject VARCHAR(255) NOT NULL,
+		user_id INT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (provider, subject),
+		INDEX idx_user_identities_user (user_id),
+		FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create user_identities table: %v", err)
+	}
+
 	// 确保默认管理员用户存在
 	fmt.Printf("DEBUG: Creating default admin user\n")
-	_, err = DB.Exec(`INSERT IGNORE INTO users (username, password, email, is_admin, base_port) 
-		VALUES ('admin', '$2a$10$7kCbgG3FCL5wfatLw7RnL.qefBo7t1OwiGxfWma3vGHZSkYy67k12', 'admin@example.com', TRUE, 9001)`)
+	_, err = DB.Exec(`INSERT IGNORE INTO users (username, password, email, is_admin, base_port, must_change_password) 
+		VALUES ('admin', ?, 'admin@example.com', TRUE, 9001, TRUE)`, defaultAdminPasswordHash)
 	if err != nil {
 		return fmt.Errorf("failed to create default admin user: %v", err)
 	}
 
+	// 默认管理员仍在使用初始密码时，强制其登录后修改
+	_, err = DB.Exec("UPDATE users SET must_change_password = TRUE WHERE username = 'admin' AND password = ?",
+		defaultAdminPasswordHash)
+	if err != nil {
+		return fmt.Errorf("failed to flag default admin password: %v", err)
+	}
+
 	return nil
 }
 
+// ensureColumnExists 为已有部署补充新增的列，MySQL 不支持 ADD COLUMN IF NOT EXISTS
+func ensureColumnExists(table, column, definition string) error {
+	var exists int
+	err := DB.QueryRow(`SELECT COUNT(*) FROM information_schema.columns
+		WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?`, table, column).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists > 0 {
+		return nil
+	}
+
+	fmt.Printf("DEBUG: Adding column %s.%s\n", table, column)
+	_, err = DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// ensureColumnType 列的类型不是 dataType 时修改为 definition，用于放宽已有部署中的列
+func ensureColumnType(table, column, dataType, definition string) error {
+	var current string
+	err := DB.QueryRow(`SELECT data_type FROM information_schema.columns
+		WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?`, table, column).Scan(&current)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(current, dataType) {
+		return nil
+	}
+
+	fmt.Printf("DEBUG: Changing column %s.%s to %s\n", table, column, definition)
+	_, err = DB.Exec(fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s", table, column, definition))
+	return err
+}
+
 func verifyTablesExist() error {
-	tables := []string{"users", "containers", "container_stats", "db_init_status"}
+	tables := []string{"users", "containers", "container_stats", "sessions", "user_totp", "user_recovery_codes", "platform_settings", "login_attempts", "api_tokens", "roles", "role_permissions", "user_roles", "user_groups", "group_members", "audit_events", "import_jobs", "operations", "gpus", "gpu_assignments", "gpu_leases", "gpu_lease_gpus", "user_identities", "db_init_status"}
 	
 	for _, table := range tables {
 		var exists int
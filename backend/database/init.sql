@@ -11,7 +11,12 @@ CREATE TABLE IF NOT EXISTS users (
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     container_id VARCHAR(64),
     base_port INT UNIQUE,
-    last_login TIMESTAMP NULL
+    last_login TIMESTAMP NULL,
+    must_change_password BOOLEAN DEFAULT FALSE,
+    expires_at TIMESTAMP NULL,
+    expiry_warned_at TIMESTAMP NULL,
+    deprovisioned_at TIMESTAMP NULL,
+    deleted_at TIMESTAMP NULL
 );
 
 -- 创建容器表
@@ -23,7 +28,10 @@ CREATE TABLE IF NOT EXISTS containers (
     image_name VARCHAR(200) DEFAULT 'connermo/ai4s-env:latest',
     cpu_limit VARCHAR(20) DEFAULT '2',
     memory_limit VARCHAR(20) DEFAULT '4g',
-    gpu_devices VARCHAR(100),
+    swap_limit VARCHAR(20),
+    pids_limit INT,
+    shm_size VARCHAR(20),
+    gpu_devices TEXT,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     last_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
@@ -41,6 +49,248 @@ CREATE TABLE IF NOT EXISTS container_stats (
     FOREIGN KEY (container_id) REFERENCES containers (id) ON DELETE CASCADE
 );
 
+-- 创建登录会话表
+CREATE TABLE IF NOT EXISTS sessions (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id INT NOT NULL,
+    refresh_token_hash CHAR(64) NOT NULL,
+    user_agent VARCHAR(255),
+    ip_address VARCHAR(64),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP NULL,
+    UNIQUE KEY uk_sessions_refresh_token_hash (refresh_token_hash),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- 创建两步验证密钥表
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id INT PRIMARY KEY,
+    secret VARCHAR(64) NOT NULL,
+    enabled BOOLEAN DEFAULT FALSE,
+    last_used_step BIGINT DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    confirmed_at TIMESTAMP NULL,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- 创建两步验证恢复码表
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    used_at TIMESTAMP NULL,
+    UNIQUE KEY uk_recovery_codes_user_code (user_id, code_hash),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- 创建平台设置表
+CREATE TABLE IF NOT EXISTS platform_settings (
+    name VARCHAR(100) PRIMARY KEY,
+    value TEXT NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+-- 创建登录失败计数表
+CREATE TABLE IF NOT EXISTS login_attempts (
+    scope VARCHAR(16) NOT NULL,
+    subject VARCHAR(128) NOT NULL,
+    failures INT DEFAULT 0,
+    window_start TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (scope, subject)
+);
+
+-- 创建个人访问令牌表
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    token_prefix VARCHAR(16) NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    scopes VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP NULL,
+    last_used_ip VARCHAR(64),
+    revoked_at TIMESTAMP NULL,
+    UNIQUE KEY uk_api_tokens_token_hash (token_hash),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- 创建角色表
+CREATE TABLE IF NOT EXISTS roles (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(50) NOT NULL,
+    description VARCHAR(255) DEFAULT '',
+    is_builtin BOOLEAN DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_roles_name (name)
+);
+
+-- 创建角色权限表
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id INT NOT NULL,
+    permission VARCHAR(50) NOT NULL,
+    PRIMARY KEY (role_id, permission),
+    FOREIGN KEY (role_id) REFERENCES roles (id) ON DELETE CASCADE
+);
+
+-- 创建用户角色表
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id INT NOT NULL,
+    role_id INT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, role_id),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (role_id) REFERENCES roles (id) ON DELETE CASCADE
+);
+
+-- 创建用户组表
+CREATE TABLE IF NOT EXISTS user_groups (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(50) NOT NULL,
+    description VARCHAR(255) DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_user_groups_name (name)
+);
+
+-- 创建用户组成员表
+CREATE TABLE IF NOT EXISTS group_members (
+    group_id INT NOT NULL,
+    user_id INT NOT NULL,
+    is_admin BOOLEAN DEFAULT FALSE,
+    joined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (group_id, user_id),
+    FOREIGN KEY (group_id) REFERENCES user_groups (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- 创建审计日志表
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    actor_id INT NOT NULL DEFAULT 0,
+    actor_name VARCHAR(100) NOT NULL DEFAULT '',
+    action VARCHAR(64) NOT NULL,
+    target_type VARCHAR(32) NOT NULL,
+    target_id VARCHAR(100) NOT NULL DEFAULT '',
+    source_ip VARCHAR(64) NOT NULL DEFAULT '',
+    result VARCHAR(16) NOT NULL,
+    error TEXT,
+    changes TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_audit_events_created_at (created_at),
+    KEY idx_audit_events_actor (actor_id, created_at),
+    KEY idx_audit_events_target (target_type, target_id)
+);
+
+-- 创建批量导入任务表
+CREATE TABLE IF NOT EXISTS import_jobs (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    created_by INT NOT NULL DEFAULT 0,
+    created_by_name VARCHAR(100) NOT NULL DEFAULT '',
+    status VARCHAR(16) NOT NULL,
+    dry_run BOOLEAN DEFAULT FALSE,
+    create_containers BOOLEAN DEFAULT FALSE,
+    total INT NOT NULL DEFAULT 0,
+    succeeded INT NOT NULL DEFAULT 0,
+    failed INT NOT NULL DEFAULT 0,
+    results MEDIUMTEXT,
+    error TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP NULL,
+    KEY idx_import_jobs_created_at (created_at)
+);
+
+-- 创建后台操作表
+CREATE TABLE IF NOT EXISTS operations (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    type VARCHAR(64) NOT NULL,
+    target_type VARCHAR(32) NOT NULL,
+    target_id VARCHAR(100) NOT NULL DEFAULT '',
+    target_name VARCHAR(100) NOT NULL DEFAULT '',
+    status VARCHAR(16) NOT NULL,
+    steps TEXT,
+    created_by INT NOT NULL DEFAULT 0,
+    created_by_name VARCHAR(100) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP NULL,
+    KEY idx_operations_target (target_type, target_id),
+    KEY idx_operations_created_at (created_at)
+);
+
+-- 创建GPU清单表
+CREATE TABLE IF NOT EXISTS gpus (
+    uuid VARCHAR(64) PRIMARY KEY,
+    gpu_index INT NOT NULL,
+    model VARCHAR(100),
+    memory_mb INT DEFAULT 0,
+    present BOOLEAN DEFAULT TRUE,
+    last_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+-- 创建GPU分配表
+CREATE TABLE IF NOT EXISTS gpu_assignments (
+    container_id VARCHAR(64) NOT NULL,
+    gpu_uuid VARCHAR(64) NOT NULL,
+    mode VARCHAR(20) NOT NULL DEFAULT 'exclusive',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (container_id, gpu_uuid),
+    INDEX idx_gpu_assignments_gpu (gpu_uuid),
+    FOREIGN KEY (container_id) REFERENCES containers (id) ON DELETE CASCADE,
+    FOREIGN KEY (gpu_uuid) REFERENCES gpus (uuid)
+);
+
+-- 创建GPU租约表
+CREATE TABLE IF NOT EXISTS gpu_leases (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    mode VARCHAR(20) NOT NULL DEFAULT 'exclusive',
+    starts_at TIMESTAMP NOT NULL,
+    ends_at TIMESTAMP NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'scheduled',
+    note VARCHAR(255),
+    previous_gpu_devices TEXT,
+    previous_gpu_mode VARCHAR(20),
+    previous_container_id VARCHAR(64),
+    last_error TEXT,
+    start_warned_at TIMESTAMP NULL,
+    end_warned_at TIMESTAMP NULL,
+    attached_at TIMESTAMP NULL,
+    detached_at TIMESTAMP NULL,
+    created_by INT,
+    created_by_name VARCHAR(100),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_gpu_leases_status_starts (status, starts_at),
+    INDEX idx_gpu_leases_status_ends (status, ends_at),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- 创建GPU租约设备表
+CREATE TABLE IF NOT EXISTS gpu_lease_gpus (
+    lease_id INT NOT NULL,
+    gpu_uuid VARCHAR(64) NOT NULL,
+    PRIMARY KEY (lease_id, gpu_uuid),
+    INDEX idx_gpu_lease_gpus_gpu (gpu_uuid),
+    FOREIGN KEY (lease_id) REFERENCES gpu_leases (id) ON DELETE CASCADE,
+    FOREIGN KEY (gpu_uuid) REFERENCES gpus (uuid)
+);
+
+-- 创建外部身份关联表
+CREATE TABLE IF NOT EXISTS user_identities (
+    provider VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_id INT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (provider, subject),
+    INDEX idx_user_identities_user (user_id),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
 -- 创建初始化状态表
 CREATE TABLE IF NOT EXISTS db_init_status (
     id INT AUTO_INCREMENT PRIMARY KEY,
@@ -49,9 +299,9 @@ CREATE TABLE IF NOT EXISTS db_init_status (
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
--- 插入默认管理员用户 (密码: admin123)
-INSERT IGNORE INTO users (username, password, email, is_admin, base_port) 
-VALUES ('admin', '$2a$10$7kCbgG3FCL5wfatLw7RnL.qefBo7t1OwiGxfWma3vGHZSkYy67k12', 'admin@example.com', TRUE, 9001);
+-- 插入默认管理员用户 (密码: admin123，首次登录必须修改)
+INSERT IGNORE INTO users (username, password, email, is_admin, base_port, must_change_password) 
+VALUES ('admin', '$2a$10$7kCbgG3FCL5wfatLw7RnL.qefBo7t1OwiGxfWma3vGHZSkYy67k12', 'admin@example.com', TRUE, 9001, TRUE);
 
 -- 标记基础数据已初始化
 INSERT IGNORE INTO db_init_status (component, initialized) VALUES ('base_data', TRUE);
\ No newline at end of file
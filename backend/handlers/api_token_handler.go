@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gpu-dev-platform/models"
+	"gpu-dev-platform/services"
+	"github.com/gorilla/mux"
+)
+
+// APITokenHandler 个人访问令牌管理，供脚本和CI调用平台接口
+type APITokenHandler struct {
+	apiTokens *services.APITokenService
+	audit     *services.AuditService
+}
+
+func NewAPITokenHandler() *APITokenHandler {
+	return &APITokenHandler{
+		apiTokens: services.NewAPITokenService(),
+		audit:     services.NewAuditService(),
+	}
+}
+
+type CreateAPITokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days,omitempty"` // 不填使用默认有效期
+}
+
+type CreateAPITokenResponse struct {
+	Token    string           `json:"token"` // 明文只返回这一次
+	APIToken *models.APIToken `json:"api_token"`
+}
+
+func (h *APITokenHandler) ListMyTokens(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFrom(r.Context())
+	h.writeTokens(w, principal.UserID)
+}
+
+func (h *APITokenHandler) CreateMyToken(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFrom(r.Context())
+
+	var req CreateAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Scopes) == 0 {
+		http.Error(w, "令牌名称和权限范围不能为空", http.StatusBadRequest)
+		return
+	}
+
+	// 没有用户管理权限的账户不能申请用户管理范围
+	for _, scope := range req.Scopes {
+		if (scope == models.ScopeUsersRead && !principal.Can(models.PermUsersRead)) ||
+			(scope == models.ScopeUsersWrite && !principal.Can(models.PermUsersUpdate)) {
+			http.Error(w, "无权申请该权限范围: "+scope, http.StatusForbidden)
+			return
+		}
+	}
+
+	ttl := time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	token, plaintext, err := h.apiTokens.CreateToken(principal.UserID, req.Name, req.Scopes, ttl)
+	if err != nil {
+		if err == services.ErrUnknownScope {
+			http.Error(w, "未知的权限范围，可选: "+strings.Join(models.AllScopes, ", "), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateAPITokenResponse{Token: plaintext, APIToken: token})
+}
+
+func (h *APITokenHandler) RevokeMyToken(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFrom(r.Context())
+	h.revoke(w, r, principal.UserID, nil)
+}
+
+// ListUserTokens 管理员查看指定用户的令牌
+func (h *APITokenHandler) ListUserTokens(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	h.writeTokens(w, userID)
+}
+
+// RevokeUserToken 管理员吊销指定用户的令牌
+func (h *APITokenHandler) RevokeUserToken(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	h.revoke(w, r, userID, newAuditEvent(r, models.AuditUserTokenRevoke, "user", strconv.Itoa(userID)))
+}
+
+func (h *APITokenHandler) writeTokens(w http.ResponseWriter, userID int) {
+	tokens, err := h.apiTokens.ListTokens(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// revoke 吊销令牌，event 不为空时（管理员吊销他人的令牌）记录审计
+func (h *APITokenHandler) revoke(w http.ResponseWriter, r *http.Request, userID int, event *models.AuditEvent) {
+	tokenID, err := strconv.Atoi(mux.Vars(r)["tokenId"])
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	if event != nil {
+		var token *models.APIToken
+		if token, err = h.apiTokens.GetToken(userID, tokenID); err == nil {
+			event.SetChange(fmt.Sprintf("api_token.%d", tokenID),
+				fmt.Sprintf("%s (%s…)", token.Name, token.Prefix), "revoked")
+		}
+	}
+	if err == nil {
+		err = h.apiTokens.RevokeToken(userID, tokenID)
+	}
+	if event != nil {
+		h.audit.Record(event, err)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
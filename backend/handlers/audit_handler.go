@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gpu-dev-platform/models"
+	"gpu-dev-platform/services"
+)
+
+const maxAuditPageSize = 500
+
+// AuditHandler 审计日志查询和导出
+type AuditHandler struct {
+	audit *services.AuditService
+}
+
+func NewAuditHandler() *AuditHandler {
+	return &AuditHandler{
+		audit: services.NewAuditService(),
+	}
+}
+
+type AuditListResponse struct {
+	Events   []*models.AuditEvent `json:"events"`
+	Total    int                  `json:"total"`
+	Page     int                  `json:"page"`
+	PageSize int                  `json:"page_size"`
+}
+
+// newAuditEvent 以当前调用者为操作人创建审计事件，由各管理接口在操作完成后交给 AuditService.Record
+func newAuditEvent(r *http.Request, action, targetType, targetID string) *models.AuditEvent {
+	event := &models.AuditEvent{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		SourceIP:   clientIP(r),
+	}
+	if principal, ok := PrincipalFrom(r.Context()); ok {
+		event.ActorID = principal.UserID
+		event.ActorName = principal.Username
+	}
+	return event
+}
+
+// ListEvents 按条件分页查询审计日志
+func (h *AuditHandler) ListEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, total, err := h.audit.List(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AuditListResponse{
+		Events:   events,
+		Total:    total,
+		Page:     filter.Page,
+		PageSize: filter.PageSize,
+	})
+}
+
+// ExportEvents 按条件导出全部匹配的审计日志，format=csv 或 jsonl
+func (h *AuditHandler) ExportEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	filename := fmt.Sprintf("audit-%s.%s", time.Now().Format("20060102-150405"), format)
+
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"id", "created_at", "actor_id", "actor_name", "action", "target_type",
+			"target_id", "source_ip", "result", "error", "changes"})
+		err = h.audit.Each(filter, func(e *models.AuditEvent) error {
+			changes := ""
+			if len(e.Changes) > 0 {
+				data, _ := json.Marshal(e.Changes)
+				changes = string(data)
+			}
+			return writer.Write([]string{
+				strconv.FormatInt(e.ID, 10), e.CreatedAt.Format(time.RFC3339), strconv.Itoa(e.ActorID),
+				e.ActorName, e.Action, e.TargetType, e.TargetID, e.SourceIP, e.Result, e.Error, changes,
+			})
+		})
+		writer.Flush()
+
+	case "jsonl":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+
+		encoder := json.NewEncoder(w)
+		err = h.audit.Each(filter, func(e *models.AuditEvent) error {
+			return encoder.Encode(e)
+		})
+
+	default:
+		http.Error(w, "format 只支持 csv 或 jsonl", http.StatusBadRequest)
+		return
+	}
+
+	// 响应已经开始写出，只能记录错误
+	if err != nil {
+		log.Printf("audit export failed: %v", err)
+	}
+}
+
+// parseAuditFilter 解析查询参数：actor_id, actor, action, target_type, target_id, result,
+// from/to (RFC3339), page, page_size
+func parseAuditFilter(r *http.Request) (services.AuditFilter, error) {
+	q := r.URL.Query()
+	filter := services.AuditFilter{
+		ActorName:  q.Get("actor"),
+		Action:     q.Get("action"),
+		TargetType: q.Get("target_type"),
+		TargetID:   q.Get("target_id"),
+		Result:     q.Get("result"),
+		Page:       1,
+		PageSize:   50,
+	}
+
+	var err error
+	if v := q.Get("actor_id"); v != "" {
+		if filter.ActorID, err = strconv.Atoi(v); err != nil {
+			return filter, fmt.Errorf("无效的 actor_id")
+		}
+	}
+	if v := q.Get("from"); v != "" {
+		if filter.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, fmt.Errorf("from 必须是 RFC3339 时间格式")
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if filter.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, fmt.Errorf("to 必须是 RFC3339 时间格式")
+		}
+	}
+	if v := q.Get("page"); v != "" {
+		if filter.Page, err = strconv.Atoi(v); err != nil || filter.Page < 1 {
+			return filter, fmt.Errorf("无效的 page")
+		}
+	}
+	if v := q.Get("page_size"); v != "" {
+		if filter.PageSize, err = strconv.Atoi(v); err != nil || filter.PageSize < 1 {
+			return filter, fmt.Errorf("无效的 page_size")
+		}
+		if filter.PageSize > maxAuditPageSize {
+			filter.PageSize = maxAuditPageSize
+		}
+	}
+
+	return filter, nil
+}
@@ -4,49 +4,116 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"gpu-dev-platform/database"
 	"gpu-dev-platform/models"
+	"gpu-dev-platform/services"
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthHandler struct {
-	db *sql.DB
+	db             *sql.DB
+	keys           *KeySet
+	sessionService *services.SessionService
+	userService    *services.UserService
+	authenticator  services.Authenticator
+	mfaService     *services.MFAService
+	settings       *services.SettingsService
+	throttle       *services.LoginThrottleService
+	apiTokens      *services.APITokenService
+	roles          *services.RoleService
+	groups         *services.GroupService
+	oidc           *services.OIDCProvider // 未配置OIDC时为nil
+	oidcStates     *oidcStateStore
+	oidcMFATickets *oidcStateStore
 }
 
 type LoginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	TOTPCode string `json:"totp_code,omitempty"` // 已启用两步验证时必填，也可填写恢复码
 }
 
 type LoginResponse struct {
-	Token string       `json:"token"`
-	User  *models.User `json:"user"`
+	Token        string       `json:"token"`
+	RefreshToken string       `json:"refresh_token"`
+	ExpiresIn    int          `json:"expires_in"` // 访问token有效秒数
+	User         *models.User `json:"user"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
 type Claims struct {
-	UserID   int    `json:"user_id"`
-	Username string `json:"username"`
-	IsAdmin  bool   `json:"is_admin"`
+	UserID    int    `json:"user_id"`
+	Username  string `json:"username"`
+	IsAdmin   bool   `json:"is_admin"`
+	SessionID string `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-// JWT密钥 - 在生产环境中应该从环境变量获取
-var jwtSecret = []byte("your-secret-key-change-in-production")
+func NewAuthHandler() (*AuthHandler, error) {
+	keys, err := LoadKeySet()
+	if err != nil {
+		return nil, err
+	}
+
+	userService := services.NewUserService()
+	authenticator, err := services.NewAuthenticatorFromEnv(userService)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &AuthHandler{
+		db:             database.DB,
+		keys:           keys,
+		sessionService: services.NewSessionService(),
+		userService:    userService,
+		authenticator:  authenticator,
+		mfaService:     services.NewMFAService(),
+		settings:       services.NewSettingsService(),
+		throttle:       services.NewLoginThrottleService(),
+		apiTokens:      services.NewAPITokenService(),
+		roles:          services.NewRoleService(),
+		groups:         services.NewGroupService(),
+		oidcStates:     newOIDCStateStore(),
+		oidcMFATickets: newOIDCStateStore(),
+	}
 
-func NewAuthHandler() *AuthHandler {
-	return &AuthHandler{
-		db: database.DB,
+	if cfg := services.LoadOIDCConfig(); cfg != nil {
+		h.oidc = services.NewOIDCProvider(cfg)
 	}
+
+	return h, nil
+}
+
+// JWKS 公开token验证公钥
+func (h *AuthHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	h.keys.JWKS(w, r)
 }
 
+// 统一的登录失败提示，不区分账户不存在、密码错误、非管理员或已禁用
+const invalidLoginMessage = "用户名或密码错误"
+
 // 管理员登录
 func (h *AuthHandler) AdminLogin(w http.ResponseWriter, r *http.Request) {
+	h.passwordLogin(w, r, true)
+}
+
+// 用户登录
+func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	h.passwordLogin(w, r, false)
+}
+
+// passwordLogin 用户名密码登录的公共流程，adminOnly 为 true 时只允许拥有管理权限的账户
+func (h *AuthHandler) passwordLogin(w http.ResponseWriter, r *http.Request, adminOnly bool) {
 	var req LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "无效的请求格式", http.StatusBadRequest)
@@ -58,148 +125,189 @@ func (h *AuthHandler) AdminLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 查询用户
-	user := &models.User{}
-	query := `SELECT id, username, email, password, is_active, is_admin, 
-	          created_at, updated_at, COALESCE(container_id, '') as container_id 
-	          FROM users WHERE username = ? AND is_admin = 1`
-	
-	err := h.db.QueryRow(query, req.Username).Scan(
-		&user.ID, &user.Username, &user.Email, &user.Password,
-		&user.IsActive, &user.IsAdmin, &user.CreatedAt, &user.UpdatedAt,
-		&user.ContainerID,
-	)
-
+	// 按IP和用户名限流，锁定期间不再校验密码
+	ip := clientIP(r)
+	wait, err := h.throttle.Check(ip, req.Username)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "管理员账户不存在", http.StatusUnauthorized)
-		} else {
+		http.Error(w, "数据库查询失败", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "登录失败次数过多，请稍后再试", http.StatusTooManyRequests)
+		return
+	}
+
+	// 通过认证后端验证用户名密码
+	user, err := h.authenticator.Authenticate(req.Username, req.Password)
+	if err == services.ErrIdentityNotLinked {
+		// 目录账户与平台上未关联的同名账户冲突，需管理员确认后关联
+		http.Error(w, "该用户名已被平台现有账户使用，请联系管理员关联目录账户", http.StatusForbidden)
+		return
+	}
+	if err == services.ErrInvalidExternalUsername {
+		http.Error(w, "目录中的用户名不符合平台用户名格式（字母、数字、下划线、点和连字符，以字母或数字开头，不超过50位），无法自动开通账户，请联系管理员创建账户并关联目录账户", http.StatusForbidden)
+		return
+	}
+	if err != nil && err != services.ErrInvalidCredentials {
+		http.Error(w, "认证服务不可用", http.StatusInternalServerError)
+		return
+	}
+
+	if err == nil && user.CanLogin() {
+		if user.Permissions, err = h.roles.UserPermissions(user.ID, user.IsAdmin); err != nil {
 			http.Error(w, "数据库查询失败", http.StatusInternalServerError)
+			return
 		}
-		return
 	}
 
-	// 检查用户是否激活
-	if !user.IsActive {
-		http.Error(w, "管理员账户已被禁用", http.StatusUnauthorized)
+	if err != nil || !user.CanLogin() || (adminOnly && len(user.Permissions) == 0) {
+		h.throttle.RecordFailure(ip, req.Username)
+		http.Error(w, invalidLoginMessage, http.StatusUnauthorized)
 		return
 	}
 
-	// 验证密码
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-		http.Error(w, "用户名或密码错误", http.StatusUnauthorized)
+	if !h.verifySecondFactor(w, ip, user, req.TOTPCode) {
 		return
 	}
 
-	// 生成JWT token
-	token, err := h.generateToken(user)
+	h.throttle.RecordSuccess(req.Username)
+	h.issueTokens(w, r, user)
+}
+
+// verifySecondFactor 已启用两步验证的账户必须提供验证码或恢复码
+// 缺少或错误时响应头带 X-MFA-Required，前端据此显示验证码输入框
+func (h *AuthHandler) verifySecondFactor(w http.ResponseWriter, ip string, user *models.User, code string) bool {
+	enabled, err := h.mfaService.IsEnabled(user.ID)
 	if err != nil {
-		http.Error(w, "生成token失败", http.StatusInternalServerError)
-		return
+		http.Error(w, "数据库查询失败", http.StatusInternalServerError)
+		return false
+	}
+	if !enabled {
+		return true
 	}
 
-	// 清除密码hash，不返回给客户端
-	user.Password = ""
+	if code == "" {
+		w.Header().Set("X-MFA-Required", "true")
+		http.Error(w, "需要两步验证码", http.StatusUnauthorized)
+		return false
+	}
 
-	response := LoginResponse{
-		Token: token,
-		User:  user,
+	if err := h.mfaService.Verify(user.ID, code); err != nil {
+		h.throttle.RecordFailure(ip, user.Username)
+		w.Header().Set("X-MFA-Required", "true")
+		http.Error(w, "两步验证码错误", http.StatusUnauthorized)
+		return false
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	return true
 }
 
-// 用户登录
-func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
-	var req LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+// issueTokens 创建新会话并返回访问token和刷新token
+func (h *AuthHandler) issueTokens(w http.ResponseWriter, r *http.Request, user *models.User) {
+	sessionID, refreshToken, err := h.sessionService.CreateSession(user.ID, r.UserAgent(), clientIP(r))
+	if err != nil {
+		http.Error(w, "创建会话失败", http.StatusInternalServerError)
 		return
 	}
 
-	if req.Username == "" || req.Password == "" {
-		http.Error(w, "用户名和密码不能为空", http.StatusBadRequest)
+	h.writeTokens(w, user, sessionID, refreshToken)
+}
+
+func (h *AuthHandler) writeTokens(w http.ResponseWriter, user *models.User, sessionID, refreshToken string) {
+	response, err := h.loginResponse(user, sessionID, refreshToken)
+	if err != nil {
+		http.Error(w, "生成token失败", http.StatusInternalServerError)
 		return
 	}
 
-	// 查询用户
-	user := &models.User{}
-	query := `SELECT id, username, email, password, is_active, is_admin, 
-	          created_at, updated_at, COALESCE(container_id, '') as container_id 
-	          FROM users WHERE username = ?`
-	
-	err := h.db.QueryRow(query, req.Username).Scan(
-		&user.ID, &user.Username, &user.Email, &user.Password,
-		&user.IsActive, &user.IsAdmin, &user.CreatedAt, &user.UpdatedAt,
-		&user.ContainerID,
-	)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
 
+func (h *AuthHandler) loginResponse(user *models.User, sessionID, refreshToken string) (*LoginResponse, error) {
+	// 生成JWT token
+	token, err := h.generateToken(user, sessionID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "用户名或密码错误", http.StatusUnauthorized)
-		} else {
-			http.Error(w, "数据库查询失败", http.StatusInternalServerError)
+		return nil, err
+	}
+
+	if user.Permissions == nil {
+		if user.Permissions, err = h.roles.UserPermissions(user.ID, user.IsAdmin); err != nil {
+			return nil, err
 		}
-		return
 	}
 
-	// 检查用户是否激活
-	if !user.IsActive {
-		http.Error(w, "用户账户已被禁用", http.StatusUnauthorized)
+	// 清除密码hash，不返回给客户端
+	user.Password = ""
+
+	return &LoginResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(services.AccessTokenTTL.Seconds()),
+		User:         user,
+	}, nil
+}
+
+// 用刷新token换取新的访问token，刷新token同时轮换
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
 		return
 	}
 
-	// 验证密码
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-		http.Error(w, "用户名或密码错误", http.StatusUnauthorized)
+	userID, sessionID, refreshToken, err := h.sessionService.Refresh(req.RefreshToken)
+	if err != nil {
+		if err == services.ErrInvalidRefreshToken {
+			http.Error(w, "刷新token无效或已过期", http.StatusUnauthorized)
+		} else {
+			http.Error(w, "刷新会话失败", http.StatusInternalServerError)
+		}
 		return
 	}
 
-	// 生成JWT token
-	token, err := h.generateToken(user)
-	if err != nil {
-		http.Error(w, "生成token失败", http.StatusInternalServerError)
+	user, err := h.userService.GetUserByID(userID)
+	if err != nil || !user.CanLogin() {
+		h.sessionService.RevokeSession(sessionID)
+		http.Error(w, "用户账户已被禁用", http.StatusUnauthorized)
 		return
 	}
 
-	// 清除密码hash，不返回给客户端
-	user.Password = ""
+	h.writeTokens(w, user, sessionID, refreshToken)
+}
 
-	response := LoginResponse{
-		Token: token,
-		User:  user,
+// 退出登录，吊销当前会话
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFrom(r.Context())
+	if err := h.sessionService.RevokeSession(principal.SessionID); err != nil {
+		http.Error(w, "退出登录失败", http.StatusInternalServerError)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	w.WriteHeader(http.StatusOK)
 }
 
 // 生成JWT token
-func (h *AuthHandler) generateToken(user *models.User) (string, error) {
+func (h *AuthHandler) generateToken(user *models.User, sessionID string) (string, error) {
 	claims := Claims{
-		UserID:   user.ID,
-		Username: user.Username,
-		IsAdmin:  user.IsAdmin,
+		UserID:    user.ID,
+		Username:  user.Username,
+		IsAdmin:   user.IsAdmin,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)), // 24小时过期
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(services.AccessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return h.keys.Sign(claims)
 }
 
 // 验证JWT token
 func (h *AuthHandler) ValidateToken(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, h.keys.Keyfunc)
 
 	if err != nil {
 		return nil, err
@@ -229,29 +337,124 @@ func (h *AuthHandler) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
 		}
 
 		tokenString := parts[1]
-		claims, err := h.ValidateToken(tokenString)
+		var principal *Principal
+		if services.IsAPIToken(tokenString) {
+			token, err := h.apiTokens.Authenticate(tokenString, clientIP(r))
+			if err != nil {
+				http.Error(w, "无效的token", http.StatusUnauthorized)
+				return
+			}
+
+			// 个人访问令牌只能访问其权限范围覆盖的接口
+			scope := requiredScope(r)
+			if scope == "" || !token.HasScope(scope) {
+				http.Error(w, "访问令牌权限不足", http.StatusForbidden)
+				return
+			}
+
+			principal = &Principal{UserID: token.UserID, APIToken: token}
+		} else {
+			claims, err := h.ValidateToken(tokenString)
+			if err != nil {
+				http.Error(w, "无效的token", http.StatusUnauthorized)
+				return
+			}
+
+			active, err := h.sessionService.IsSessionActive(claims.SessionID, claims.UserID)
+			if err != nil || !active {
+				http.Error(w, "会话已失效，请重新登录", http.StatusUnauthorized)
+				return
+			}
+
+			principal = &Principal{UserID: claims.UserID, SessionID: claims.SessionID}
+		}
+
+		// 每次请求都核对账户状态，禁用、到期、删除或降权立即生效
+		var isActive bool
+		var mustChangePassword bool
+		err := h.db.QueryRow(`SELECT u.username, u.is_active AND u.deleted_at IS NULL AND (u.expires_at IS NULL OR u.expires_at > ?),
+			u.is_admin, u.must_change_password, COALESCE(t.enabled, FALSE)
+			FROM users u LEFT JOIN user_totp t ON t.user_id = u.id
+			WHERE u.id = ?`, time.Now(), principal.UserID).Scan(&principal.Username, &isActive, &principal.IsAdmin,
+			&mustChangePassword, &principal.MFAEnabled)
+		if err != nil || !isActive {
+			http.Error(w, "用户账户不存在或已被禁用", http.StatusUnauthorized)
+			return
+		}
+
+		// 使用初始密码或管理员重置的密码时，只能查看本人资料、修改密码或退出
+		if mustChangePassword && !passwordChangeAllowed(r) {
+			w.Header().Set("X-Password-Change-Required", "true")
+			http.Error(w, "请先修改密码", http.StatusForbidden)
+			return
+		}
+
+		principal.Permissions, err = h.roles.UserPermissions(principal.UserID, principal.IsAdmin)
 		if err != nil {
-			http.Error(w, "无效的token", http.StatusUnauthorized)
+			http.Error(w, "权限检查失败", http.StatusInternalServerError)
 			return
 		}
 
 		// 将用户信息添加到请求上下文
-		r.Header.Set("X-User-ID", strconv.Itoa(claims.UserID))
-		r.Header.Set("X-Username", claims.Username)
-		r.Header.Set("X-Is-Admin", strconv.FormatBool(claims.IsAdmin))
-
-		next(w, r)
+		next(w, r.WithContext(WithPrincipal(r.Context(), principal)))
 	}
 }
 
-// 只允许管理员访问的中间件
-func (h *AuthHandler) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+// RequirePermission 要求调用者拥有指定权限点
+func (h *AuthHandler) RequirePermission(perm string, next http.HandlerFunc) http.HandlerFunc {
 	return h.RequireAuth(func(w http.ResponseWriter, r *http.Request) {
-		isAdmin := r.Header.Get("X-Is-Admin") == "true"
-		if !isAdmin {
-			http.Error(w, "需要管理员权限", http.StatusForbidden)
+		principal, _ := PrincipalFrom(r.Context())
+		if !principal.Can(perm) {
+			http.Error(w, "权限不足", http.StatusForbidden)
+			return
+		}
+		if !h.adminMFASatisfied(principal) {
+			w.Header().Set("X-MFA-Enrollment-Required", "true")
+			http.Error(w, "管理员账户必须先启用两步验证", http.StatusForbidden)
 			return
 		}
 		next(w, r)
 	})
-}
\ No newline at end of file
+}
+
+// adminMFASatisfied 启用"管理员强制两步验证"策略后，未绑定的账户不能使用任何管理权限
+// 两步验证相关接口只需 RequireAuth，因此不受影响，管理员仍可完成绑定
+func (h *AuthHandler) adminMFASatisfied(p *Principal) bool {
+	if p.MFAEnabled {
+		return true
+	}
+	required, err := h.settings.GetBool(services.SettingRequireAdmin2FA, false)
+	if err != nil {
+		// 无法读取策略时按最严格处理
+		return false
+	}
+	return !required
+}
+
+// passwordChangeAllowed 必须修改密码的账户仍可访问的接口
+func passwordChangeAllowed(r *http.Request) bool {
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/me",
+		r.Method == http.MethodPut && r.URL.Path == "/api/me/password",
+		r.Method == http.MethodPost && r.URL.Path == "/api/logout":
+		return true
+	}
+	return false
+}
+
+// clientIP 获取客户端IP，仅在 TRUST_PROXY_HEADERS=true（位于nginx之后）时信任代理头
+func clientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY_HEADERS") == "true" {
+		if ip := r.Header.Get("X-Real-IP"); ip != "" {
+			return ip
+		}
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			return strings.TrimSpace(strings.Split(fwd, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gpu-dev-platform/models"
+	"github.com/gorilla/mux"
+)
+
+// OwnerResolver 从请求中解析出目标资源所属的用户ID
+// found 为 false 表示资源不存在
+type OwnerResolver func(r *http.Request) (ownerID int, found bool, err error)
+
+// RequireOwner 要求调用者是资源的所有者或所有者的组管理员，拥有 perm 权限的账户不受限制
+// 所有按用户划分的路由都应该通过它做归属检查
+func (h *AuthHandler) RequireOwner(perm string, resolve OwnerResolver, next http.HandlerFunc) http.HandlerFunc {
+	return h.RequireAuth(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := PrincipalFrom(r.Context())
+		if principal.Can(perm) && h.adminMFASatisfied(principal) {
+			next(w, r)
+			return
+		}
+
+		ownerID, found, err := resolve(r)
+		if err != nil {
+			http.Error(w, "权限检查失败", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "资源不存在", http.StatusNotFound)
+			return
+		}
+
+		if ownerID != principal.UserID {
+			managed, err := h.managedByGroupAdmin(principal, perm, ownerID)
+			if err != nil {
+				http.Error(w, "权限检查失败", http.StatusInternalServerError)
+				return
+			}
+			if !managed {
+				http.Error(w, "无权访问该资源", http.StatusForbidden)
+				return
+			}
+		}
+
+		next(w, r)
+	})
+}
+
+// RequireManaged 要求调用者拥有全局 perm 权限，或是资源所有者所在组的组管理员
+// 与 RequireOwner 不同，资源所有者本人不能通过这里的检查
+func (h *AuthHandler) RequireManaged(perm string, resolve OwnerResolver, next http.HandlerFunc) http.HandlerFunc {
+	return h.RequireAuth(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := PrincipalFrom(r.Context())
+		if !principal.Can(perm) {
+			ownerID, found, err := resolve(r)
+			if err != nil {
+				http.Error(w, "权限检查失败", http.StatusInternalServerError)
+				return
+			}
+			if !found {
+				http.Error(w, "资源不存在", http.StatusNotFound)
+				return
+			}
+
+			managed, err := h.managedByGroupAdmin(principal, perm, ownerID)
+			if err != nil {
+				http.Error(w, "权限检查失败", http.StatusInternalServerError)
+				return
+			}
+			if !managed {
+				http.Error(w, "权限不足", http.StatusForbidden)
+				return
+			}
+		}
+
+		if !h.adminMFASatisfied(principal) {
+			w.Header().Set("X-MFA-Enrollment-Required", "true")
+			http.Error(w, "管理员账户必须先启用两步验证", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// RequireGroupAdmin 要求调用者拥有全局 perm 权限，或是路径参数指定组的组管理员
+func (h *AuthHandler) RequireGroupAdmin(perm, param string, next http.HandlerFunc) http.HandlerFunc {
+	return h.RequireAuth(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := PrincipalFrom(r.Context())
+		if !principal.Can(perm) {
+			groupID, err := strconv.Atoi(mux.Vars(r)[param])
+			if err != nil {
+				http.Error(w, "Invalid group ID", http.StatusBadRequest)
+				return
+			}
+
+			isAdmin, err := h.groups.IsGroupAdmin(principal.UserID, groupID)
+			if err != nil {
+				http.Error(w, "权限检查失败", http.StatusInternalServerError)
+				return
+			}
+			if !isAdmin {
+				http.Error(w, "权限不足", http.StatusForbidden)
+				return
+			}
+		}
+
+		if !h.adminMFASatisfied(principal) {
+			w.Header().Set("X-MFA-Enrollment-Required", "true")
+			http.Error(w, "管理员账户必须先启用两步验证", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// managedByGroupAdmin 调用者是否以组管理员身份对目标用户拥有 perm 权限
+func (h *AuthHandler) managedByGroupAdmin(p *Principal, perm string, ownerID int) (bool, error) {
+	allowed := false
+	for _, granted := range models.GroupAdminPermissions {
+		if granted == perm {
+			allowed = true
+		}
+	}
+	if !allowed || !h.adminMFASatisfied(p) {
+		return false, nil
+	}
+	managed, err := h.groups.ManagesUser(p.UserID, ownerID)
+	if err != nil || !managed {
+		return false, err
+	}
+
+	// 与 RequireUserPermission 相同，组管理员不能操作权限高于自己的组员
+	outranks, err := h.outranks(p, ownerID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !outranks, nil
+}
+
+// outranks 目标用户是否拥有调用者没有的权限，用户不存在时返回 sql.ErrNoRows
+func (h *AuthHandler) outranks(p *Principal, targetID int) (bool, error) {
+	var isAdmin bool
+	if err := h.db.QueryRow("SELECT is_admin FROM users WHERE id = ?", targetID).Scan(&isAdmin); err != nil {
+		return false, err
+	}
+
+	targetPermissions, err := h.roles.UserPermissions(targetID, isAdmin)
+	if err != nil {
+		return false, err
+	}
+	for _, perm := range targetPermissions {
+		if !p.Can(perm) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RequireUserPermission 要求调用者拥有 perm 权限，且路径参数指定的目标用户权限不超过调用者
+// 防止 user-admin 等角色通过重置密码、吊销令牌等操作接管权限更高的账户
+func (h *AuthHandler) RequireUserPermission(perm, param string, next http.HandlerFunc) http.HandlerFunc {
+	return h.RequirePermission(perm, func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := PrincipalFrom(r.Context())
+
+		targetID, err := strconv.Atoi(mux.Vars(r)[param])
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		outranks, err := h.outranks(principal, targetID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "权限检查失败", http.StatusInternalServerError)
+			return
+		}
+		if outranks {
+			http.Error(w, "不能操作权限高于自己的账户", http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	})
+}
+
+// ContainerOwner 按路径参数中的容器ID查找容器所有者
+func (h *AuthHandler) ContainerOwner(param string) OwnerResolver {
+	return func(r *http.Request) (int, bool, error) {
+		var userID int
+		err := h.db.QueryRow("SELECT user_id FROM containers WHERE id = ?", mux.Vars(r)[param]).Scan(&userID)
+		if err == sql.ErrNoRows {
+			return 0, false, nil
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		return userID, true, nil
+	}
+}
+
+// UserOwner 路径参数本身就是用户ID，用户只能访问自己
+func (h *AuthHandler) UserOwner(param string) OwnerResolver {
+	return func(r *http.Request) (int, bool, error) {
+		userID, err := strconv.Atoi(mux.Vars(r)[param])
+		if err != nil {
+			return 0, false, nil
+		}
+		return userID, true, nil
+	}
+}
+
+// requiredScope 返回个人访问令牌访问该请求所需的权限范围
+// 未列出的接口（令牌管理、两步验证、平台设置、角色管理等）返回空字符串，令牌一律不能访问
+func requiredScope(r *http.Request) string {
+	path := strings.TrimPrefix(r.URL.Path, "/api")
+	write := r.Method != http.MethodGet
+
+	switch {
+	case strings.HasPrefix(path, "/containers"),
+		strings.HasPrefix(path, "/me/container"),
+		strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/container"),
+		path == "/ports",
+		strings.HasPrefix(path, "/gpus"),
+		strings.HasPrefix(path, "/gpu-leases"),
+		strings.HasPrefix(path, "/me/gpu-"),
+		strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/ports"):
+		if write {
+			return models.ScopeContainersWrite
+		}
+		return models.ScopeContainersRead
+
+	case path == "/me" || path == "/me/ports" || path == "/me/groups":
+		if write {
+			return ""
+		}
+		return models.ScopeProfileRead
+
+	case path == "/users" || strings.HasPrefix(path, "/users/"):
+		// 会话、令牌、两步验证、角色、导入凭据报告等账户安全操作不开放给令牌
+		if strings.Contains(path, "/sessions") || strings.Contains(path, "/tokens") || strings.HasSuffix(path, "/roles") ||
+			strings.HasSuffix(path, "/2fa") || strings.HasSuffix(path, "/password") || strings.HasSuffix(path, "/report") ||
+			strings.HasSuffix(path, "/identities") {
+			return ""
+		}
+		if write {
+			return models.ScopeUsersWrite
+		}
+		return models.ScopeUsersRead
+	}
+
+	return ""
+}
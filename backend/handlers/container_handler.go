@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 
+	"gpu-dev-platform/models"
 	"gpu-dev-platform/services"
 	"github.com/gorilla/mux"
 )
@@ -12,6 +14,7 @@ import (
 type ContainerHandler struct {
 	containerService *services.ContainerService
 	userService      *services.UserService
+	audit            *services.AuditService
 }
 
 func NewContainerHandler() (*ContainerHandler, error) {
@@ -23,6 +26,7 @@ func NewContainerHandler() (*ContainerHandler, error) {
 	return &ContainerHandler{
 		containerService: containerService,
 		userService:      services.NewUserService(),
+		audit:            services.NewAuditService(),
 	}, nil
 }
 
@@ -30,6 +34,42 @@ type CreateContainerRequest struct {
 	UserID     int    `json:"user_id"`
 	GPUDevices string `json:"gpu_devices"`
 	Password   string `json:"password,omitempty"` // 服务登录密码
+	GPUMode    string `json:"gpu_mode,omitempty"` // exclusive 或 shared，留空使用 GPU_DEFAULT_MODE
+	// 不指定 gpu_devices 时按数量、型号和显存自动分配 GPU
+	GPUCount    int     `json:"gpu_count,omitempty"`
+	GPUModel    string  `json:"gpu_model,omitempty"`
+	MinMemoryGB float64 `json:"min_memory_gb,omitempty"`
+	// cpus、memory、swap、pids_limit、shm_size，留空使用平台默认值
+	models.ContainerResources
+}
+
+// ResizeContainerRequest 留空的项保持当前值。修改 shm_size 或 gpu_devices 需要重建容器，
+// 此时必须设置 recreate 并提供新的服务登录密码
+type ResizeContainerRequest struct {
+	models.ContainerResources
+	GPUDevices *string `json:"gpu_devices,omitempty"`
+	GPUMode    string  `json:"gpu_mode,omitempty"`
+	Recreate   bool    `json:"recreate,omitempty"`
+	Password   string  `json:"password,omitempty"`
+}
+
+// RecreateRequiredResponse 告诉调用方哪些修改需要重建容器，确认后带 recreate 重新提交
+type RecreateRequiredResponse struct {
+	Error            string   `json:"error"`
+	RequiresRecreate bool     `json:"requires_recreate"`
+	Fields           []string `json:"fields"`
+}
+
+// GPUConflictResponse 请求的 GPU 已被占用，列出占用它们的容器
+type GPUConflictResponse struct {
+	Error     string                  `json:"error"`
+	Conflicts []*models.GPUAssignment `json:"conflicts"`
+}
+
+// ResourceLimitsResponse 容器资源限制的默认值和上限
+type ResourceLimitsResponse struct {
+	Defaults *models.ContainerResources `json:"defaults"`
+	Maxima   *models.ContainerResources `json:"maxima"`
 }
 
 func (h *ContainerHandler) CreateContainer(w http.ResponseWriter, r *http.Request) {
@@ -51,11 +91,41 @@ func (h *ContainerHandler) CreateContainer(w http.ResponseWriter, r *http.Reques
 		return
 	}
 	
+	if err := services.DefaultPasswordPolicy().Validate(req.Password, user.Username); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	password := req.Password
 	
-	container, err := h.containerService.CreateContainerWithPassword(user, req.GPUDevices, password)
+	container, err := h.containerService.CreateContainerWithPassword(user, services.GPURequest{
+		Devices:     req.GPUDevices,
+		Mode:        req.GPUMode,
+		Count:       req.GPUCount,
+		Model:       req.GPUModel,
+		MinMemoryMB: int(req.MinMemoryGB * 1024),
+	}, password, &req.ContainerResources)
+	event := newAuditEvent(r, models.AuditContainerCreate, "container", "")
+	event.SetChange("user_id", nil, user.ID)
+	event.SetChange("gpu_devices", nil, req.GPUDevices)
+	event.SetChange("gpu_mode", nil, req.GPUMode)
+	if req.GPUCount > 0 || req.GPUModel != "" || req.MinMemoryGB > 0 {
+		event.SetChange("gpu_request", nil, map[string]interface{}{
+			"gpu_count": req.GPUCount, "gpu_model": req.GPUModel, "min_memory_gb": req.MinMemoryGB,
+		})
+	}
+	if container != nil {
+		event.TargetID = container.ID
+		// 记录实际使用的 GPU，自动分配时为调度选出的 UUID
+		event.SetChange("gpu_devices", nil, container.GPUDevices)
+		event.SetChange("resources", nil, models.ContainerResources{
+			CPUs: container.CPULimit, Memory: container.MemoryLimit, Swap: container.SwapLimit,
+			PidsLimit: container.PidsLimit, ShmSize: container.ShmSize,
+		})
+	}
+	h.audit.Record(event, err)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeContainerError(w, err)
 		return
 	}
 
@@ -63,6 +133,114 @@ func (h *ContainerHandler) CreateContainer(w http.ResponseWriter, r *http.Reques
 	json.NewEncoder(w).Encode(container)
 }
 
+// GetResourceLimits 创建容器时可设置的资源限制及其默认值
+func (h *ContainerHandler) GetResourceLimits(w http.ResponseWriter, r *http.Request) {
+	policy := services.DefaultResourcePolicy()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ResourceLimitsResponse{
+		Defaults: policy.Defaults(),
+		Maxima:   policy.Maxima(),
+	})
+}
+
+// ResizeContainer 调整容器资源，CPU、内存、交换空间和进程数在线生效
+func (h *ContainerHandler) ResizeContainer(w http.ResponseWriter, r *http.Request) {
+	containerID := mux.Vars(r)["id"]
+
+	var req ResizeContainerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	before, err := h.containerService.GetContainerByID(containerID)
+	if err != nil {
+		http.Error(w, "Container not found", http.StatusNotFound)
+		return
+	}
+
+	if req.Recreate && req.Password != "" {
+		user, err := h.userService.GetUserByID(before.UserID)
+		if err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if err := services.DefaultPasswordPolicy().Validate(req.Password, user.Username); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	resize := &services.ResizeRequest{
+		Resources:  req.ContainerResources,
+		GPUDevices: req.GPUDevices,
+		GPUMode:    req.GPUMode,
+		Recreate:   req.Recreate,
+		Password:   req.Password,
+	}
+	if principal, ok := PrincipalFrom(r.Context()); ok {
+		resize.ActorID = principal.UserID
+		resize.ActorName = principal.Username
+	}
+
+	result, err := h.containerService.ResizeContainer(containerID, resize)
+
+	var recreateErr *services.RecreateRequiredError
+	if errors.As(err, &recreateErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(RecreateRequiredResponse{
+			Error:            recreateErr.Error(),
+			RequiresRecreate: true,
+			Fields:           recreateErr.Fields,
+		})
+		return
+	}
+
+	event := newAuditEvent(r, models.AuditContainerResize, "container", containerID)
+	if result != nil && result.Container != nil {
+		after := result.Container
+		event.SetChange("cpu_limit", before.CPULimit, after.CPULimit)
+		event.SetChange("memory_limit", before.MemoryLimit, after.MemoryLimit)
+		event.SetChange("swap_limit", before.SwapLimit, after.SwapLimit)
+		event.SetChange("pids_limit", before.PidsLimit, after.PidsLimit)
+		event.SetChange("shm_size", before.ShmSize, after.ShmSize)
+		event.SetChange("gpu_devices", before.GPUDevices, after.GPUDevices)
+		event.SetChange("gpu_mode", before.GPUMode, after.GPUMode)
+		event.SetChange("container_id", before.ID, after.ID)
+	}
+	if result != nil && result.Operation != nil {
+		event.SetChange("operation_id", nil, result.Operation.ID)
+	}
+	h.audit.Record(event, err)
+	if err != nil {
+		writeContainerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// writeContainerError 创建、调整容器失败时按错误类型返回状态码，GPU 冲突附带占用详情
+func writeContainerError(w http.ResponseWriter, err error) {
+	var conflictErr *services.GPUConflictError
+	switch {
+	case errors.As(err, &conflictErr):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(GPUConflictResponse{Error: conflictErr.Error(), Conflicts: conflictErr.Conflicts})
+	case errors.Is(err, services.ErrInvalidResources), errors.Is(err, services.ErrRecreatePasswordRequired),
+		errors.Is(err, services.ErrUnknownGPU), errors.Is(err, services.ErrInvalidGPUMode),
+		errors.Is(err, services.ErrInvalidGPURequest):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, services.ErrInsufficientGPUs):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 func (h *ContainerHandler) GetContainer(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	containerID := vars["id"]
@@ -110,7 +288,9 @@ func (h *ContainerHandler) StartContainer(w http.ResponseWriter, r *http.Request
 	vars := mux.Vars(r)
 	containerID := vars["id"]
 
-	if err := h.containerService.StartContainer(containerID); err != nil {
+	err := h.containerService.StartContainer(containerID)
+	h.audit.Record(newAuditEvent(r, models.AuditContainerStart, "container", containerID), err)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -122,7 +302,9 @@ func (h *ContainerHandler) StopContainer(w http.ResponseWriter, r *http.Request)
 	vars := mux.Vars(r)
 	containerID := vars["id"]
 
-	if err := h.containerService.StopContainer(containerID); err != nil {
+	err := h.containerService.StopContainer(containerID)
+	h.audit.Record(newAuditEvent(r, models.AuditContainerStop, "container", containerID), err)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -134,7 +316,9 @@ func (h *ContainerHandler) RemoveContainer(w http.ResponseWriter, r *http.Reques
 	vars := mux.Vars(r)
 	containerID := vars["id"]
 
-	if err := h.containerService.RemoveContainer(containerID); err != nil {
+	err := h.containerService.RemoveContainer(containerID)
+	h.audit.Record(newAuditEvent(r, models.AuditContainerDelete, "container", containerID), err)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -197,13 +381,26 @@ func (h *ContainerHandler) ResetContainerPassword(w http.ResponseWriter, r *http
 		return
 	}
 
-	if len(req.Password) < 6 {
-		http.Error(w, "密码长度至少6位", http.StatusBadRequest)
+	container, err := h.containerService.GetContainerByID(containerID)
+	if err != nil {
+		http.Error(w, "Container not found", http.StatusNotFound)
+		return
+	}
+	owner, err := h.userService.GetUserByID(container.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if err := services.DefaultPasswordPolicy().Validate(req.Password, owner.Username); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// 重置容器服务密码
-	if err := h.containerService.ResetContainerPassword(containerID, req.Password); err != nil {
+	err = h.containerService.ResetContainerPassword(containerID, req.Password)
+	h.audit.Record(newAuditEvent(r, models.AuditContainerPasswordReset, "container", containerID), err)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gpu-dev-platform/services"
+)
+
+// GPUHandler GPU 清单和占用情况
+type GPUHandler struct {
+	gpus *services.GPUService
+}
+
+func NewGPUHandler() *GPUHandler {
+	return &GPUHandler{
+		gpus: services.DefaultGPUService(),
+	}
+}
+
+// ListGPUs 全部 GPU 及占用它们的容器
+func (h *GPUHandler) ListGPUs(w http.ResponseWriter, r *http.Request) {
+	gpus, err := h.gpus.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(gpus)
+}
+
+// RefreshGPUs 立即重新盘点 GPU，更换或增减显卡后使用
+func (h *GPUHandler) RefreshGPUs(w http.ResponseWriter, r *http.Request) {
+	if !h.gpus.Enabled() {
+		http.Error(w, "未配置 GPU 盘点 (GPU_INVENTORY_PROVIDER=none)", http.StatusBadRequest)
+		return
+	}
+	if err := h.gpus.Refresh(); err != nil {
+		http.Error(w, "GPU 盘点失败: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	h.ListGPUs(w, r)
+}
@@ -0,0 +1,347 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gpu-dev-platform/models"
+	"gpu-dev-platform/services"
+	"github.com/gorilla/mux"
+)
+
+// 日历默认显示的时间范围
+const gpuCalendarDefaultRange = 7 * 24 * time.Hour
+
+// GPULeaseHandler GPU 预约：管理员可以为任何用户预约和取消，普通用户只能操作自己的租约
+type GPULeaseHandler struct {
+	leases      *services.GPULeaseService
+	gpus        *services.GPUService
+	userService *services.UserService
+	audit       *services.AuditService
+}
+
+func NewGPULeaseHandler() *GPULeaseHandler {
+	return &GPULeaseHandler{
+		leases:      services.NewGPULeaseService(),
+		gpus:        services.DefaultGPUService(),
+		userService: services.NewUserService(),
+		audit:       services.NewAuditService(),
+	}
+}
+
+// CreateGPULeaseRequest 用 gpu_devices 指定 GPU，或用 gpu_count、gpu_model、min_memory_gb 由调度器挑选
+type CreateGPULeaseRequest struct {
+	UserID      int       `json:"user_id,omitempty"` // 管理员代为预约时指定，用户自助预约时忽略
+	GPUDevices  string    `json:"gpu_devices,omitempty"`
+	GPUCount    int       `json:"gpu_count,omitempty"`
+	GPUModel    string    `json:"gpu_model,omitempty"`
+	MinMemoryGB float64   `json:"min_memory_gb,omitempty"`
+	GPUMode     string    `json:"gpu_mode,omitempty"`
+	StartsAt    time.Time `json:"starts_at"`
+	EndsAt      time.Time `json:"ends_at"`
+	Note        string    `json:"note,omitempty"`
+}
+
+// LeaseConflictResponse 与请求时段冲突的租约
+type LeaseConflictResponse struct {
+	Error     string             `json:"error"`
+	Conflicts []*models.GPULease `json:"conflicts"`
+}
+
+// GPUCalendarEntry 一块 GPU 在时间范围内的预约情况，不包含其他用户的身份
+type GPUCalendarEntry struct {
+	UUID     string        `json:"uuid"`
+	Index    int           `json:"index"`
+	Model    string        `json:"model"`
+	MemoryMB int           `json:"memory_mb"`
+	Bookings []*GPUBooking `json:"bookings"`
+}
+
+type GPUBooking struct {
+	StartsAt time.Time `json:"starts_at"`
+	EndsAt   time.Time `json:"ends_at"`
+	Mode     string    `json:"mode"`
+	Status   string    `json:"status"`
+	Mine     bool      `json:"mine"`
+}
+
+// ListLeases 全部租约，可按 user_id、from、to 过滤，all=true 时包括已结束和已取消的
+func (h *GPULeaseHandler) ListLeases(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseLeaseFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if v := r.URL.Query().Get("user_id"); v != "" {
+		if filter.UserID, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "无效的 user_id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	h.writeLeases(w, filter)
+}
+
+// CreateLease 管理员为指定用户预约 GPU，不受自助预约的时长和提前量限制
+func (h *GPULeaseHandler) CreateLease(w http.ResponseWriter, r *http.Request) {
+	var req CreateGPULeaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userService.GetUserByID(req.UserID)
+	if err != nil || user.DeletedAt != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	h.createLease(w, r, user, &req, false)
+}
+
+// CancelLease 取消任意租约，进行中的租约会在下一轮检查时卸载 GPU
+func (h *GPULeaseHandler) CancelLease(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid lease ID", http.StatusBadRequest)
+		return
+	}
+
+	h.cancelLease(w, r, id, true)
+}
+
+// ListMyLeases 本人的租约，参数同 ListLeases
+func (h *GPULeaseHandler) ListMyLeases(w http.ResponseWriter, r *http.Request) {
+	principal, ok := PrincipalFrom(r.Context())
+	if !ok {
+		http.Error(w, "无效的用户身份", http.StatusUnauthorized)
+		return
+	}
+	filter, err := parseLeaseFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.UserID = principal.UserID
+
+	h.writeLeases(w, filter)
+}
+
+// CreateMyLease 用户为自己预约 GPU，时长和提前量受 GPU_LEASE_MAX_DURATION、GPU_LEASE_MAX_ADVANCE 限制
+func (h *GPULeaseHandler) CreateMyLease(w http.ResponseWriter, r *http.Request) {
+	if !h.leases.SelfServiceEnabled() {
+		http.Error(w, "平台未开放自助预约 GPU，请联系管理员", http.StatusForbidden)
+		return
+	}
+
+	principal, ok := PrincipalFrom(r.Context())
+	if !ok {
+		http.Error(w, "无效的用户身份", http.StatusUnauthorized)
+		return
+	}
+	user, err := h.userService.GetUserByID(principal.UserID)
+	if err != nil {
+		http.Error(w, "用户不存在", http.StatusUnauthorized)
+		return
+	}
+
+	var req CreateGPULeaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	h.createLease(w, r, user, &req, true)
+}
+
+// CancelMyLease 取消本人的租约
+func (h *GPULeaseHandler) CancelMyLease(w http.ResponseWriter, r *http.Request) {
+	principal, ok := PrincipalFrom(r.Context())
+	if !ok {
+		http.Error(w, "无效的用户身份", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid lease ID", http.StatusBadRequest)
+		return
+	}
+
+	lease, err := h.leases.Get(id)
+	if err != nil || lease.UserID != principal.UserID {
+		http.Error(w, "租约不存在", http.StatusNotFound)
+		return
+	}
+
+	h.cancelLease(w, r, id, false)
+}
+
+// GetGPUCalendar 各 GPU 在 from 至 to（默认未来 7 天）内的预约时段，供用户挑选空闲的时间
+func (h *GPULeaseHandler) GetGPUCalendar(w http.ResponseWriter, r *http.Request) {
+	principal, ok := PrincipalFrom(r.Context())
+	if !ok {
+		http.Error(w, "无效的用户身份", http.StatusUnauthorized)
+		return
+	}
+	filter, err := parseLeaseFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.From.IsZero() {
+		filter.From = time.Now()
+	}
+	if filter.To.IsZero() {
+		filter.To = filter.From.Add(gpuCalendarDefaultRange)
+	}
+	filter.IncludeFinished = false
+
+	gpus, err := h.gpus.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	leases, err := h.leases.List(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	calendar := []*GPUCalendarEntry{}
+	byUUID := make(map[string]*GPUCalendarEntry)
+	for _, gpu := range gpus {
+		if !gpu.Present {
+			continue
+		}
+		entry := &GPUCalendarEntry{UUID: gpu.UUID, Index: gpu.Index, Model: gpu.Model, MemoryMB: gpu.MemoryMB, Bookings: []*GPUBooking{}}
+		calendar = append(calendar, entry)
+		byUUID[gpu.UUID] = entry
+	}
+	for _, lease := range leases {
+		for _, uuid := range lease.GPUUUIDs {
+			if entry := byUUID[uuid]; entry != nil {
+				entry.Bookings = append(entry.Bookings, &GPUBooking{
+					StartsAt: lease.StartsAt,
+					EndsAt:   lease.EndsAt,
+					Mode:     lease.Mode,
+					Status:   lease.Status,
+					Mine:     lease.UserID == principal.UserID,
+				})
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(calendar)
+}
+
+func (h *GPULeaseHandler) createLease(w http.ResponseWriter, r *http.Request, user *models.User, req *CreateGPULeaseRequest, selfService bool) {
+	leaseReq := &services.LeaseRequest{
+		UserID: user.ID,
+		GPU: services.GPURequest{
+			Devices:     req.GPUDevices,
+			Mode:        req.GPUMode,
+			Count:       req.GPUCount,
+			Model:       req.GPUModel,
+			MinMemoryMB: int(req.MinMemoryGB * 1024),
+		},
+		StartsAt:    req.StartsAt,
+		EndsAt:      req.EndsAt,
+		Note:        req.Note,
+		SelfService: selfService,
+	}
+	if principal, ok := PrincipalFrom(r.Context()); ok {
+		leaseReq.ActorID = principal.UserID
+		leaseReq.ActorName = principal.Username
+	}
+
+	lease, err := h.leases.Create(leaseReq)
+	event := newAuditEvent(r, models.AuditGPULeaseCreate, "gpu_lease", "")
+	event.SetChange("user_id", nil, user.ID)
+	if lease != nil {
+		event.TargetID = strconv.Itoa(lease.ID)
+		event.SetChange("gpu_uuids", nil, lease.GPUUUIDs)
+		event.SetChange("mode", nil, lease.Mode)
+		event.SetChange("starts_at", nil, lease.StartsAt)
+		event.SetChange("ends_at", nil, lease.EndsAt)
+	}
+	h.audit.Record(event, err)
+	if err != nil {
+		writeLeaseError(w, err, !selfService)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(lease)
+}
+
+func (h *GPULeaseHandler) cancelLease(w http.ResponseWriter, r *http.Request, id int, admin bool) {
+	lease, err := h.leases.Cancel(id)
+	event := newAuditEvent(r, models.AuditGPULeaseCancel, "gpu_lease", strconv.Itoa(id))
+	if lease != nil {
+		event.SetChange("user_id", nil, lease.UserID)
+		event.SetChange("ends_at", nil, lease.EndsAt)
+	}
+	h.audit.Record(event, err)
+	if err != nil {
+		writeLeaseError(w, err, admin)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lease)
+}
+
+func (h *GPULeaseHandler) writeLeases(w http.ResponseWriter, filter services.LeaseFilter) {
+	leases, err := h.leases.List(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(leases)
+}
+
+func parseLeaseFilter(r *http.Request) (services.LeaseFilter, error) {
+	q := r.URL.Query()
+	filter := services.LeaseFilter{IncludeFinished: q.Get("all") == "true"}
+
+	var err error
+	if v := q.Get("from"); v != "" {
+		if filter.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, fmt.Errorf("from 必须是 RFC3339 时间格式")
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if filter.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, fmt.Errorf("to 必须是 RFC3339 时间格式")
+		}
+	}
+	return filter, nil
+}
+
+// writeLeaseError 按错误类型返回状态码。冲突详情包含其他用户的租约，只返回给管理员
+func writeLeaseError(w http.ResponseWriter, err error, admin bool) {
+	var conflictErr *services.LeaseConflictError
+	switch {
+	case errors.As(err, &conflictErr) && admin:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(LeaseConflictResponse{Error: conflictErr.Error(), Conflicts: conflictErr.Leases})
+	case errors.As(err, &conflictErr), errors.Is(err, services.ErrInsufficientGPUs), errors.Is(err, services.ErrLeaseFinished):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, services.ErrLeaseNotFound):
+		http.Error(w, "租约不存在", http.StatusNotFound)
+	case errors.Is(err, services.ErrInvalidLease), errors.Is(err, services.ErrInvalidGPURequest),
+		errors.Is(err, services.ErrUnknownGPU), errors.Is(err, services.ErrInvalidGPUMode):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gpu-dev-platform/models"
+	"gpu-dev-platform/services"
+	"github.com/gorilla/mux"
+)
+
+// GroupHandler 用户组和成员管理
+type GroupHandler struct {
+	groups      *services.GroupService
+	userService *services.UserService
+	audit       *services.AuditService
+}
+
+func NewGroupHandler() *GroupHandler {
+	return &GroupHandler{
+		groups:      services.NewGroupService(),
+		userService: services.NewUserService(),
+		audit:       services.NewAuditService(),
+	}
+}
+
+type CreateGroupRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type SetGroupMemberRequest struct {
+	IsAdmin bool `json:"is_admin"`
+}
+
+func (h *GroupHandler) ListGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := h.groups.ListGroups()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+// ListMyGroups 当前用户所属的组
+func (h *GroupHandler) ListMyGroups(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFrom(r.Context())
+	groups, err := h.groups.GroupsForUser(principal.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+func (h *GroupHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	var req CreateGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	group, err := h.groups.CreateGroup(strings.TrimSpace(req.Name), req.Description)
+	if err != nil {
+		if err == services.ErrInvalidGroupName {
+			http.Error(w, "组名只能包含小写字母、数字、下划线和连字符，且以字母或数字开头", http.StatusBadRequest)
+			return
+		}
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			http.Error(w, "组名已存在", http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(group)
+}
+
+func (h *GroupHandler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.groups.DeleteGroup(id); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *GroupHandler) ListMembers(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	members, err := h.groups.ListMembers(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// SetMember 添加成员或修改其组管理员标记，新的共享目录在成员重建容器后挂载
+func (h *GroupHandler) SetMember(w http.ResponseWriter, r *http.Request) {
+	groupID, userID, ok := memberVars(w, r)
+	if !ok {
+		return
+	}
+
+	var req SetGroupMemberRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if _, err := h.groups.GetGroup(groupID); err != nil {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+	user, err := h.userService.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	before, err := h.groups.GetMember(groupID, userID)
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	event := newAuditEvent(r, models.AuditGroupMemberSet, "group", strconv.Itoa(groupID))
+	err = h.groups.SetMember(groupID, userID, req.IsAdmin)
+	if err == nil {
+		event.SetChange("member."+user.Username, memberRole(before), memberRole(&models.GroupMember{IsAdmin: req.IsAdmin}))
+	}
+	h.audit.Record(event, err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *GroupHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	groupID, userID, ok := memberVars(w, r)
+	if !ok {
+		return
+	}
+
+	before, err := h.groups.GetMember(groupID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	event := newAuditEvent(r, models.AuditGroupMemberRemove, "group", strconv.Itoa(groupID))
+	err = h.groups.RemoveMember(groupID, userID)
+	if err == nil {
+		event.SetChange("member."+before.Username, memberRole(before), nil)
+	}
+	h.audit.Record(event, err)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Member not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// memberRole 成员在组内的身份，用于审计记录；不是成员时为空
+func memberRole(member *models.GroupMember) interface{} {
+	switch {
+	case member == nil:
+		return nil
+	case member.IsAdmin:
+		return "admin"
+	default:
+		return "member"
+	}
+}
+
+func memberVars(w http.ResponseWriter, r *http.Request) (groupID, userID int, ok bool) {
+	vars := mux.Vars(r)
+	groupID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	userID, err = strconv.Atoi(vars["userId"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return groupID, userID, true
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gpu-dev-platform/models"
+	"gpu-dev-platform/services"
+	"github.com/gorilla/mux"
+)
+
+// 上传文件大小上限
+const maxImportBodyBytes = 10 << 20
+
+// ImportHandler 批量开通用户
+type ImportHandler struct {
+	imports *services.ImportService
+	audit   *services.AuditService
+}
+
+func NewImportHandler() (*ImportHandler, error) {
+	containerService, err := services.NewContainerService()
+	if err != nil {
+		return nil, err
+	}
+
+	imports := services.NewImportService(containerService)
+	// 重启前未完成的任务无法继续执行
+	if err := imports.MarkInterruptedJobs(); err != nil {
+		return nil, err
+	}
+
+	return &ImportHandler{
+		imports: imports,
+		audit:   services.NewAuditService(),
+	}, nil
+}
+
+// ImportUsers 上传 CSV 或 JSON 用户列表，创建后台导入任务
+// 查询参数：format=csv|json（默认按 Content-Type 判断）, dry_run, create_containers
+func (h *ImportHandler) ImportUsers(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFrom(r.Context())
+	q := r.URL.Query()
+
+	opts := services.ImportOptions{
+		DryRun:           q.Get("dry_run") == "true",
+		CreateContainers: q.Get("create_containers") == "true",
+		ActorID:          principal.UserID,
+		ActorName:        principal.Username,
+		SourceIP:         clientIP(r),
+	}
+	if opts.CreateContainers && !principal.Can(models.PermContainersAdmin) {
+		http.Error(w, "创建容器需要 containers.manage 权限", http.StatusForbidden)
+		return
+	}
+
+	format := q.Get("format")
+	if format == "" {
+		format = "json"
+		if strings.Contains(r.Header.Get("Content-Type"), "csv") {
+			format = "csv"
+		}
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportBodyBytes)
+	var rows []*models.ImportRow
+	var lines []int
+	var err error
+	switch format {
+	case "csv":
+		rows, lines, err = h.imports.ParseImportCSV(body)
+	case "json":
+		rows, lines, err = h.imports.ParseImportJSON(body)
+	default:
+		http.Error(w, "format 只支持 csv 或 json", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 逐行创建的用户和容器另有各自的审计记录
+	job, err := h.imports.StartJob(rows, lines, opts)
+	event := newAuditEvent(r, models.AuditUserImport, "import_job", "")
+	event.SetChange("rows", nil, len(rows))
+	event.SetChange("dry_run", nil, opts.DryRun)
+	event.SetChange("create_containers", nil, opts.CreateContainers)
+	if job != nil {
+		event.TargetID = strconv.Itoa(job.ID)
+	}
+	h.audit.Record(event, err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// ListImportJobs 最近的导入任务
+func (h *ImportHandler) ListImportJobs(w http.ResponseWriter, r *http.Request) {
+	jobs, err := h.imports.ListJobs(50)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+// GetImportJob 任务进度和逐行结果
+func (h *ImportHandler) GetImportJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.loadJob(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// DownloadReport 下载包含初始密码的 CSV 报告，只有任务创建者或拥有 users.security 权限的账户可以下载
+func (h *ImportHandler) DownloadReport(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.loadJob(w, r)
+	if !ok {
+		return
+	}
+
+	principal, _ := PrincipalFrom(r.Context())
+	if job.CreatedBy != principal.UserID && !principal.Can(models.PermUsersSecurity) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if job.DryRun {
+		http.Error(w, "dry-run 任务没有凭据报告", http.StatusBadRequest)
+		return
+	}
+	if job.Status != models.ImportJobCompleted {
+		http.Error(w, "任务尚未完成", http.StatusConflict)
+		return
+	}
+	credentials, ok := h.imports.Credentials(job.ID)
+	if !ok {
+		http.Error(w, "凭据报告已过期或服务已重启，请为需要的用户重置密码", http.StatusGone)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=import-%d-credentials.csv", job.ID))
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"line", "username", "email", "status", "error", "user_id", "password",
+		"ssh_port", "container_id", "container_password"})
+	for _, res := range job.Results {
+		cred := credentials[res.Line]
+		userID, sshPort := "", ""
+		if res.UserID > 0 {
+			userID = strconv.Itoa(res.UserID)
+			sshPort = strconv.Itoa(cred.BasePort)
+		}
+		writer.Write([]string{strconv.Itoa(res.Line), res.Username, res.Email, res.Status, res.Error, userID,
+			cred.Password, sshPort, res.ContainerID, cred.ContainerPassword})
+	}
+	writer.Flush()
+}
+
+func (h *ImportHandler) loadJob(w http.ResponseWriter, r *http.Request) (*models.ImportJob, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["jobId"])
+	if err != nil {
+		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	job, err := h.imports.GetJob(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Import job not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return job, true
+}
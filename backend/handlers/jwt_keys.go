@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWT密钥配置
+//
+// 优先读取 JWT_KEYS_FILE 指定的JSON文件，格式如下：
+//
+//	{
+//	  "active_kid": "2024-06",
+//	  "keys": [
+//	    {"kid": "2024-06", "alg": "EdDSA", "private_key_file": "/run/secrets/jwt-ed25519.pem"},
+//	    {"kid": "2024-01", "alg": "HS256", "secret_file": "/run/secrets/jwt-old"}
+//	  ]
+//	}
+//
+// active_kid 用于签发新token，其余密钥只用于验证，轮换时把新密钥设为active、
+// 旧密钥保留到已签发的token全部过期即可。只有公钥的条目 (public_key_file) 只能验证。
+//
+// 未配置文件时使用 JWT_SECRET 或 JWT_SECRET_FILE 作为HS256密钥，kid取 JWT_KEY_ID (默认 "default")。
+// 两者都没有时生成随机密钥，重启后所有token失效。
+
+type jwtKeyConfig struct {
+	KID            string `json:"kid"`
+	Alg            string `json:"alg"`
+	Secret         string `json:"secret,omitempty"`
+	SecretFile     string `json:"secret_file,omitempty"`
+	PrivateKeyFile string `json:"private_key_file,omitempty"`
+	PublicKeyFile  string `json:"public_key_file,omitempty"`
+}
+
+type jwtKeysFile struct {
+	ActiveKID string         `json:"active_kid"`
+	Keys      []jwtKeyConfig `json:"keys"`
+}
+
+type jwtKey struct {
+	kid       string
+	method    jwt.SigningMethod
+	signKey   interface{} // 仅可签发的密钥才有
+	verifyKey interface{}
+}
+
+// KeySet 保存当前签发密钥和所有可用于验证的密钥
+type KeySet struct {
+	active *jwtKey
+	keys   map[string]*jwtKey
+}
+
+// LoadKeySet 从环境变量加载JWT密钥
+func LoadKeySet() (*KeySet, error) {
+	if path := os.Getenv("JWT_KEYS_FILE"); path != "" {
+		return loadKeySetFile(path)
+	}
+
+	kid := getEnv("JWT_KEY_ID", "default")
+
+	secret := os.Getenv("JWT_SECRET")
+	if path := os.Getenv("JWT_SECRET_FILE"); path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT_SECRET_FILE: %v", err)
+		}
+		secret = strings.TrimSpace(string(content))
+	}
+
+	if secret == "" {
+		log.Printf("WARNING: JWT_SECRET not configured, using a random key; tokens will not survive a restart")
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		secret = string(buf)
+	}
+
+	if len(secret) < 32 {
+		log.Printf("WARNING: JWT_SECRET is shorter than 32 bytes")
+	}
+
+	key := &jwtKey{
+		kid:       kid,
+		method:    jwt.SigningMethodHS256,
+		signKey:   []byte(secret),
+		verifyKey: []byte(secret),
+	}
+
+	return &KeySet{
+		active: key,
+		keys:   map[string]*jwtKey{kid: key},
+	}, nil
+}
+
+func loadKeySetFile(path string) (*KeySet, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT keys file: %v", err)
+	}
+
+	var cfg jwtKeysFile
+	if err := json.Unmarshal(content, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse JWT keys file: %v", err)
+	}
+
+	ks := &KeySet{keys: make(map[string]*jwtKey)}
+	for _, kc := range cfg.Keys {
+		if kc.KID == "" {
+			return nil, fmt.Errorf("JWT key without kid")
+		}
+		if _, dup := ks.keys[kc.KID]; dup {
+			return nil, fmt.Errorf("duplicate JWT kid %q", kc.KID)
+		}
+		key, err := parseKeyConfig(kc)
+		if err != nil {
+			return nil, fmt.Errorf("JWT key %q: %v", kc.KID, err)
+		}
+		ks.keys[kc.KID] = key
+	}
+
+	active, ok := ks.keys[cfg.ActiveKID]
+	if !ok {
+		return nil, fmt.Errorf("active_kid %q not found in JWT keys file", cfg.ActiveKID)
+	}
+	if active.signKey == nil {
+		return nil, fmt.Errorf("active JWT key %q has no private key", cfg.ActiveKID)
+	}
+	ks.active = active
+
+	return ks, nil
+}
+
+func parseKeyConfig(kc jwtKeyConfig) (*jwtKey, error) {
+	key := &jwtKey{kid: kc.KID}
+
+	switch kc.Alg {
+	case "HS256", "HS384", "HS512":
+		key.method = jwt.GetSigningMethod(kc.Alg)
+		secret := kc.Secret
+		if kc.SecretFile != "" {
+			content, err := os.ReadFile(kc.SecretFile)
+			if err != nil {
+				return nil, err
+			}
+			secret = strings.TrimSpace(string(content))
+		}
+		if secret == "" {
+			return nil, fmt.Errorf("missing secret")
+		}
+		key.signKey = []byte(secret)
+		key.verifyKey = []byte(secret)
+
+	case "RS256", "RS384", "RS512":
+		key.method = jwt.GetSigningMethod(kc.Alg)
+		if kc.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(kc.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			priv, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = priv
+			key.verifyKey = &priv.PublicKey
+		} else if kc.PublicKeyFile != "" {
+			pem, err := os.ReadFile(kc.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			pub, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.verifyKey = pub
+		} else {
+			return nil, fmt.Errorf("missing private_key_file or public_key_file")
+		}
+
+	case "EdDSA":
+		key.method = jwt.SigningMethodEdDSA
+		if kc.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(kc.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			priv, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			edPriv, ok := priv.(ed25519.PrivateKey)
+			if !ok {
+				return nil, fmt.Errorf("not an Ed25519 private key")
+			}
+			key.signKey = edPriv
+			key.verifyKey = edPriv.Public()
+		} else if kc.PublicKeyFile != "" {
+			pem, err := os.ReadFile(kc.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			pub, err := jwt.ParseEdPublicKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.verifyKey = pub
+		} else {
+			return nil, fmt.Errorf("missing private_key_file or public_key_file")
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported alg %q", kc.Alg)
+	}
+
+	return key, nil
+}
+
+// Sign 使用当前密钥签发token，并在头部写入kid
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.active.method, claims)
+	token.Header["kid"] = ks.active.kid
+	return token.SignedString(ks.active.signKey)
+}
+
+// Keyfunc 根据token头部的kid选择验证密钥
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid: %q", kid)
+	}
+	// 防止算法混淆攻击：token声明的算法必须与密钥配置一致
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.verifyKey, nil
+}
+
+// JWKS 以JWK Set格式公开非对称公钥，供其他内部服务验证token
+func (ks *KeySet) JWKS(w http.ResponseWriter, r *http.Request) {
+	keys := []map[string]string{}
+	for _, key := range ks.keys {
+		switch pub := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"kid": key.kid,
+				"alg": key.method.Alg(),
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			keys = append(keys, map[string]string{
+				"kty": "OKP",
+				"crv": "Ed25519",
+				"kid": key.kid,
+				"alg": "EdDSA",
+				"use": "sig",
+				"x":   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"gpu-dev-platform/models"
+	"gpu-dev-platform/services"
+	"github.com/gorilla/mux"
+)
+
+// MFAHandler 当前用户的TOTP两步验证管理
+type MFAHandler struct {
+	mfaService  *services.MFAService
+	userService *services.UserService
+	throttle    *services.LoginThrottleService
+	audit       *services.AuditService
+}
+
+func NewMFAHandler() *MFAHandler {
+	return &MFAHandler{
+		mfaService:  services.NewMFAService(),
+		userService: services.NewUserService(),
+		throttle:    services.NewLoginThrottleService(),
+		audit:       services.NewAuditService(),
+	}
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+type MFAEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"` // 前端可将其渲染为二维码供验证器App扫描
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func (h *MFAHandler) Status(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFrom(r.Context())
+
+	status, err := h.mfaService.Status(principal.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// Enroll 生成新的TOTP密钥，需调用 Confirm 验证后才生效
+func (h *MFAHandler) Enroll(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFrom(r.Context())
+
+	secret, uri, err := h.mfaService.BeginEnrollment(principal.UserID, principal.Username)
+	if err != nil {
+		if err == services.ErrMFAAlreadyEnabled {
+			http.Error(w, "已启用两步验证", http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MFAEnrollResponse{Secret: secret, OTPAuthURI: uri})
+}
+
+// Confirm 用验证器App生成的验证码确认绑定，返回一次性恢复码
+func (h *MFAHandler) Confirm(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFrom(r.Context())
+
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := h.mfaService.ConfirmEnrollment(principal.UserID, req.Code)
+	if err != nil {
+		switch err {
+		case services.ErrInvalidMFACode:
+			http.Error(w, "验证码错误", http.StatusBadRequest)
+		case services.ErrMFANotEnrolled:
+			http.Error(w, "请先生成两步验证密钥", http.StatusBadRequest)
+		case services.ErrMFAAlreadyEnabled:
+			http.Error(w, "已启用两步验证", http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// Disable 关闭两步验证，需提供当前验证码或恢复码
+func (h *MFAHandler) Disable(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFrom(r.Context())
+
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+
+	if !h.verifyCode(w, r, principal, req.Code) {
+		return
+	}
+
+	if err := h.mfaService.Disable(principal.UserID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码，旧恢复码全部作废
+func (h *MFAHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	principal, _ := PrincipalFrom(r.Context())
+
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+
+	if !h.verifyCode(w, r, principal, req.Code) {
+		return
+	}
+
+	codes, err := h.mfaService.RegenerateRecoveryCodes(principal.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// verifyCode 校验当前验证码或恢复码，与登录共用限流，防止借已登录的会话猜测验证码
+func (h *MFAHandler) verifyCode(w http.ResponseWriter, r *http.Request, principal *Principal, code string) bool {
+	ip := clientIP(r)
+	wait, err := h.throttle.Check(ip, principal.Username)
+	if err != nil {
+		http.Error(w, "数据库查询失败", http.StatusInternalServerError)
+		return false
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "失败次数过多，请稍后再试", http.StatusTooManyRequests)
+		return false
+	}
+
+	if err := h.mfaService.Verify(principal.UserID, code); err != nil {
+		h.throttle.RecordFailure(ip, principal.Username)
+		http.Error(w, "验证码错误", http.StatusForbidden)
+		return false
+	}
+	h.throttle.RecordSuccess(principal.Username)
+	return true
+}
+
+// ResetUserMFA 管理员为丢失设备的用户清除两步验证
+func (h *MFAHandler) ResetUserMFA(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	before, err := h.mfaService.Status(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	event := newAuditEvent(r, models.AuditUserMFAReset, "user", strconv.Itoa(id))
+	err = h.mfaService.Disable(id)
+	if err == nil {
+		event.SetChange("mfa_enabled", before.Enabled, false)
+		event.SetChange("recovery_codes_remaining", before.RecoveryCodesRemaining, 0)
+	}
+	h.audit.Record(event, err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"gpu-dev-platform/services"
+)
+
+// oidcLoginTTL 从跳转IdP到回调的最长等待时间
+const oidcLoginTTL = 10 * time.Minute
+
+// oidcMFATTL 单点登录通过后输入两步验证码的最长等待时间
+const oidcMFATTL = 5 * time.Minute
+
+type oidcLoginState struct {
+	nonce        string
+	codeVerifier string
+	userID       int // 等待两步验证的账户，仅用于两步验证票据
+	expiresAt    time.Time
+}
+
+// oidcStateStore 保存进行中的授权请求或等待两步验证的票据，成功后只能使用一次
+type oidcStateStore struct {
+	mu     sync.Mutex
+	states map[string]oidcLoginState
+}
+
+func newOIDCStateStore() *oidcStateStore {
+	return &oidcStateStore{states: make(map[string]oidcLoginState)}
+}
+
+func (s *oidcStateStore) put(state string, v oidcLoginState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, old := range s.states {
+		if now.After(old.expiresAt) {
+			delete(s.states, k)
+		}
+	}
+	s.states[state] = v
+}
+
+// get 查看但不消耗，两步验证码输错时票据仍可在有效期内重试
+func (s *oidcStateStore) get(state string) (oidcLoginState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.states[state]
+	if !ok || time.Now().After(v.expiresAt) {
+		return oidcLoginState{}, false
+	}
+	return v, true
+}
+
+func (s *oidcStateStore) take(state string) (oidcLoginState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.states[state]
+	delete(s.states, state)
+	if !ok || time.Now().After(v.expiresAt) {
+		return oidcLoginState{}, false
+	}
+	return v, true
+}
+
+func randomURLString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// OIDC登录是否启用，供登录页决定是否显示单点登录按钮
+func (h *AuthHandler) OIDCStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"enabled": h.oidc != nil})
+}
+
+// OIDC登录，跳转到IdP授权页面
+func (h *AuthHandler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if h.oidc == nil {
+		http.Error(w, "未启用单点登录", http.StatusNotFound)
+		return
+	}
+
+	state, err1 := randomURLString(24)
+	nonce, err2 := randomURLString(24)
+	verifier, err3 := randomURLString(48)
+	if err1 != nil || err2 != nil || err3 != nil {
+		http.Error(w, "生成登录请求失败", http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := h.oidc.AuthCodeURL(state, nonce, verifier)
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		http.Error(w, "无法连接身份提供方", http.StatusBadGateway)
+		return
+	}
+
+	h.oidcStates.put(state, oidcLoginState{
+		nonce:        nonce,
+		codeVerifier: verifier,
+		expiresAt:    time.Now().Add(oidcLoginTTL),
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDC回调，验证ID token、自动开通账户并签发平台token
+// token通过URL片段交给前端页面，片段不会发送到服务器或写入访问日志
+func (h *AuthHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if h.oidc == nil {
+		http.Error(w, "未启用单点登录", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		http.Error(w, "身份提供方拒绝登录: "+errCode, http.StatusUnauthorized)
+		return
+	}
+
+	login, ok := h.oidcStates.take(query.Get("state"))
+	if !ok {
+		http.Error(w, "登录请求无效或已过期", http.StatusBadRequest)
+		return
+	}
+
+	identity, err := h.oidc.Exchange(query.Get("code"), login.nonce, login.codeVerifier)
+	if err != nil {
+		log.Printf("OIDC callback failed: %v", err)
+		http.Error(w, "单点登录验证失败", http.StatusUnauthorized)
+		return
+	}
+
+	// 按issuer和sub查找关联账户，用户名只在首次开通账户时使用
+	provider := services.OIDCIdentityProvider(h.oidc.Config.Issuer)
+	user, err := h.userService.ProvisionExternalUser(services.ExternalIdentity{
+		Provider: provider,
+		Subject:  identity.Subject,
+		Username: identity.Username,
+		Email:    identity.Email,
+		IsAdmin:  identity.IsAdmin,
+	})
+	if err == services.ErrIdentityNotLinked {
+		log.Printf("OIDC login refused: %s (%s, sub %s) is not linked to the existing account", identity.Username, provider, identity.Subject)
+		http.Error(w, "该用户名已被平台现有账户使用，请联系管理员关联单点登录身份", http.StatusForbidden)
+		return
+	}
+	if err == services.ErrInvalidExternalUsername {
+		log.Printf("OIDC login refused: %q (%s, sub %s) is not a valid platform username", identity.Username, provider, identity.Subject)
+		http.Error(w, "单点登录返回的用户名不符合平台用户名格式（字母、数字、下划线、点和连字符，以字母或数字开头，不超过50位），无法自动开通账户，请联系管理员创建账户并关联单点登录身份", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("OIDC provisioning failed for %s: %v", identity.Username, err)
+		http.Error(w, "开通用户账户失败", http.StatusInternalServerError)
+		return
+	}
+
+	if !user.CanLogin() {
+		http.Error(w, "用户账户已被禁用", http.StatusUnauthorized)
+		return
+	}
+
+	// 已启用两步验证的账户不能只凭IdP登录，先换成票据，由登录页提交验证码后再签发token
+	mfaEnabled, err := h.mfaService.IsEnabled(user.ID)
+	if err != nil {
+		http.Error(w, "数据库查询失败", http.StatusInternalServerError)
+		return
+	}
+	if mfaEnabled {
+		ticket, err := randomURLString(24)
+		if err != nil {
+			http.Error(w, "生成登录请求失败", http.StatusInternalServerError)
+			return
+		}
+		h.oidcMFATickets.put(ticket, oidcLoginState{userID: user.ID, expiresAt: time.Now().Add(oidcMFATTL)})
+
+		fragment := url.Values{"mfa_ticket": {ticket}}
+		http.Redirect(w, r, h.oidc.Config.PostLoginURL+"#"+fragment.Encode(), http.StatusFound)
+		return
+	}
+
+	sessionID, refreshToken, err := h.sessionService.CreateSession(user.ID, r.UserAgent(), clientIP(r))
+	if err != nil {
+		http.Error(w, "创建会话失败", http.StatusInternalServerError)
+		return
+	}
+
+	response, err := h.loginResponse(user, sessionID, refreshToken)
+	if err != nil {
+		http.Error(w, "生成token失败", http.StatusInternalServerError)
+		return
+	}
+
+	fragment := url.Values{
+		"token":         {response.Token},
+		"refresh_token": {response.RefreshToken},
+	}
+	http.Redirect(w, r, h.oidc.Config.PostLoginURL+"#"+fragment.Encode(), http.StatusFound)
+}
+
+type OIDCMFARequest struct {
+	Ticket   string `json:"ticket"`
+	TOTPCode string `json:"totp_code"`
+}
+
+// OIDCVerifyMFA 单点登录后提交两步验证码，验证通过才签发token
+func (h *AuthHandler) OIDCVerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var req OIDCMFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Ticket == "" {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+
+	login, ok := h.oidcMFATickets.get(req.Ticket)
+	if !ok {
+		http.Error(w, "登录请求无效或已过期", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userService.GetUserByID(login.userID)
+	if err != nil || !user.CanLogin() {
+		h.oidcMFATickets.take(req.Ticket)
+		http.Error(w, "用户账户已被禁用", http.StatusUnauthorized)
+		return
+	}
+
+	// 与密码登录共用限流，票据有效期内不能无限尝试验证码
+	ip := clientIP(r)
+	wait, err := h.throttle.Check(ip, user.Username)
+	if err != nil {
+		http.Error(w, "数据库查询失败", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "登录失败次数过多，请稍后再试", http.StatusTooManyRequests)
+		return
+	}
+
+	if !h.verifySecondFactor(w, ip, user, req.TOTPCode) {
+		return
+	}
+
+	// 并发提交同一票据时只有一个请求能签发token
+	if _, ok := h.oidcMFATickets.take(req.Ticket); !ok {
+		http.Error(w, "登录请求无效或已过期", http.StatusBadRequest)
+		return
+	}
+
+	h.throttle.RecordSuccess(user.Username)
+	h.issueTokens(w, r, user)
+}
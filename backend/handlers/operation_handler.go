@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"gpu-dev-platform/services"
+	"github.com/gorilla/mux"
+)
+
+// OperationHandler 查询后台操作的进度和各步骤结果
+type OperationHandler struct {
+	operations *services.OperationService
+}
+
+func NewOperationHandler() *OperationHandler {
+	return &OperationHandler{
+		operations: services.NewOperationService(),
+	}
+}
+
+// ListOperations 最近的操作，可按 target_type、target_id 过滤
+func (h *OperationHandler) ListOperations(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	ops, err := h.operations.List(q.Get("target_type"), q.Get("target_id"), 100)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ops)
+}
+
+func (h *OperationHandler) GetOperation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid operation ID", http.StatusBadRequest)
+		return
+	}
+
+	op, err := h.operations.Get(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Operation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(op)
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"gpu-dev-platform/models"
+	"gpu-dev-platform/services"
+)
+
+// PortalHandler 普通用户自助服务接口，只操作调用者本人的账户和容器
+type PortalHandler struct {
+	containerService *services.ContainerService
+	userService      *services.UserService
+	sessionService   *services.SessionService
+	throttle         *services.LoginThrottleService
+}
+
+func NewPortalHandler() (*PortalHandler, error) {
+	containerService, err := services.NewContainerService()
+	if err != nil {
+		return nil, err
+	}
+
+	return &PortalHandler{
+		containerService: containerService,
+		userService:      services.NewUserService(),
+		sessionService:   services.NewSessionService(),
+		throttle:         services.NewLoginThrottleService(),
+	}, nil
+}
+
+type ChangeMyPasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// currentUser 根据认证中间件写入上下文的身份加载当前用户
+func (h *PortalHandler) currentUser(r *http.Request) (*models.User, error) {
+	principal, ok := PrincipalFrom(r.Context())
+	if !ok {
+		return nil, fmt.Errorf("无效的用户身份")
+	}
+	return h.userService.GetUserByID(principal.UserID)
+}
+
+// currentContainer 加载当前用户的容器，并确认容器确实属于该用户
+func (h *PortalHandler) currentContainer(r *http.Request) (*models.User, *models.Container, int, error) {
+	user, err := h.currentUser(r)
+	if err != nil {
+		return nil, nil, http.StatusUnauthorized, fmt.Errorf("用户不存在")
+	}
+
+	if user.ContainerID == "" {
+		return user, nil, http.StatusNotFound, fmt.Errorf("您还没有开发容器")
+	}
+
+	container, err := h.containerService.GetContainerByID(user.ContainerID)
+	if err != nil {
+		return user, nil, http.StatusNotFound, fmt.Errorf("容器不存在")
+	}
+
+	if container.UserID != user.ID {
+		return user, nil, http.StatusForbidden, fmt.Errorf("无权访问该容器")
+	}
+
+	return user, container, http.StatusOK, nil
+}
+
+func (h *PortalHandler) GetMe(w http.ResponseWriter, r *http.Request) {
+	user, err := h.currentUser(r)
+	if err != nil {
+		http.Error(w, "用户不存在", http.StatusUnauthorized)
+		return
+	}
+
+	// 权限已由认证中间件加载，管理后台据此决定显示哪些功能
+	principal, _ := PrincipalFrom(r.Context())
+	user.Permissions = principal.Permissions
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func (h *PortalHandler) GetMyContainer(w http.ResponseWriter, r *http.Request) {
+	user, container, status, err := h.currentContainer(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	// 附带Docker中的实际运行状态
+	actualStatus, err := h.containerService.GetContainerActualStatus(container.ID)
+	if err != nil {
+		actualStatus = "missing"
+	}
+
+	response := map[string]interface{}{
+		"container":     container,
+		"actual_status": actualStatus,
+		"ports":         user.GetPorts(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *PortalHandler) GetMyPorts(w http.ResponseWriter, r *http.Request) {
+	user, err := h.currentUser(r)
+	if err != nil {
+		http.Error(w, "用户不存在", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user.GetPorts())
+}
+
+func (h *PortalHandler) StartMyContainer(w http.ResponseWriter, r *http.Request) {
+	_, container, status, err := h.currentContainer(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if err := h.containerService.StartContainer(container.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *PortalHandler) StopMyContainer(w http.ResponseWriter, r *http.Request) {
+	_, container, status, err := h.currentContainer(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if err := h.containerService.StopContainer(container.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *PortalHandler) ChangeMyPassword(w http.ResponseWriter, r *http.Request) {
+	var req ChangeMyPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "无效的请求格式", http.StatusBadRequest)
+		return
+	}
+
+	if req.OldPassword == "" || req.NewPassword == "" {
+		http.Error(w, "原密码和新密码不能为空", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.currentUser(r)
+	if err != nil {
+		http.Error(w, "用户不存在", http.StatusUnauthorized)
+		return
+	}
+
+	// 原密码校验与登录共用限流，防止借已登录的会话猜测密码
+	ip := clientIP(r)
+	wait, err := h.throttle.Check(ip, user.Username)
+	if err != nil {
+		http.Error(w, "数据库查询失败", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "失败次数过多，请稍后再试", http.StatusTooManyRequests)
+		return
+	}
+
+	// 修改密码前必须验证原密码
+	if !user.CheckPassword(req.OldPassword) {
+		h.throttle.RecordFailure(ip, user.Username)
+		http.Error(w, "原密码错误", http.StatusForbidden)
+		return
+	}
+	h.throttle.RecordSuccess(user.Username)
+
+	if req.NewPassword == req.OldPassword {
+		http.Error(w, "新密码不能与原密码相同", http.StatusBadRequest)
+		return
+	}
+
+	if err := services.DefaultPasswordPolicy().Validate(req.NewPassword, user.Username); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 用户自己设置密码后解除强制修改标记
+	updates := map[string]interface{}{
+		"password":             req.NewPassword,
+		"must_change_password": false,
+	}
+
+	if err := h.userService.UpdateUser(user.ID, updates); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// 密码可能已泄露，其他设备上的会话和刷新token一并失效，只保留当前会话
+	principal, _ := PrincipalFrom(r.Context())
+	if _, err := h.sessionService.RevokeOtherSessions(user.ID, principal.SessionID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"gpu-dev-platform/models"
+)
+
+// Principal 已认证的调用者身份，由认证中间件写入请求上下文
+type Principal struct {
+	UserID     int
+	Username   string
+	IsAdmin    bool
+	SessionID  string
+	MFAEnabled bool
+
+	// 通过角色获得的权限点，is_admin 账户拥有全部权限
+	Permissions []string
+
+	// 通过个人访问令牌认证时非空，此时 SessionID 为空
+	APIToken *models.APIToken
+}
+
+// Can 调用者是否拥有指定权限点
+func (p *Principal) Can(perm string) bool {
+	for _, granted := range p.Permissions {
+		if granted == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Privileged 是否拥有任意管理权限，即能否登录管理后台
+func (p *Principal) Privileged() bool {
+	return len(p.Permissions) > 0
+}
+
+type principalKey struct{}
+
+// WithPrincipal 返回携带调用者身份的上下文
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom 从上下文取出调用者身份，未经认证时返回 false
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// StripIdentityHeaders 在入口处删除客户端伪造的身份头，
+// 身份只能来自认证中间件写入的上下文
+func StripIdentityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name := range r.Header {
+			if strings.HasPrefix(name, "X-User-") || name == "X-Username" ||
+				name == "X-Is-Admin" || name == "X-Session-Id" {
+				r.Header.Del(name)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
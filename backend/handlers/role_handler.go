@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gpu-dev-platform/models"
+	"gpu-dev-platform/services"
+	"github.com/gorilla/mux"
+)
+
+// RoleHandler 角色和用户角色分配管理
+type RoleHandler struct {
+	roles *services.RoleService
+	audit *services.AuditService
+}
+
+func NewRoleHandler() *RoleHandler {
+	return &RoleHandler{
+		roles: services.NewRoleService(),
+		audit: services.NewAuditService(),
+	}
+}
+
+type RoleRequest struct {
+	Name        string   `json:"name,omitempty"`
+	Description *string  `json:"description,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+type SetUserRolesRequest struct {
+	RoleIDs []int `json:"role_ids"`
+}
+
+func (h *RoleHandler) ListRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.roles.ListRoles()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roles)
+}
+
+// ListPermissions 返回全部可分配的权限点，供管理界面编辑角色
+func (h *RoleHandler) ListPermissions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.AllPermissions)
+}
+
+func (h *RoleHandler) CreateRole(w http.ResponseWriter, r *http.Request) {
+	var req RoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "角色名称不能为空", http.StatusBadRequest)
+		return
+	}
+
+	description := ""
+	if req.Description != nil {
+		description = *req.Description
+	}
+
+	role, err := h.roles.CreateRole(req.Name, description, req.Permissions)
+	if err != nil {
+		if err == services.ErrUnknownPermission {
+			http.Error(w, "未知的权限点，可选: "+strings.Join(models.AllPermissions, ", "), http.StatusBadRequest)
+			return
+		}
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			http.Error(w, "角色名称已存在", http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(role)
+}
+
+func (h *RoleHandler) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid role ID", http.StatusBadRequest)
+		return
+	}
+
+	var req RoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.roles.UpdateRole(id, req.Description, req.Permissions); err != nil {
+		writeRoleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *RoleHandler) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid role ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.roles.DeleteRole(id); err != nil {
+		writeRoleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *RoleHandler) GetUserRoles(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	roles, err := h.roles.GetUserRoles(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roles)
+}
+
+// SetUserRoles 整体替换用户的角色
+func (h *RoleHandler) SetUserRoles(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req SetUserRolesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	before, err := h.roles.GetUserRoles(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	event := newAuditEvent(r, models.AuditUserRoles, "user", strconv.Itoa(userID))
+	err = h.roles.SetUserRoles(userID, req.RoleIDs)
+	if err == nil {
+		var after []*models.Role
+		if after, err = h.roles.GetUserRoles(userID); err == nil {
+			event.SetChange("roles", roleNames(before), roleNames(after))
+		}
+	}
+	h.audit.Record(event, err)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "角色不存在", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// roleNames 角色名称列表，用于审计记录
+func roleNames(roles []*models.Role) string {
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, role.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func writeRoleError(w http.ResponseWriter, err error) {
+	switch err {
+	case sql.ErrNoRows:
+		http.Error(w, "Role not found", http.StatusNotFound)
+	case services.ErrBuiltinRole:
+		http.Error(w, "内置角色不能修改或删除", http.StatusConflict)
+	case services.ErrUnknownPermission:
+		http.Error(w, "未知的权限点，可选: "+strings.Join(models.AllPermissions, ", "), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
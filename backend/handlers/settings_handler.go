@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gpu-dev-platform/models"
+	"gpu-dev-platform/services"
+)
+
+// SettingsHandler 平台级安全策略设置
+type SettingsHandler struct {
+	settings *services.SettingsService
+	audit    *services.AuditService
+}
+
+func NewSettingsHandler() *SettingsHandler {
+	return &SettingsHandler{
+		settings: services.NewSettingsService(),
+		audit:    services.NewAuditService(),
+	}
+}
+
+type SecuritySettings struct {
+	RequireAdmin2FA *bool `json:"require_admin_2fa,omitempty"`
+}
+
+func (h *SettingsHandler) GetSecuritySettings(w http.ResponseWriter, r *http.Request) {
+	requireAdmin2FA, err := h.settings.GetBool(services.SettingRequireAdmin2FA, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SecuritySettings{RequireAdmin2FA: &requireAdmin2FA})
+}
+
+func (h *SettingsHandler) UpdateSecuritySettings(w http.ResponseWriter, r *http.Request) {
+	var req SecuritySettings
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.RequireAdmin2FA != nil {
+		// 防止管理员在自己未绑定时开启策略而把自己锁在外面
+		principal, _ := PrincipalFrom(r.Context())
+		if *req.RequireAdmin2FA && !principal.MFAEnabled {
+			http.Error(w, "请先为当前账户启用两步验证", http.StatusConflict)
+			return
+		}
+
+		before, err := h.settings.GetBool(services.SettingRequireAdmin2FA, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		event := newAuditEvent(r, models.AuditSettingsSecurity, "settings", "security")
+		err = h.settings.SetBool(services.SettingRequireAdmin2FA, *req.RequireAdmin2FA)
+		if err == nil {
+			event.SetChange("require_admin_2fa", before, *req.RequireAdmin2FA)
+		}
+		h.audit.Record(event, err)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -1,36 +1,65 @@
 package handlers
 
 import (
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	"gpu-dev-platform/models"
 	"gpu-dev-platform/services"
 	"github.com/gorilla/mux"
 )
 
+const maxUserPageSize = 500
+
 type UserHandler struct {
-	userService *services.UserService
+	userService    *services.UserService
+	sessionService *services.SessionService
+	throttle       *services.LoginThrottleService
+	cleanup        *services.UserCleanupService
+	audit          *services.AuditService
 }
 
-func NewUserHandler() *UserHandler {
-	return &UserHandler{
-		userService: services.NewUserService(),
+func NewUserHandler() (*UserHandler, error) {
+	containerService, err := services.NewContainerService()
+	if err != nil {
+		return nil, err
 	}
+
+	return &UserHandler{
+		userService:    services.NewUserService(),
+		sessionService: services.NewSessionService(),
+		throttle:       services.NewLoginThrottleService(),
+		cleanup:        services.NewUserCleanupService(containerService),
+		audit:          services.NewAuditService(),
+	}, nil
 }
 
 type CreateUserRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
-	Email    string `json:"email"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	Email     string `json:"email"`
+	ExpiresAt string `json:"expires_at,omitempty"` // RFC3339 或 YYYY-MM-DD
 }
 
 type UpdateUserRequest struct {
-	Username string `json:"username,omitempty"`
-	Email    string `json:"email,omitempty"`
-	IsActive *bool  `json:"is_active,omitempty"`
-	IsAdmin  *bool  `json:"is_admin,omitempty"`
+	Username  string  `json:"username,omitempty"`
+	Email     string  `json:"email,omitempty"`
+	IsActive  *bool   `json:"is_active,omitempty"`
+	IsAdmin   *bool   `json:"is_admin,omitempty"`
+	ExpiresAt *string `json:"expires_at,omitempty"` // 空字符串表示取消到期时间
+}
+
+// ReassignPortsRequest base_port 为 0 或省略时自动分配空闲端口段
+type ReassignPortsRequest struct {
+	BasePort int `json:"base_port"`
 }
 
 type ChangePasswordRequest struct {
@@ -49,12 +78,38 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.userService.CreateUser(req.Username, req.Password, req.Email)
+	if err := services.DefaultPasswordPolicy().Validate(req.Password, req.Username); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	expiresAt, err := parseExpiresAt(req.ExpiresAt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 管理员设置的初始密码，用户首次登录后必须修改
+	user, err := h.userService.CreateUserWithExpiry(req.Username, req.Password, req.Email, true, expiresAt)
+	event := newAuditEvent(r, models.AuditUserCreate, "user", "")
+	event.SetChange("username", nil, req.Username)
+	event.SetChange("email", nil, req.Email)
+	if expiresAt != nil {
+		event.SetChange("expires_at", nil, expiresAt.Format(time.RFC3339))
+	}
+	if user != nil {
+		event.TargetID = strconv.Itoa(user.ID)
+	}
+	h.audit.Record(event, err)
 	if err != nil {
 		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
 			http.Error(w, "Username already exists", http.StatusConflict)
 			return
 		}
+		if errors.Is(err, services.ErrPortRangeExhausted) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -81,15 +136,161 @@ func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(user)
 }
 
+// UserListResponse 用户列表分页结果，NextPage 为 0 表示没有下一页
+type UserListResponse struct {
+	Users    []*models.User `json:"users"`
+	Total    int            `json:"total"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"page_size"`
+	HasMore  bool           `json:"has_more"`
+	NextPage int            `json:"next_page,omitempty"`
+}
+
 func (h *UserHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := h.userService.ListUsers()
+	filter, err := parseUserFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.writeUserList(w, filter)
+}
+
+// writeUserList 查询一页用户并附带分页信息返回
+func (h *UserHandler) writeUserList(w http.ResponseWriter, filter services.UserFilter) {
+	users, total, err := h.userService.ListUsers(filter)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	resp := UserListResponse{
+		Users:    users,
+		Total:    total,
+		Page:     filter.Page,
+		PageSize: filter.PageSize,
+		HasMore:  filter.Page*filter.PageSize < total,
+	}
+	if resp.HasMore {
+		resp.NextPage = filter.Page + 1
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(users)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ExportUsers 按与列表相同的过滤条件导出全部匹配的用户，format=csv 或 jsonl
+// CSV 包含 username, email 列，可直接作为批量导入的模板
+func (h *UserHandler) ExportUsers(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseUserFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	filename := fmt.Sprintf("users-%s.%s", time.Now().Format("20060102-150405"), format)
+
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"id", "username", "email", "is_active", "is_admin", "must_change_password",
+			"base_port", "container_id", "created_at", "last_login"})
+		err = h.userService.EachUser(filter, func(u *models.User) error {
+			return writer.Write([]string{
+				strconv.Itoa(u.ID), u.Username, u.Email, strconv.FormatBool(u.IsActive), strconv.FormatBool(u.IsAdmin),
+				strconv.FormatBool(u.MustChangePassword), strconv.Itoa(u.BasePort), u.ContainerID,
+				u.CreatedAt.Format(time.RFC3339), u.LastLogin.Format(time.RFC3339),
+			})
+		})
+		writer.Flush()
+
+	case "jsonl":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+
+		encoder := json.NewEncoder(w)
+		err = h.userService.EachUser(filter, func(u *models.User) error {
+			return encoder.Encode(u)
+		})
+
+	default:
+		http.Error(w, "format 只支持 csv 或 jsonl", http.StatusBadRequest)
+		return
+	}
+
+	// 响应已经开始写出，只能记录错误
+	if err != nil {
+		log.Printf("user export failed: %v", err)
+	}
+}
+
+// parseUserFilter 解析查询参数：search, is_active, is_admin, has_container,
+// sort (id/username/email/created_at/last_login/base_port/expires_at), order (asc/desc), page, page_size
+func parseUserFilter(r *http.Request) (services.UserFilter, error) {
+	q := r.URL.Query()
+	filter := services.UserFilter{
+		Search:   strings.TrimSpace(q.Get("search")),
+		Sort:     "created_at",
+		Desc:     true,
+		Page:     1,
+		PageSize: 50,
+	}
+
+	var err error
+	for name, target := range map[string]**bool{
+		"is_active":     &filter.IsActive,
+		"is_admin":      &filter.IsAdmin,
+		"has_container": &filter.HasContainer,
+	} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, fmt.Errorf("%s 必须是 true 或 false", name)
+		}
+		*target = &b
+	}
+
+	if v := q.Get("sort"); v != "" {
+		if !services.ValidUserSort(v) {
+			return filter, fmt.Errorf("不支持的排序字段: %s", v)
+		}
+		filter.Sort = v
+	}
+	switch q.Get("order") {
+	case "":
+	case "asc":
+		filter.Desc = false
+	case "desc":
+		filter.Desc = true
+	default:
+		return filter, fmt.Errorf("order 只支持 asc 或 desc")
+	}
+
+	if v := q.Get("page"); v != "" {
+		if filter.Page, err = strconv.Atoi(v); err != nil || filter.Page < 1 {
+			return filter, fmt.Errorf("无效的 page")
+		}
+	}
+	if v := q.Get("page_size"); v != "" {
+		if filter.PageSize, err = strconv.Atoi(v); err != nil || filter.PageSize < 1 {
+			return filter, fmt.Errorf("无效的 page_size")
+		}
+		if filter.PageSize > maxUserPageSize {
+			filter.PageSize = maxUserPageSize
+		}
+	}
+
+	return filter, nil
 }
 
 func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
@@ -106,6 +307,12 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	before, err := h.userService.GetUserByID(id)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
 	updates := make(map[string]interface{})
 	if req.Username != "" {
 		updates["username"] = req.Username
@@ -117,14 +324,55 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		updates["is_active"] = *req.IsActive
 	}
 	if req.IsAdmin != nil {
+		// is_admin 等同于 platform-admin 角色，只有能管理角色的账户可以修改
+		principal, _ := PrincipalFrom(r.Context())
+		if !principal.Can(models.PermRolesManage) {
+			http.Error(w, "修改管理员身份需要角色管理权限", http.StatusForbidden)
+			return
+		}
 		updates["is_admin"] = *req.IsAdmin
 	}
+	var expiresAt *time.Time
+	if req.ExpiresAt != nil {
+		if expiresAt, err = parseExpiresAt(*req.ExpiresAt); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		updates["expires_at"] = expiresAt
+		// 修改到期时间后重新发送到期提醒，已回收的账户延期后可再次回收
+		updates["expiry_warned_at"] = nil
+		updates["deprovisioned_at"] = nil
+	}
+
+	event := newAuditEvent(r, models.AuditUserUpdate, "user", strconv.Itoa(id))
+	if req.Username != "" {
+		event.SetChange("username", before.Username, req.Username)
+	}
+	if req.Email != "" {
+		event.SetChange("email", before.Email, req.Email)
+	}
+	if req.IsActive != nil {
+		event.SetChange("is_active", before.IsActive, *req.IsActive)
+	}
+	if req.IsAdmin != nil {
+		event.SetChange("is_admin", before.IsAdmin, *req.IsAdmin)
+	}
+	if req.ExpiresAt != nil {
+		event.SetChange("expires_at", formatExpiresAt(before.ExpiresAt), formatExpiresAt(expiresAt))
+	}
 
-	if err := h.userService.UpdateUser(id, updates); err != nil {
+	err = h.userService.UpdateUser(id, updates)
+	h.audit.Record(event, err)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// 禁用账户时立即踢下线
+	if req.IsActive != nil && !*req.IsActive {
+		h.sessionService.RevokeUserSessions(id)
+	}
+
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -147,18 +395,61 @@ func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user, err := h.userService.GetUserByID(id)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if err := services.DefaultPasswordPolicy().Validate(req.Password, user.Username); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 管理员重置的密码只是临时密码，用户登录后必须修改
 	updates := map[string]interface{}{
-		"password": req.Password,
+		"password":             req.Password,
+		"must_change_password": true,
 	}
 
-	if err := h.userService.UpdateUser(id, updates); err != nil {
+	// 密码只记录发生了变更，不记录内容
+	event := newAuditEvent(r, models.AuditUserPasswordReset, "user", strconv.Itoa(id))
+	event.SetChange("password", nil, "[REDACTED]")
+
+	err = h.userService.UpdateUser(id, updates)
+	h.audit.Record(event, err)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// 管理员重置密码后旧会话全部失效
+	h.sessionService.RevokeUserSessions(id)
+
 	w.WriteHeader(http.StatusOK)
 }
 
+// RevokeSessions 吊销用户的所有登录会话
+func (h *UserHandler) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	revoked, err := h.sessionService.RevokeUserSessions(id)
+	h.audit.Record(newAuditEvent(r, models.AuditUserRevokeSession, "user", strconv.Itoa(id)), err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"revoked": revoked})
+}
+
+// DeleteUser 默认移入回收站；purge=true 时彻底删除，data=archive(默认)|delete|keep 决定家目录的处理方式
 func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
@@ -167,10 +458,298 @@ func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.userService.DeleteUser(id); err != nil {
+	purge := r.URL.Query().Get("purge") == "true"
+	data := r.URL.Query().Get("data")
+	if data == "" {
+		data = services.UserDataArchive
+	}
+	if !services.ValidUserDataOption(data) {
+		http.Error(w, "data 只支持 archive、delete 或 keep", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userService.GetUserByID(id)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if !purge {
+		if user.DeletedAt != nil {
+			http.Error(w, "用户已在回收站中", http.StatusConflict)
+			return
+		}
+
+		err = h.cleanup.Trash(user)
+		event := newAuditEvent(r, models.AuditUserDelete, "user", strconv.Itoa(id))
+		event.SetChange("username", user.Username, nil)
+		h.audit.Record(event, err)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// 清理在后台执行，审计日志在操作结束后记录
+	principal, _ := PrincipalFrom(r.Context())
+	op, err := h.cleanup.StartPurge(user, services.DeleteUserOptions{
+		Data:      data,
+		ActorID:   principal.UserID,
+		ActorName: principal.Username,
+		SourceIP:  clientIP(r),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(op)
+}
+
+// ListTrash 回收站中的用户，支持与用户列表相同的查询参数，默认按删除时间倒序
+func (h *UserHandler) ListTrash(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseUserFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Deleted = true
+	if r.URL.Query().Get("sort") == "" {
+		filter.Sort = "deleted_at"
+	}
+
+	h.writeUserList(w, filter)
+}
+
+// RestoreUser 从回收站恢复用户
+func (h *UserHandler) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userService.GetUserByID(id)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if user.DeletedAt == nil {
+		http.Error(w, "用户不在回收站中", http.StatusConflict)
+		return
+	}
+
+	err = h.cleanup.Restore(user)
+	event := newAuditEvent(r, models.AuditUserRestore, "user", strconv.Itoa(id))
+	event.SetChange("username", nil, user.Username)
+	h.audit.Record(event, err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetPortUsage 端口分配范围、各用户的端口段和被外部占用的主机端口
+func (h *UserHandler) GetPortUsage(w http.ResponseWriter, r *http.Request) {
+	usage, err := h.userService.PortUsage()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
+
+// ReassignPorts 为没有容器的用户重新分配端口段
+func (h *UserHandler) ReassignPorts(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req ReassignPortsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userService.GetUserByID(id)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if user.ContainerID != "" {
+		http.Error(w, services.ErrUserHasContainer.Error(), http.StatusConflict)
+		return
+	}
+
+	basePort, err := h.userService.ReassignPorts(user, req.BasePort)
+	event := newAuditEvent(r, models.AuditUserPorts, "user", strconv.Itoa(id))
+	event.SetChange("base_port", user.BasePort, basePort)
+	h.audit.Record(event, err)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrPortRangeExhausted):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		case errors.Is(err, services.ErrPortConflict), errors.Is(err, services.ErrUserHasContainer):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, services.ErrPortOutOfRange):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	user.BasePort = basePort
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// UnlockLogin 解除用户因登录失败过多造成的锁定
+func (h *UserHandler) UnlockLogin(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userService.GetUserByID(id)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	err = h.throttle.Unlock(user.Username)
+	h.audit.Record(newAuditEvent(r, models.AuditUserUnlock, "user", strconv.Itoa(id)), err)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-}
\ No newline at end of file
+}
+
+type IdentityRequest struct {
+	Provider string `json:"provider"` // "ldap" 或 "oidc:<issuer>"
+	Subject  string `json:"subject"`
+}
+
+// ListIdentities 用户已关联的外部登录身份
+func (h *UserHandler) ListIdentities(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	identities, err := h.userService.ListIdentities(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(identities)
+}
+
+// LinkIdentity 将外部身份关联到已有账户，用于让同名的本地账户改用单点登录或目录登录
+func (h *UserHandler) LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req IdentityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.userService.GetUserByID(id); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	event := newAuditEvent(r, models.AuditUserIdentityLink, "user", strconv.Itoa(id))
+	event.SetChange("identity", nil, req.Provider+" "+req.Subject)
+
+	err = h.userService.LinkIdentity(id, req.Provider, req.Subject)
+	h.audit.Record(event, err)
+	switch err {
+	case nil:
+	case services.ErrInvalidIdentity:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case services.ErrIdentityLinked:
+		http.Error(w, "该外部身份已关联其他账户", http.StatusConflict)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// UnlinkIdentity 解除外部身份关联，参数 provider 和 subject 通过查询字符串传入
+func (h *UserHandler) UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	provider := r.URL.Query().Get("provider")
+	subject := r.URL.Query().Get("subject")
+
+	event := newAuditEvent(r, models.AuditUserIdentityUnlink, "user", strconv.Itoa(id))
+	event.SetChange("identity", provider+" "+subject, nil)
+
+	err = h.userService.UnlinkIdentity(id, provider, subject)
+	h.audit.Record(event, err)
+	if err == services.ErrIdentityNotFound {
+		http.Error(w, "关联不存在", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseExpiresAt 解析到期时间，支持 RFC3339 或 YYYY-MM-DD（当天零点起失效），空字符串返回 nil
+func parseExpiresAt(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return &t, nil
+	}
+	return nil, fmt.Errorf("expires_at 必须是 RFC3339 时间或 YYYY-MM-DD 日期")
+}
+
+func formatExpiresAt(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.Format(time.RFC3339)
+}
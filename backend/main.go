@@ -7,6 +7,8 @@ import (
 
 	"gpu-dev-platform/database"
 	"gpu-dev-platform/handlers"
+	"gpu-dev-platform/models"
+	"gpu-dev-platform/services"
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 )
@@ -22,6 +24,44 @@ func main() {
 	}
 	defer database.Close()
 
+	// 写入内置角色
+	if err := services.NewRoleService().EnsureBuiltinRoles(); err != nil {
+		log.Fatal("Failed to initialize builtin roles:", err)
+	}
+
+	// 重启前仍在执行的后台操作无法继续，需在启动后台任务前标记
+	if err := services.NewOperationService().MarkInterrupted(); err != nil {
+		log.Fatal("Failed to mark interrupted operations:", err)
+	}
+
+	// 账户到期调度：提醒、禁用、宽限期后回收
+	expiryScheduler, err := services.NewExpiryScheduler()
+	if err != nil {
+		log.Fatal("Failed to create account expiry scheduler:", err)
+	}
+	go expiryScheduler.Run()
+
+	// 回收站保留期满后彻底删除用户
+	trashPurger, err := services.NewTrashPurger()
+	if err != nil {
+		log.Fatal("Failed to create trash purger:", err)
+	}
+	go trashPurger.Run()
+
+	// GPU 租约开始和结束时挂载、卸载 GPU
+	gpuLeaseWorker, err := services.NewGPULeaseWorker()
+	if err != nil {
+		log.Fatal("Failed to create GPU lease worker:", err)
+	}
+	go gpuLeaseWorker.Run()
+
+	// 启动时盘点 GPU，失败时保留上次的清单，之后按 GPU_INVENTORY_REFRESH 自动重试
+	go func() {
+		if err := services.DefaultGPUService().Refresh(); err != nil {
+			log.Printf("GPU inventory failed: %v", err)
+		}
+	}()
+
 	// 创建路由
 	router := mux.NewRouter()
 
@@ -29,22 +69,108 @@ func main() {
 	api := router.PathPrefix("/api").Subrouter()
 
 	// 认证处理器
-	authHandler := handlers.NewAuthHandler()
+	authHandler, err := handlers.NewAuthHandler()
+	if err != nil {
+		log.Fatal("Failed to create auth handler:", err)
+	}
 
 	// 公开的认证路由
 	api.HandleFunc("/admin/login", authHandler.AdminLogin).Methods("POST")
+	api.HandleFunc("/login", authHandler.Login).Methods("POST")
+	api.HandleFunc("/token/refresh", authHandler.Refresh).Methods("POST")
+	api.HandleFunc("/oidc/status", authHandler.OIDCStatus).Methods("GET")
+	api.HandleFunc("/oidc/login", authHandler.OIDCLogin).Methods("GET")
+	api.HandleFunc("/oidc/callback", authHandler.OIDCCallback).Methods("GET")
+	api.HandleFunc("/oidc/mfa", authHandler.OIDCVerifyMFA).Methods("POST")
+	api.HandleFunc("/logout", authHandler.RequireAuth(authHandler.Logout)).Methods("POST")
 
-	// 管理员路由 (需要管理员权限)
+	// 管理路由 (按权限点控制访问)
 	adminAPI := api.PathPrefix("").Subrouter()
-	
+
+
 	// 用户管理路由
-	userHandler := handlers.NewUserHandler()
-	adminAPI.HandleFunc("/users", authHandler.RequireAdmin(userHandler.ListUsers)).Methods("GET")
-	adminAPI.HandleFunc("/users", authHandler.RequireAdmin(userHandler.CreateUser)).Methods("POST")
-	adminAPI.HandleFunc("/users/{id:[0-9]+}", authHandler.RequireAdmin(userHandler.GetUser)).Methods("GET")
-	adminAPI.HandleFunc("/users/{id:[0-9]+}", authHandler.RequireAdmin(userHandler.UpdateUser)).Methods("PUT")
-	adminAPI.HandleFunc("/users/{id:[0-9]+}", authHandler.RequireAdmin(userHandler.DeleteUser)).Methods("DELETE")
-	adminAPI.HandleFunc("/users/{id:[0-9]+}/password", authHandler.RequireAdmin(userHandler.ChangePassword)).Methods("PUT")
+	userHandler, err := handlers.NewUserHandler()
+	if err != nil {
+		log.Fatal("Failed to create user handler:", err)
+	}
+	adminAPI.HandleFunc("/users", authHandler.RequirePermission(models.PermUsersRead, userHandler.ListUsers)).Methods("GET")
+	adminAPI.HandleFunc("/users", authHandler.RequirePermission(models.PermUsersCreate, userHandler.CreateUser)).Methods("POST")
+	adminAPI.HandleFunc("/users/{id:[0-9]+}", authHandler.RequirePermission(models.PermUsersRead, userHandler.GetUser)).Methods("GET")
+	adminAPI.HandleFunc("/users/{id:[0-9]+}", authHandler.RequireUserPermission(models.PermUsersUpdate, "id", userHandler.UpdateUser)).Methods("PUT")
+	adminAPI.HandleFunc("/users/{id:[0-9]+}", authHandler.RequireUserPermission(models.PermUsersDelete, "id", userHandler.DeleteUser)).Methods("DELETE")
+	adminAPI.HandleFunc("/users/{id:[0-9]+}/password", authHandler.RequireUserPermission(models.PermUsersSecurity, "id", userHandler.ChangePassword)).Methods("PUT")
+	adminAPI.HandleFunc("/users/{id:[0-9]+}/sessions/revoke", authHandler.RequireUserPermission(models.PermUsersSecurity, "id", userHandler.RevokeSessions)).Methods("POST")
+	adminAPI.HandleFunc("/users/{id:[0-9]+}/unlock", authHandler.RequireUserPermission(models.PermUsersSecurity, "id", userHandler.UnlockLogin)).Methods("POST")
+	adminAPI.HandleFunc("/users/{id:[0-9]+}/identities", authHandler.RequirePermission(models.PermUsersRead, userHandler.ListIdentities)).Methods("GET")
+	adminAPI.HandleFunc("/users/{id:[0-9]+}/identities", authHandler.RequireUserPermission(models.PermUsersSecurity, "id", userHandler.LinkIdentity)).Methods("POST")
+	adminAPI.HandleFunc("/users/{id:[0-9]+}/identities", authHandler.RequireUserPermission(models.PermUsersSecurity, "id", userHandler.UnlinkIdentity)).Methods("DELETE")
+	adminAPI.HandleFunc("/users/export", authHandler.RequirePermission(models.PermUsersRead, userHandler.ExportUsers)).Methods("GET")
+	adminAPI.HandleFunc("/users/trash", authHandler.RequirePermission(models.PermUsersRead, userHandler.ListTrash)).Methods("GET")
+	adminAPI.HandleFunc("/users/{id:[0-9]+}/ports", authHandler.RequireUserPermission(models.PermContainersAdmin, "id", userHandler.ReassignPorts)).Methods("PUT")
+	adminAPI.HandleFunc("/ports", authHandler.RequirePermission(models.PermContainersRead, userHandler.GetPortUsage)).Methods("GET")
+	adminAPI.HandleFunc("/users/{id:[0-9]+}/restore", authHandler.RequireUserPermission(models.PermUsersDelete, "id", userHandler.RestoreUser)).Methods("POST")
+
+	// 后台操作 (删除用户等多步骤操作的进度和结果)
+	operationHandler := handlers.NewOperationHandler()
+	adminAPI.HandleFunc("/operations", authHandler.RequirePermission(models.PermUsersRead, operationHandler.ListOperations)).Methods("GET")
+	adminAPI.HandleFunc("/operations/{id:[0-9]+}", authHandler.RequirePermission(models.PermUsersRead, operationHandler.GetOperation)).Methods("GET")
+
+	// 批量导入用户 (创建容器还需要 containers.manage)
+	importHandler, err := handlers.NewImportHandler()
+	if err != nil {
+		log.Fatal("Failed to create import handler:", err)
+	}
+	adminAPI.HandleFunc("/users/import", authHandler.RequirePermission(models.PermUsersCreate, importHandler.ImportUsers)).Methods("POST")
+	adminAPI.HandleFunc("/users/import", authHandler.RequirePermission(models.PermUsersCreate, importHandler.ListImportJobs)).Methods("GET")
+	adminAPI.HandleFunc("/users/import/{jobId:[0-9]+}", authHandler.RequirePermission(models.PermUsersCreate, importHandler.GetImportJob)).Methods("GET")
+	adminAPI.HandleFunc("/users/import/{jobId:[0-9]+}/report", authHandler.RequirePermission(models.PermUsersCreate, importHandler.DownloadReport)).Methods("GET")
+
+	// 两步验证 (绑定接口只需登录，未绑定的管理员在强制策略下也能完成绑定)
+	mfaHandler := handlers.NewMFAHandler()
+	api.HandleFunc("/me/2fa", authHandler.RequireAuth(mfaHandler.Status)).Methods("GET")
+	api.HandleFunc("/me/2fa/enroll", authHandler.RequireAuth(mfaHandler.Enroll)).Methods("POST")
+	api.HandleFunc("/me/2fa/confirm", authHandler.RequireAuth(mfaHandler.Confirm)).Methods("POST")
+	api.HandleFunc("/me/2fa/disable", authHandler.RequireAuth(mfaHandler.Disable)).Methods("POST")
+	api.HandleFunc("/me/2fa/recovery-codes", authHandler.RequireAuth(mfaHandler.RegenerateRecoveryCodes)).Methods("POST")
+	adminAPI.HandleFunc("/users/{id:[0-9]+}/2fa", authHandler.RequireUserPermission(models.PermUsersSecurity, "id", mfaHandler.ResetUserMFA)).Methods("DELETE")
+
+	// 个人访问令牌 (令牌本身不能访问这些接口)
+	apiTokenHandler := handlers.NewAPITokenHandler()
+	api.HandleFunc("/me/tokens", authHandler.RequireAuth(apiTokenHandler.ListMyTokens)).Methods("GET")
+	api.HandleFunc("/me/tokens", authHandler.RequireAuth(apiTokenHandler.CreateMyToken)).Methods("POST")
+	api.HandleFunc("/me/tokens/{tokenId:[0-9]+}", authHandler.RequireAuth(apiTokenHandler.RevokeMyToken)).Methods("DELETE")
+	adminAPI.HandleFunc("/users/{id:[0-9]+}/tokens", authHandler.RequireUserPermission(models.PermUsersSecurity, "id", apiTokenHandler.ListUserTokens)).Methods("GET")
+	adminAPI.HandleFunc("/users/{id:[0-9]+}/tokens/{tokenId:[0-9]+}", authHandler.RequireUserPermission(models.PermUsersSecurity, "id", apiTokenHandler.RevokeUserToken)).Methods("DELETE")
+
+	// 角色和权限
+	roleHandler := handlers.NewRoleHandler()
+	adminAPI.HandleFunc("/roles", authHandler.RequirePermission(models.PermRolesManage, roleHandler.ListRoles)).Methods("GET")
+	adminAPI.HandleFunc("/roles", authHandler.RequirePermission(models.PermRolesManage, roleHandler.CreateRole)).Methods("POST")
+	adminAPI.HandleFunc("/roles/{id:[0-9]+}", authHandler.RequirePermission(models.PermRolesManage, roleHandler.UpdateRole)).Methods("PUT")
+	adminAPI.HandleFunc("/roles/{id:[0-9]+}", authHandler.RequirePermission(models.PermRolesManage, roleHandler.DeleteRole)).Methods("DELETE")
+	adminAPI.HandleFunc("/permissions", authHandler.RequirePermission(models.PermRolesManage, roleHandler.ListPermissions)).Methods("GET")
+	adminAPI.HandleFunc("/users/{id:[0-9]+}/roles", authHandler.RequirePermission(models.PermUsersRead, roleHandler.GetUserRoles)).Methods("GET")
+	adminAPI.HandleFunc("/users/{id:[0-9]+}/roles", authHandler.RequirePermission(models.PermRolesManage, roleHandler.SetUserRoles)).Methods("PUT")
+
+	// 用户组 (组管理员可查看本组成员并管理其容器)
+	groupHandler := handlers.NewGroupHandler()
+	api.HandleFunc("/me/groups", authHandler.RequireAuth(groupHandler.ListMyGroups)).Methods("GET")
+	adminAPI.HandleFunc("/groups", authHandler.RequirePermission(models.PermUsersRead, groupHandler.ListGroups)).Methods("GET")
+	adminAPI.HandleFunc("/groups", authHandler.RequirePermission(models.PermGroupsManage, groupHandler.CreateGroup)).Methods("POST")
+	adminAPI.HandleFunc("/groups/{id:[0-9]+}", authHandler.RequirePermission(models.PermGroupsManage, groupHandler.DeleteGroup)).Methods("DELETE")
+	adminAPI.HandleFunc("/groups/{id:[0-9]+}/members", authHandler.RequireGroupAdmin(models.PermUsersRead, "id", groupHandler.ListMembers)).Methods("GET")
+	adminAPI.HandleFunc("/groups/{id:[0-9]+}/members/{userId:[0-9]+}", authHandler.RequirePermission(models.PermGroupsManage, groupHandler.SetMember)).Methods("PUT")
+	adminAPI.HandleFunc("/groups/{id:[0-9]+}/members/{userId:[0-9]+}", authHandler.RequirePermission(models.PermGroupsManage, groupHandler.RemoveMember)).Methods("DELETE")
+
+	// 审计日志
+	auditHandler := handlers.NewAuditHandler()
+	adminAPI.HandleFunc("/audit", authHandler.RequirePermission(models.PermAuditRead, auditHandler.ListEvents)).Methods("GET")
+	adminAPI.HandleFunc("/audit/export", authHandler.RequirePermission(models.PermAuditRead, auditHandler.ExportEvents)).Methods("GET")
+
+	// 平台安全策略
+	settingsHandler := handlers.NewSettingsHandler()
+	adminAPI.HandleFunc("/settings/security", authHandler.RequirePermission(models.PermSettingsManage, settingsHandler.GetSecuritySettings)).Methods("GET")
+	adminAPI.HandleFunc("/settings/security", authHandler.RequirePermission(models.PermSettingsManage, settingsHandler.UpdateSecuritySettings)).Methods("PUT")
 
 	// 容器管理路由
 	containerHandler, err := handlers.NewContainerHandler()
@@ -52,15 +178,48 @@ func main() {
 		log.Fatal("Failed to create container handler:", err)
 	}
 	
-	adminAPI.HandleFunc("/containers", authHandler.RequireAdmin(containerHandler.ListContainers)).Methods("GET")
-	adminAPI.HandleFunc("/containers", authHandler.RequireAdmin(containerHandler.CreateContainer)).Methods("POST")
-	adminAPI.HandleFunc("/containers/{id}", authHandler.RequireAuth(containerHandler.GetContainer)).Methods("GET")
-	adminAPI.HandleFunc("/containers/{id}/status", authHandler.RequireAdmin(containerHandler.GetContainerStatus)).Methods("GET")
-	adminAPI.HandleFunc("/containers/{id}/start", authHandler.RequireAdmin(containerHandler.StartContainer)).Methods("POST")
-	adminAPI.HandleFunc("/containers/{id}/stop", authHandler.RequireAdmin(containerHandler.StopContainer)).Methods("POST")
-	adminAPI.HandleFunc("/containers/{id}", authHandler.RequireAdmin(containerHandler.RemoveContainer)).Methods("DELETE")
-	adminAPI.HandleFunc("/containers/{id}/reset-password", authHandler.RequireAdmin(containerHandler.ResetContainerPassword)).Methods("PUT")
-	adminAPI.HandleFunc("/users/{userId:[0-9]+}/container", authHandler.RequireAuth(containerHandler.GetUserContainer)).Methods("GET")
+	adminAPI.HandleFunc("/containers", authHandler.RequirePermission(models.PermContainersRead, containerHandler.ListContainers)).Methods("GET")
+	adminAPI.HandleFunc("/containers", authHandler.RequirePermission(models.PermContainersAdmin, containerHandler.CreateContainer)).Methods("POST")
+	adminAPI.HandleFunc("/containers/limits", authHandler.RequirePermission(models.PermContainersRead, containerHandler.GetResourceLimits)).Methods("GET")
+	adminAPI.HandleFunc("/containers/{id}", authHandler.RequireOwner(models.PermContainersRead, authHandler.ContainerOwner("id"), containerHandler.GetContainer)).Methods("GET")
+	adminAPI.HandleFunc("/containers/{id}/status", authHandler.RequireManaged(models.PermContainersRead, authHandler.ContainerOwner("id"), containerHandler.GetContainerStatus)).Methods("GET")
+	adminAPI.HandleFunc("/containers/{id}/start", authHandler.RequireManaged(models.PermContainersOp, authHandler.ContainerOwner("id"), containerHandler.StartContainer)).Methods("POST")
+	adminAPI.HandleFunc("/containers/{id}/stop", authHandler.RequireManaged(models.PermContainersOp, authHandler.ContainerOwner("id"), containerHandler.StopContainer)).Methods("POST")
+	adminAPI.HandleFunc("/containers/{id}", authHandler.RequireManaged(models.PermContainersAdmin, authHandler.ContainerOwner("id"), containerHandler.RemoveContainer)).Methods("DELETE")
+	adminAPI.HandleFunc("/containers/{id}/reset-password", authHandler.RequireManaged(models.PermContainersOp, authHandler.ContainerOwner("id"), containerHandler.ResetContainerPassword)).Methods("PUT")
+	adminAPI.HandleFunc("/containers/{id}/resources", authHandler.RequireManaged(models.PermContainersAdmin, authHandler.ContainerOwner("id"), containerHandler.ResizeContainer)).Methods("PATCH")
+	adminAPI.HandleFunc("/users/{userId:[0-9]+}/container", authHandler.RequireOwner(models.PermContainersRead, authHandler.UserOwner("userId"), containerHandler.GetUserContainer)).Methods("GET")
+
+	// GPU 清单与占用
+	gpuHandler := handlers.NewGPUHandler()
+	adminAPI.HandleFunc("/gpus", authHandler.RequirePermission(models.PermContainersRead, gpuHandler.ListGPUs)).Methods("GET")
+	adminAPI.HandleFunc("/gpus/refresh", authHandler.RequirePermission(models.PermContainersAdmin, gpuHandler.RefreshGPUs)).Methods("POST")
+
+	// GPU 预约
+	gpuLeaseHandler := handlers.NewGPULeaseHandler()
+	adminAPI.HandleFunc("/gpu-leases", authHandler.RequirePermission(models.PermContainersRead, gpuLeaseHandler.ListLeases)).Methods("GET")
+	adminAPI.HandleFunc("/gpu-leases", authHandler.RequirePermission(models.PermContainersAdmin, gpuLeaseHandler.CreateLease)).Methods("POST")
+	adminAPI.HandleFunc("/gpu-leases/{id:[0-9]+}", authHandler.RequirePermission(models.PermContainersAdmin, gpuLeaseHandler.CancelLease)).Methods("DELETE")
+
+	// 普通用户自助路由 (只能操作自己的账户和容器)
+	portalHandler, err := handlers.NewPortalHandler()
+	if err != nil {
+		log.Fatal("Failed to create portal handler:", err)
+	}
+
+	api.HandleFunc("/me", authHandler.RequireAuth(portalHandler.GetMe)).Methods("GET")
+	api.HandleFunc("/me/container", authHandler.RequireAuth(portalHandler.GetMyContainer)).Methods("GET")
+	api.HandleFunc("/me/ports", authHandler.RequireAuth(portalHandler.GetMyPorts)).Methods("GET")
+	api.HandleFunc("/me/container/start", authHandler.RequireAuth(portalHandler.StartMyContainer)).Methods("POST")
+	api.HandleFunc("/me/container/stop", authHandler.RequireAuth(portalHandler.StopMyContainer)).Methods("POST")
+	api.HandleFunc("/me/password", authHandler.RequireAuth(portalHandler.ChangeMyPassword)).Methods("PUT")
+	api.HandleFunc("/me/gpu-leases", authHandler.RequireAuth(gpuLeaseHandler.ListMyLeases)).Methods("GET")
+	api.HandleFunc("/me/gpu-leases", authHandler.RequireAuth(gpuLeaseHandler.CreateMyLease)).Methods("POST")
+	api.HandleFunc("/me/gpu-leases/{id:[0-9]+}", authHandler.RequireAuth(gpuLeaseHandler.CancelMyLease)).Methods("DELETE")
+	api.HandleFunc("/me/gpu-calendar", authHandler.RequireAuth(gpuLeaseHandler.GetGPUCalendar)).Methods("GET")
+
+	// token验证公钥 (仅非对称密钥)
+	router.HandleFunc("/.well-known/jwks.json", authHandler.JWKS).Methods("GET")
 
 	// 静态文件服务
 	router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", 
@@ -89,11 +248,12 @@ func main() {
 	// CORS设置
 	c := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders: []string{"*"},
+		ExposedHeaders: []string{"X-MFA-Required", "X-MFA-Enrollment-Required", "X-Password-Change-Required"},
 	})
 
-	handler := c.Handler(router)
+	handler := c.Handler(handlers.StripIdentityHeaders(router))
 
 	// 启动服务器
 	port := os.Getenv("PORT")
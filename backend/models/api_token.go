@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// 个人访问令牌的权限范围
+const (
+	ScopeProfileRead     = "profile:read"
+	ScopeContainersRead  = "containers:read"
+	ScopeContainersWrite = "containers:write"
+	ScopeUsersRead       = "users:read"
+	ScopeUsersWrite      = "users:write"
+)
+
+// AllScopes 可授予令牌的全部权限范围
+var AllScopes = []string{
+	ScopeProfileRead,
+	ScopeContainersRead,
+	ScopeContainersWrite,
+	ScopeUsersRead,
+	ScopeUsersWrite,
+}
+
+// APIToken 个人访问令牌，数据库只保存哈希
+type APIToken struct {
+	ID         int        `json:"id" db:"id"`
+	UserID     int        `json:"user_id" db:"user_id"`
+	Name       string     `json:"name" db:"name"`
+	Prefix     string     `json:"prefix" db:"token_prefix"` // 明文开头几位，便于用户辨认
+	Scopes     []string   `json:"scopes" db:"scopes"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at" db:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip" db:"last_used_ip"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// HasScope 令牌是否包含指定权限范围，写权限隐含读权限
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+		if scope == ScopeContainersRead && s == ScopeContainersWrite {
+			return true
+		}
+		if scope == ScopeUsersRead && s == ScopeUsersWrite {
+			return true
+		}
+	}
+	return false
+}
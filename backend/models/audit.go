@@ -0,0 +1,79 @@
+package models
+
+import "time"
+
+// 审计事件的操作类型
+const (
+	AuditUserCreate         = "user.create"
+	AuditUserUpdate         = "user.update"
+	AuditUserDelete         = "user.delete"
+	AuditUserPasswordReset  = "user.password_reset"
+	AuditUserRevokeSession  = "user.sessions_revoke"
+	AuditUserUnlock         = "user.unlock"
+	AuditUserImport         = "user.import"
+	AuditUserExpire         = "user.expire"
+	AuditUserDeprovision    = "user.deprovision"
+	AuditUserRestore        = "user.restore"
+	AuditUserPurge          = "user.purge"
+	AuditUserPorts          = "user.ports_reassign"
+	AuditUserIdentityLink   = "user.identity_link"
+	AuditUserIdentityUnlink = "user.identity_unlink"
+	AuditUserRoles          = "user.roles"
+	AuditUserMFAReset       = "user.mfa_reset"
+	AuditUserTokenRevoke    = "user.token_revoke"
+
+	AuditContainerCreate        = "container.create"
+	AuditContainerStart         = "container.start"
+	AuditContainerStop          = "container.stop"
+	AuditContainerDelete        = "container.delete"
+	AuditContainerPasswordReset = "container.password_reset"
+	AuditContainerResize        = "container.resize"
+
+	AuditGPULeaseCreate = "gpu_lease.create"
+	AuditGPULeaseCancel = "gpu_lease.cancel"
+	AuditGPULeaseAttach = "gpu_lease.attach"
+	AuditGPULeaseDetach = "gpu_lease.detach"
+
+	AuditGroupMemberSet    = "group.member_set"
+	AuditGroupMemberRemove = "group.member_remove"
+
+	AuditSettingsSecurity = "settings.security"
+)
+
+// 审计事件结果
+const (
+	AuditResultSuccess = "success"
+	AuditResultFailure = "failure"
+)
+
+// AuditChange 单个字段的变更前后值，敏感字段只记录是否变更
+type AuditChange struct {
+	Old interface{} `json:"old,omitempty"`
+	New interface{} `json:"new,omitempty"`
+}
+
+// AuditEvent 一次管理操作的审计记录，操作人被删除后记录仍保留
+type AuditEvent struct {
+	ID         int64                  `json:"id" db:"id"`
+	ActorID    int                    `json:"actor_id" db:"actor_id"`
+	ActorName  string                 `json:"actor_name" db:"actor_name"`
+	Action     string                 `json:"action" db:"action"`
+	TargetType string                 `json:"target_type" db:"target_type"`
+	TargetID   string                 `json:"target_id" db:"target_id"`
+	SourceIP   string                 `json:"source_ip" db:"source_ip"`
+	Result     string                 `json:"result" db:"result"`
+	Error      string                 `json:"error,omitempty" db:"error"`
+	Changes    map[string]AuditChange `json:"changes,omitempty" db:"changes"`
+	CreatedAt  time.Time              `json:"created_at" db:"created_at"`
+}
+
+// SetChange 记录字段变更，新旧值相同时忽略
+func (e *AuditEvent) SetChange(field string, old, new interface{}) {
+	if old == new {
+		return
+	}
+	if e.Changes == nil {
+		e.Changes = map[string]AuditChange{}
+	}
+	e.Changes[field] = AuditChange{Old: old, New: new}
+}
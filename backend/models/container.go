@@ -10,10 +10,25 @@ type Container struct {
 	ImageName   string    `json:"image_name" db:"image_name"`
 	CPULimit    string    `json:"cpu_limit" db:"cpu_limit"`
 	MemoryLimit string    `json:"memory_limit" db:"memory_limit"`
+	SwapLimit   string    `json:"swap_limit" db:"swap_limit"` // 内存之外可用的交换空间
+	PidsLimit   int64     `json:"pids_limit" db:"pids_limit"`
+	ShmSize     string    `json:"shm_size" db:"shm_size"`
 	GPUDevices  string    `json:"gpu_devices" db:"gpu_devices"` // GPU设备ID，逗号分隔
+	GPUMode     string    `json:"gpu_mode,omitempty"`           // 来自 gpu_assignments，没有占用记录时为空
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
 	LastSeen    time.Time `json:"last_seen" db:"last_seen"`
+	Warnings    []string  `json:"warnings,omitempty"` // 创建时的非致命问题，如按配置放行的 GPU 冲突
+}
+
+// ContainerResources 创建容器时请求的资源限制，留空的项使用平台默认值。
+// 内存类的值支持 512m、4g 这样的写法
+type ContainerResources struct {
+	CPUs      string `json:"cpus,omitempty"`
+	Memory    string `json:"memory,omitempty"`
+	Swap      string `json:"swap,omitempty"`
+	PidsLimit int64  `json:"pids_limit,omitempty"`
+	ShmSize   string `json:"shm_size,omitempty"`
 }
 
 type ContainerStats struct {
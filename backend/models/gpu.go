@@ -0,0 +1,77 @@
+package models
+
+import "time"
+
+// GPU 分配方式
+const (
+	GPUModeExclusive = "exclusive" // 独占，其他容器不能再使用该 GPU
+	GPUModeShared    = "shared"    // 共享，可与其他共享方式的容器同时使用
+)
+
+// GPU 主机上的一块 GPU，以 UUID 标识，序号在重启或插拔后可能变化
+type GPU struct {
+	UUID        string           `json:"uuid" db:"uuid"`
+	Index       int              `json:"index" db:"gpu_index"`
+	Model       string           `json:"model" db:"model"`
+	MemoryMB    int              `json:"memory_mb" db:"memory_mb"`
+	Present     bool             `json:"present" db:"present"` // 最近一次盘点时是否还在
+	LastSeen    time.Time        `json:"last_seen" db:"last_seen"`
+	Assignments []*GPUAssignment `json:"assignments"`
+}
+
+// GPUAssignment 容器对 GPU 的占用
+type GPUAssignment struct {
+	GPUUUID       string    `json:"gpu_uuid" db:"gpu_uuid"`
+	GPUIndex      int       `json:"gpu_index"`
+	ContainerID   string    `json:"container_id" db:"container_id"`
+	ContainerName string    `json:"container_name"`
+	UserID        int       `json:"user_id"`
+	Username      string    `json:"username"`
+	Mode          string    `json:"mode" db:"mode"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	// 其他用户的租约对 GPU 的预约，不是容器的实际占用，此时 CreatedAt 为租约开始时间
+	LeaseID int        `json:"lease_id,omitempty"`
+	EndsAt  *time.Time `json:"ends_at,omitempty"`
+}
+
+// ValidGPUMode 判断分配方式是否受支持
+func ValidGPUMode(mode string) bool {
+	return mode == GPUModeExclusive || mode == GPUModeShared
+}
+
+// GPU 租约状态
+const (
+	GPULeaseScheduled = "scheduled" // 已预约，尚未开始
+	GPULeaseActive    = "active"    // 进行中，GPU 已挂载到用户容器
+	GPULeaseEnded     = "ended"
+	GPULeaseCancelled = "cancelled"
+)
+
+// GPULease 用户在一段时间内预约的 GPU。开始时用户容器按租约的 GPU 重建，结束时恢复原来的 GPU 配置
+type GPULease struct {
+	ID            int        `json:"id" db:"id"`
+	UserID        int        `json:"user_id" db:"user_id"`
+	Username      string     `json:"username"`
+	GPUUUIDs      []string   `json:"gpu_uuids"`
+	GPUIndexes    []int      `json:"gpu_indexes"`
+	Mode          string     `json:"mode" db:"mode"`
+	StartsAt      time.Time  `json:"starts_at" db:"starts_at"`
+	EndsAt        time.Time  `json:"ends_at" db:"ends_at"`
+	Status        string     `json:"status" db:"status"`
+	Note          string     `json:"note,omitempty" db:"note"`
+	LastError     string     `json:"last_error,omitempty" db:"last_error"`
+	AttachedAt    *time.Time `json:"attached_at,omitempty" db:"attached_at"`
+	DetachedAt    *time.Time `json:"detached_at,omitempty" db:"detached_at"`
+	CreatedBy     int        `json:"created_by" db:"created_by"`
+	CreatedByName string     `json:"created_by_name" db:"created_by_name"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	// 租约开始前容器的 GPU 配置，结束时恢复。PreviousContainerID 在第一次重建前记录，非空表示已保存
+	PreviousGPUDevices  string `json:"-" db:"previous_gpu_devices"`
+	PreviousGPUMode     string `json:"-" db:"previous_gpu_mode"`
+	PreviousContainerID string `json:"-" db:"previous_container_id"`
+	// 开始和结束提醒的发送时间，重建容器前至少提前 GPU_LEASE_NOTICE 提醒
+	StartWarnedAt *time.Time `json:"-" db:"start_warned_at"`
+	EndWarnedAt   *time.Time `json:"-" db:"end_warned_at"`
+	// 创建时发现的、可能导致租约无法按时挂载的占用
+	Warnings []string `json:"warnings,omitempty"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"regexp"
+	"time"
+)
+
+// groupNamePattern 组名同时用作共享目录名，只允许小写字母、数字、下划线和连字符
+var groupNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,49}$`)
+
+// Group 团队/项目组，成员容器会挂载组共享目录
+type Group struct {
+	ID          int       `json:"id" db:"id"`
+	Name        string    `json:"name" db:"name"`
+	Description string    `json:"description" db:"description"`
+	MemberCount int       `json:"member_count"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// GroupMember 组成员，IsAdmin 为组管理员，可管理本组成员的容器
+type GroupMember struct {
+	GroupID  int       `json:"group_id" db:"group_id"`
+	UserID   int       `json:"user_id" db:"user_id"`
+	Username string    `json:"username"`
+	Email    string    `json:"email"`
+	IsAdmin  bool      `json:"is_admin" db:"is_admin"`
+	JoinedAt time.Time `json:"joined_at" db:"joined_at"`
+}
+
+// GroupAdminPermissions 组管理员对本组成员拥有的权限点
+var GroupAdminPermissions = []string{PermContainersRead, PermContainersOp, PermContainersAdmin}
+
+// ValidGroupName 组名是否可以安全地用作目录名
+func ValidGroupName(name string) bool {
+	return groupNamePattern.MatchString(name)
+}
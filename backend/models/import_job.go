@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+// 批量导入任务状态
+const (
+	ImportJobPending   = "pending"
+	ImportJobRunning   = "running"
+	ImportJobCompleted = "completed"
+	ImportJobFailed    = "failed"
+)
+
+// 单行导入结果
+const (
+	ImportRowCreated = "created" // 已创建（dry-run 时表示校验通过，可以创建）
+	ImportRowFailed  = "failed"
+	ImportRowSkipped = "skipped"
+)
+
+// ImportRow 导入文件中的一行
+type ImportRow struct {
+	Username   string `json:"username"`
+	Email      string `json:"email"`
+	Password   string `json:"password,omitempty"` // 为空时自动生成
+	GPUDevices string `json:"gpu_devices"`
+}
+
+// ImportRowResult 单行的处理结果，不包含密码，生成的凭据只出现在导出报告中
+type ImportRowResult struct {
+	Line        int    `json:"line"`
+	Username    string `json:"username"`
+	Email       string `json:"email"`
+	GPUDevices  string `json:"gpu_devices,omitempty"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+	UserID      int    `json:"user_id,omitempty"`
+	ContainerID string `json:"container_id,omitempty"`
+}
+
+// ImportJob 一次批量导入任务
+type ImportJob struct {
+	ID               int                `json:"id" db:"id"`
+	CreatedBy        int                `json:"created_by" db:"created_by"`
+	CreatedByName    string             `json:"created_by_name" db:"created_by_name"`
+	Status           string             `json:"status" db:"status"`
+	DryRun           bool               `json:"dry_run" db:"dry_run"`
+	CreateContainers bool               `json:"create_containers" db:"create_containers"`
+	Total            int                `json:"total" db:"total"`
+	Succeeded        int                `json:"succeeded" db:"succeeded"`
+	Failed           int                `json:"failed" db:"failed"`
+	Results          []*ImportRowResult `json:"results,omitempty" db:"results"`
+	Error            string             `json:"error,omitempty" db:"error"`
+	CreatedAt        time.Time          `json:"created_at" db:"created_at"`
+	FinishedAt       *time.Time         `json:"finished_at,omitempty" db:"finished_at"`
+}
@@ -0,0 +1,63 @@
+package models
+
+import "time"
+
+// 后台操作类型
+const (
+	OperationUserPurge         = "user.purge"
+	OperationContainerRecreate = "container.recreate"
+)
+
+// 后台操作状态
+const (
+	OperationRunning   = "running"
+	OperationCompleted = "completed"
+	OperationPartial   = "partial" // 部分步骤失败，已完成的步骤不会回滚
+	OperationFailed    = "failed"
+)
+
+// 单个步骤的结果
+const (
+	StepSucceeded = "succeeded"
+	StepFailed    = "failed"
+	StepSkipped   = "skipped"
+)
+
+// OperationStep 后台操作中的一个步骤
+type OperationStep struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Operation 需要多个步骤、可能部分失败的后台操作，如删除用户时清理容器和数据
+type Operation struct {
+	ID            int              `json:"id" db:"id"`
+	Type          string           `json:"type" db:"type"`
+	TargetType    string           `json:"target_type" db:"target_type"`
+	TargetID      string           `json:"target_id" db:"target_id"`
+	TargetName    string           `json:"target_name" db:"target_name"`
+	Status        string           `json:"status" db:"status"`
+	Steps         []*OperationStep `json:"steps" db:"steps"`
+	CreatedBy     int              `json:"created_by" db:"created_by"`
+	CreatedByName string           `json:"created_by_name" db:"created_by_name"`
+	CreatedAt     time.Time        `json:"created_at" db:"created_at"`
+	FinishedAt    *time.Time       `json:"finished_at,omitempty" db:"finished_at"`
+}
+
+// AddStep 追加步骤结果，err 非空时步骤记为失败
+func (o *Operation) AddStep(name, detail string, err error) *OperationStep {
+	step := &OperationStep{Name: name, Status: StepSucceeded, Detail: detail}
+	if err != nil {
+		step.Status = StepFailed
+		step.Error = err.Error()
+	}
+	o.Steps = append(o.Steps, step)
+	return step
+}
+
+// SkipStep 追加跳过的步骤
+func (o *Operation) SkipStep(name, reason string) {
+	o.Steps = append(o.Steps, &OperationStep{Name: name, Status: StepSkipped, Detail: reason})
+}
@@ -0,0 +1,29 @@
+package models
+
+// PortAllocation 用户占用的端口段
+type PortAllocation struct {
+	UserID      int    `json:"user_id"`
+	Username    string `json:"username"`
+	BasePort    int    `json:"base_port"`
+	EndPort     int    `json:"end_port"`
+	ContainerID string `json:"container_id,omitempty"`
+	Deleted     bool   `json:"deleted"`  // 在回收站中，彻底删除后端口段才释放
+	InRange     bool   `json:"in_range"` // 是否位于当前配置的分配范围内
+}
+
+// PortConflict 分配范围内被平台以外的程序或容器占用的主机端口
+type PortConflict struct {
+	Port  int    `json:"port"`
+	Owner string `json:"owner"`
+}
+
+// PortUsage 端口分配范围的使用情况
+type PortUsage struct {
+	RangeStart  int               `json:"range_start"`
+	RangeEnd    int               `json:"range_end"`
+	Step        int               `json:"step"`
+	TotalBlocks int               `json:"total_blocks"`
+	FreeBlocks  int               `json:"free_blocks"`
+	Allocations []*PortAllocation `json:"allocations"`
+	Conflicts   []*PortConflict   `json:"conflicts"`
+}
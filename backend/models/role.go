@@ -0,0 +1,95 @@
+package models
+
+import "time"
+
+// 权限点，路由按权限点而不是 is_admin 做访问控制
+const (
+	PermUsersRead       = "users.read"
+	PermUsersCreate     = "users.create"
+	PermUsersUpdate     = "users.update"
+	PermUsersDelete     = "users.delete"
+	PermUsersSecurity   = "users.security" // 重置密码、吊销会话/令牌、解锁、重置两步验证
+	PermContainersRead  = "containers.read"
+	PermContainersOp    = "containers.operate" // 启动、停止、重置SSH密码
+	PermContainersAdmin = "containers.manage"  // 创建、删除
+	PermGroupsManage    = "groups.manage"      // 创建/删除组、调整成员
+	PermSettingsManage  = "settings.manage"
+	PermAuditRead       = "audit.read"
+	PermRolesManage     = "roles.manage"
+)
+
+// AllPermissions 全部权限点
+var AllPermissions = []string{
+	PermUsersRead,
+	PermUsersCreate,
+	PermUsersUpdate,
+	PermUsersDelete,
+	PermUsersSecurity,
+	PermContainersRead,
+	PermContainersOp,
+	PermContainersAdmin,
+	PermGroupsManage,
+	PermSettingsManage,
+	PermAuditRead,
+	PermRolesManage,
+}
+
+// 内置角色名称
+const (
+	RolePlatformAdmin = "platform-admin"
+	RoleUserAdmin     = "user-admin"
+	RoleOperator      = "operator"
+	RoleViewer        = "viewer"
+)
+
+// RenamedBuiltinRoles 改过名的内置角色，旧名称 -> 新名称
+// 启动时就地改名，保留角色ID和已有的用户分配
+var RenamedBuiltinRoles = map[string]string{
+	"group-admin": RoleUserAdmin, // 与用户组的组管理员无关，容易误解
+}
+
+// BuiltinRoles 启动时写入数据库的内置角色，权限随版本更新
+var BuiltinRoles = []Role{
+	{
+		Name:        RolePlatformAdmin,
+		Description: "平台管理员，拥有全部权限",
+		Permissions: AllPermissions,
+	},
+	{
+		Name:        RoleUserAdmin,
+		Description: "用户和容器管理员，可管理全部用户（不限于某个组），不能删除用户、修改平台设置或管理角色",
+		Permissions: []string{
+			PermUsersRead, PermUsersCreate, PermUsersUpdate, PermUsersSecurity,
+			PermContainersRead, PermContainersOp, PermContainersAdmin,
+		},
+	},
+	{
+		Name:        RoleOperator,
+		Description: "运维人员，只能查看、启动、停止容器和重置SSH密码",
+		Permissions: []string{PermUsersRead, PermContainersRead, PermContainersOp},
+	},
+	{
+		Name:        RoleViewer,
+		Description: "只读用户，可查看用户和容器",
+		Permissions: []string{PermUsersRead, PermContainersRead},
+	},
+}
+
+type Role struct {
+	ID          int       `json:"id" db:"id"`
+	Name        string    `json:"name" db:"name"`
+	Description string    `json:"description" db:"description"`
+	IsBuiltin   bool      `json:"is_builtin" db:"is_builtin"`
+	Permissions []string  `json:"permissions"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// ValidPermission 是否为已定义的权限点
+func ValidPermission(perm string) bool {
+	for _, p := range AllPermissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
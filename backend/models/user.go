@@ -1,10 +1,14 @@
 package models
 
 import (
+	"regexp"
 	"time"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// usernamePattern 用户名同时用于容器名和家目录，只允许字母、数字、下划线、点和连字符
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,49}$`)
+
 type User struct {
 	ID          int       `json:"id" db:"id"`
 	Username    string    `json:"username" db:"username"`
@@ -19,6 +23,26 @@ type User struct {
 	ContainerID string    `json:"container_id" db:"container_id"`
 	BasePort    int       `json:"base_port" db:"base_port"` // SSH端口基数
 	LastLogin   time.Time `json:"last_login" db:"last_login"`
+
+	// 管理员设置或初始化的密码，登录后必须先修改才能使用其他接口
+	MustChangePassword bool `json:"must_change_password" db:"must_change_password"`
+
+	// 账户到期时间，到期后无法登录，由到期调度器禁用并回收容器
+	ExpiresAt *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+
+	// 移入回收站的时间，回收站中的账户无法登录，保留期满后彻底删除
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+
+	// 通过角色获得的权限点，仅在登录和 /api/me 响应中填充
+	Permissions []string `json:"permissions,omitempty" db:"-"`
+}
+
+// UserIdentity 本地账户与外部身份（OIDC、LDAP）的关联，外部登录只按关联查找账户
+type UserIdentity struct {
+	Provider  string    `json:"provider" db:"provider"` // "ldap" 或 "oidc:<issuer>"
+	Subject   string    `json:"subject" db:"subject"`   // 身份源内不变的唯一标识
+	UserID    int       `json:"user_id" db:"user_id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
 
 // HashPassword 加密密码
@@ -37,6 +61,9 @@ func (u *User) CheckPassword(password string) bool {
 	return err == nil
 }
 
+// UserPortCount 每个用户占用的端口数，即 GetPorts 中 base_port+0 到 base_port+9
+const UserPortCount = 10
+
 // GetPorts 获取用户的服务端口
 func (u *User) GetPorts() map[string]int {
 	return map[string]int{
@@ -51,4 +78,19 @@ func (u *User) GetPorts() map[string]int {
 		"app6":    u.BasePort + 8, // 末尾8: 备用应用6
 		"app7":    u.BasePort + 9, // 末尾9: 备用应用7
 	}
-}
\ No newline at end of file
+}
+
+// Expired 账户是否已过到期时间
+func (u *User) Expired() bool {
+	return u.ExpiresAt != nil && !u.ExpiresAt.After(time.Now())
+}
+
+// CanLogin 账户是否启用、未到期且不在回收站中
+func (u *User) CanLogin() bool {
+	return u.IsActive && !u.Expired() && u.DeletedAt == nil
+}
+
+// ValidUsername 检查用户名格式
+func ValidUsername(username string) bool {
+	return usernamePattern.MatchString(username)
+}
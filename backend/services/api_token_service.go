@@ -0,0 +1,194 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"gpu-dev-platform/database"
+	"gpu-dev-platform/models"
+)
+
+// APITokenPrefix 个人访问令牌的固定前缀，用于和JWT区分
+const APITokenPrefix = "ai4s_"
+
+var (
+	ErrInvalidAPIToken = errors.New("invalid api token")
+	ErrUnknownScope    = errors.New("unknown scope")
+)
+
+var (
+	DefaultAPITokenTTL = parseDurationEnv("API_TOKEN_DEFAULT_TTL", 90*24*time.Hour)
+	MaxAPITokenTTL     = parseDurationEnv("API_TOKEN_MAX_TTL", 365*24*time.Hour)
+)
+
+// APITokenService 个人访问令牌的创建、校验和吊销
+type APITokenService struct {
+	db *sql.DB
+}
+
+func NewAPITokenService() *APITokenService {
+	return &APITokenService{db: database.DB}
+}
+
+// IsAPIToken 判断Bearer凭据是否为个人访问令牌
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+// CreateToken 创建令牌，明文只在返回值中出现这一次
+func (s *APITokenService) CreateToken(userID int, name string, scopes []string, ttl time.Duration) (*models.APIToken, string, error) {
+	for _, scope := range scopes {
+		if !validScope(scope) {
+			return nil, "", ErrUnknownScope
+		}
+	}
+
+	if ttl <= 0 {
+		ttl = DefaultAPITokenTTL
+	}
+	if ttl > MaxAPITokenTTL {
+		ttl = MaxAPITokenTTL
+	}
+
+	raw, err := randomToken(20)
+	if err != nil {
+		return nil, "", err
+	}
+	plaintext := APITokenPrefix + raw
+
+	token := &models.APIToken{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    plaintext[:len(APITokenPrefix)+6],
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+
+	result, err := s.db.Exec(`
+		INSERT INTO api_tokens (user_id, name, token_prefix, token_hash, scopes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, token.UserID, token.Name, token.Prefix, hashToken(plaintext), strings.Join(scopes, ","),
+		token.CreatedAt, token.ExpiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, "", err
+	}
+	token.ID = int(id)
+
+	return token, plaintext, nil
+}
+
+// Authenticate 校验令牌并记录最近使用时间
+func (s *APITokenService) Authenticate(plaintext, ip string) (*models.APIToken, error) {
+	rows, err := s.db.Query(apiTokenSelect+`
+		WHERE token_hash = ? AND revoked_at IS NULL AND expires_at > ?
+	`, hashToken(plaintext), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := scanAPITokens(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, ErrInvalidAPIToken
+	}
+	token := tokens[0]
+
+	// 最近使用时间精确到分钟即可，避免每个请求都写库
+	now := time.Now()
+	_, _ = s.db.Exec(`
+		UPDATE api_tokens SET last_used_at = ?, last_used_ip = ?
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)
+	`, now, ip, token.ID, now.Add(-time.Minute))
+
+	return token, nil
+}
+
+// ListTokens 列出用户的令牌（包括已吊销和已过期的）
+func (s *APITokenService) ListTokens(userID int) ([]*models.APIToken, error) {
+	rows, err := s.db.Query(apiTokenSelect+" WHERE user_id = ? ORDER BY created_at DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanAPITokens(rows)
+}
+
+// GetToken 返回用户的某个令牌，令牌不属于该用户时返回 sql.ErrNoRows
+func (s *APITokenService) GetToken(userID, tokenID int) (*models.APIToken, error) {
+	rows, err := s.db.Query(apiTokenSelect+" WHERE id = ? AND user_id = ?", tokenID, userID)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := scanAPITokens(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return tokens[0], nil
+}
+
+// RevokeToken 吊销用户的某个令牌，令牌不属于该用户时返回 sql.ErrNoRows
+func (s *APITokenService) RevokeToken(userID, tokenID int) error {
+	result, err := s.db.Exec("UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		time.Now(), tokenID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+const apiTokenSelect = `
+	SELECT id, user_id, name, token_prefix, scopes, created_at, expires_at,
+	       last_used_at, COALESCE(last_used_ip, ''), revoked_at
+	FROM api_tokens`
+
+func scanAPITokens(rows *sql.Rows) ([]*models.APIToken, error) {
+	defer rows.Close()
+
+	tokens := []*models.APIToken{}
+	for rows.Next() {
+		token := &models.APIToken{}
+		var scopes string
+		var lastUsedAt, revokedAt sql.NullTime
+		err := rows.Scan(&token.ID, &token.UserID, &token.Name, &token.Prefix, &scopes,
+			&token.CreatedAt, &token.ExpiresAt, &lastUsedAt, &token.LastUsedIP, &revokedAt)
+		if err != nil {
+			return nil, err
+		}
+		if scopes != "" {
+			token.Scopes = strings.Split(scopes, ",")
+		} else {
+			token.Scopes = []string{}
+		}
+		if lastUsedAt.Valid {
+			token.LastUsedAt = &lastUsedAt.Time
+		}
+		if revokedAt.Valid {
+			token.RevokedAt = &revokedAt.Time
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func validScope(scope string) bool {
+	for _, s := range models.AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
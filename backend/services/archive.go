@@ -0,0 +1,110 @@
+package services
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var ErrUnsafeDataPath = errors.New("user data path escapes USERS_DATA_PATH")
+
+// userDataDir 用户家目录在管理后端容器内的路径。
+// 旧账户和外部身份开通的账户的用户名未必经过格式校验，归档或删除前必须确认路径
+// 恰好是 USERS_DATA_PATH 下的一级子目录，否则返回 ErrUnsafeDataPath
+func userDataDir(username string) (string, error) {
+	base := filepath.Clean(getEnvWithDefault("USERS_DATA_PATH", "/app/users"))
+	dir := filepath.Clean(filepath.Join(base, username))
+	if dir == base || filepath.Dir(dir) != base || filepath.Base(dir) != username {
+		return "", fmt.Errorf("%w: %q", ErrUnsafeDataPath, username)
+	}
+	return dir, nil
+}
+
+// archiveUserData 将用户家目录打包为 USER_ARCHIVE_PATH/<username>-<时间>.tar.gz，
+// 目录不存在时返回空路径
+func archiveUserData(username string) (string, error) {
+	dir, err := userDataDir(username)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return "", nil
+	}
+
+	archiveDir := getEnvWithDefault("USER_ARCHIVE_PATH", "/app/archive")
+	if err := os.MkdirAll(archiveDir, 0700); err != nil {
+		return "", err
+	}
+	dest := filepath.Join(archiveDir, fmt.Sprintf("%s-%s.tar.gz", username, time.Now().Format("20060102-150405")))
+
+	if err := writeTarGz(dir, dest); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+// writeTarGz 先写临时文件再改名，避免留下不完整的归档
+func writeTarGz(dir, dest string) error {
+	tmp := dest + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	base := filepath.Base(dir)
+
+	walkErr := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(filepath.Join(base, rel))
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		// 只有普通文件有内容，目录、链接、设备文件只写头
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+
+	for _, closeErr := range []error{tw.Close(), gz.Close(), file.Close()} {
+		if walkErr == nil {
+			walkErr = closeErr
+		}
+	}
+	if walkErr != nil {
+		os.Remove(tmp)
+		return walkErr
+	}
+	return os.Rename(tmp, dest)
+}
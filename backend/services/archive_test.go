@@ -0,0 +1,53 @@
+package services
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUserDataDir(t *testing.T) {
+	t.Setenv("USERS_DATA_PATH", "/data/users/")
+
+	tests := []struct {
+		username string
+		want     string // 空表示应拒绝
+	}{
+		{username: "alice", want: "/data/users/alice"},
+		{username: "alice.smith-2", want: "/data/users/alice.smith-2"},
+		{username: "alice@corp", want: "/data/users/alice@corp"},
+		{username: ""},
+		{username: "."},
+		{username: ".."},
+		{username: "../users"},
+		{username: "../../etc"},
+		{username: "a/../b"},
+		{username: "alice/projects"},
+		{username: "alice/"},
+		{username: "/etc"},
+		{username: "./alice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			got, err := userDataDir(tt.username)
+			if tt.want == "" {
+				if !errors.Is(err, ErrUnsafeDataPath) {
+					t.Fatalf("userDataDir(%q) = %q, %v; want ErrUnsafeDataPath", tt.username, got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("userDataDir(%q) = %q, %v; want %q", tt.username, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestArchiveUserDataRejectsUnsafeUsername(t *testing.T) {
+	t.Setenv("USERS_DATA_PATH", t.TempDir())
+	t.Setenv("USER_ARCHIVE_PATH", t.TempDir())
+
+	if _, err := archiveUserData("../escape"); !errors.Is(err, ErrUnsafeDataPath) {
+		t.Fatalf("archiveUserData = %v, want ErrUnsafeDataPath", err)
+	}
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"gpu-dev-platform/database"
+	"gpu-dev-platform/models"
+)
+
+// AuditFilter 审计日志查询条件，零值字段不参与过滤
+type AuditFilter struct {
+	ActorID    int
+	ActorName  string
+	Action     string // 精确匹配，或以 "." 结尾时按前缀匹配，如 "user."
+	TargetType string
+	TargetID   string
+	Result     string
+	From       time.Time
+	To         time.Time
+	Page       int
+	PageSize   int
+}
+
+// AuditService 写入和查询 audit_events
+type AuditService struct {
+	db *sql.DB
+}
+
+func NewAuditService() *AuditService {
+	return &AuditService{db: database.DB}
+}
+
+// Record 按操作结果写入审计事件，写入失败只记录日志，不影响业务请求
+func (s *AuditService) Record(event *models.AuditEvent, opErr error) {
+	event.Result = models.AuditResultSuccess
+	if opErr != nil {
+		event.Result = models.AuditResultFailure
+		event.Error = opErr.Error()
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	var changes interface{}
+	if len(event.Changes) > 0 {
+		data, err := json.Marshal(event.Changes)
+		if err == nil {
+			changes = string(data)
+		}
+	}
+
+	_, err := s.db.Exec(`
+		INSERT INTO audit_events (actor_id, actor_name, action, target_type, target_id, source_ip, result, error, changes, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, event.ActorID, event.ActorName, event.Action, event.TargetType, event.TargetID,
+		event.SourceIP, event.Result, event.Error, changes, event.CreatedAt)
+	if err != nil {
+		log.Printf("failed to write audit event %s %s/%s: %v", event.Action, event.TargetType, event.TargetID, err)
+	}
+}
+
+// List 分页查询，按时间倒序，返回当前页和总条数
+func (s *AuditService) List(filter AuditFilter) ([]*models.AuditEvent, int, error) {
+	where, args := filter.where()
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM audit_events"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	if filter.PageSize <= 0 {
+		filter.PageSize = 50
+	}
+	if filter.Page <= 0 {
+		filter.Page = 1
+	}
+
+	rows, err := s.db.Query(auditEventSelect+where+" ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?",
+		append(args, filter.PageSize, (filter.Page-1)*filter.PageSize)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	events := []*models.AuditEvent{}
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		events = append(events, event)
+	}
+	return events, total, rows.Err()
+}
+
+// Each 按时间顺序遍历所有匹配的事件，用于导出，避免一次性载入内存
+func (s *AuditService) Each(filter AuditFilter, fn func(*models.AuditEvent) error) error {
+	where, args := filter.where()
+
+	rows, err := s.db.Query(auditEventSelect+where+" ORDER BY created_at, id", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (f AuditFilter) where() (string, []interface{}) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	if f.ActorID > 0 {
+		conditions = append(conditions, "actor_id = ?")
+		args = append(args, f.ActorID)
+	}
+	if f.ActorName != "" {
+		conditions = append(conditions, "actor_name = ?")
+		args = append(args, f.ActorName)
+	}
+	if f.Action != "" {
+		if strings.HasSuffix(f.Action, ".") {
+			conditions = append(conditions, "action LIKE ?")
+			args = append(args, f.Action+"%")
+		} else {
+			conditions = append(conditions, "action = ?")
+			args = append(args, f.Action)
+		}
+	}
+	if f.TargetType != "" {
+		conditions = append(conditions, "target_type = ?")
+		args = append(args, f.TargetType)
+	}
+	if f.TargetID != "" {
+		conditions = append(conditions, "target_id = ?")
+		args = append(args, f.TargetID)
+	}
+	if f.Result != "" {
+		conditions = append(conditions, "result = ?")
+		args = append(args, f.Result)
+	}
+	if !f.From.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, f.From)
+	}
+	if !f.To.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, f.To)
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+const auditEventSelect = `
+	SELECT id, actor_id, actor_name, action, target_type, target_id, source_ip, result,
+	       COALESCE(error, ''), COALESCE(changes, ''), created_at
+	FROM audit_events`
+
+func scanAuditEvent(rows *sql.Rows) (*models.AuditEvent, error) {
+	event := &models.AuditEvent{}
+	var changes string
+	err := rows.Scan(&event.ID, &event.ActorID, &event.ActorName, &event.Action, &event.TargetType,
+		&event.TargetID, &event.SourceIP, &event.Result, &event.Error, &changes, &event.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if changes != "" {
+		json.Unmarshal([]byte(changes), &event.Changes)
+	}
+	return event, nil
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+	"strings"
+
+	"gpu-dev-platform/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// dummyPasswordHash 与真实密码相同cost的哈希，用于拉平不存在账户的响应时间
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+// Authenticator 用户名密码认证后端，成功时返回对应的本地用户
+// 用户不存在或密码错误都返回 ErrInvalidCredentials，其他错误表示后端不可用
+type Authenticator interface {
+	Name() string
+	Authenticate(username, password string) (*models.User, error)
+}
+
+// LocalAuthenticator 使用 users 表中的bcrypt密码认证
+type LocalAuthenticator struct {
+	userService *UserService
+}
+
+func NewLocalAuthenticator(userService *UserService) *LocalAuthenticator {
+	return &LocalAuthenticator{userService: userService}
+}
+
+func (a *LocalAuthenticator) Name() string {
+	return "local"
+}
+
+func (a *LocalAuthenticator) Authenticate(username, password string) (*models.User, error) {
+	user, err := a.userService.GetUserByUsername(username)
+	if err == sql.ErrNoRows {
+		// 仍做一次bcrypt比较，避免通过响应时间判断账户是否存在
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.CheckPassword(password) {
+		return nil, ErrInvalidCredentials
+	}
+
+	return user, nil
+}
+
+// ChainAuthenticator 按顺序尝试多个后端，第一个成功的生效
+type ChainAuthenticator struct {
+	backends []Authenticator
+}
+
+func (a *ChainAuthenticator) Name() string {
+	names := make([]string, len(a.backends))
+	for i, b := range a.backends {
+		names[i] = b.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func (a *ChainAuthenticator) Authenticate(username, password string) (*models.User, error) {
+	var lastErr error = ErrInvalidCredentials
+	for _, backend := range a.backends {
+		user, err := backend.Authenticate(username, password)
+		if err == nil {
+			return user, nil
+		}
+		if err != ErrInvalidCredentials {
+			// 某个后端不可用时继续尝试下一个，保证本地管理员仍能登录
+			log.Printf("auth backend %s failed for %s: %v", backend.Name(), username, err)
+			lastErr = err
+		}
+	}
+	return nil, lastErr
+}
+
+// NewAuthenticatorFromEnv 根据 AUTH_BACKENDS（逗号分隔，默认 "local"）组装认证后端
+func NewAuthenticatorFromEnv(userService *UserService) (Authenticator, error) {
+	names := strings.Split(getEnvWithDefault("AUTH_BACKENDS", "local"), ",")
+
+	chain := &ChainAuthenticator{}
+	for _, name := range names {
+		switch strings.TrimSpace(name) {
+		case "local":
+			chain.backends = append(chain.backends, NewLocalAuthenticator(userService))
+		case "ldap":
+			cfg, err := LoadLDAPConfig()
+			if err != nil {
+				return nil, err
+			}
+			chain.backends = append(chain.backends, NewLDAPAuthenticator(cfg, userService))
+		case "":
+		default:
+			return nil, errors.New("unknown auth backend: " + name)
+		}
+	}
+
+	if len(chain.backends) == 0 {
+		return nil, errors.New("AUTH_BACKENDS is empty")
+	}
+	if len(chain.backends) == 1 {
+		return chain.backends[0], nil
+	}
+	return chain, nil
+}
+
+func envBool(key string, defaultValue bool) bool {
+	switch strings.ToLower(os.Getenv(key)) {
+	case "true", "1", "yes":
+		return true
+	case "false", "0", "no":
+		return false
+	}
+	return defaultValue
+}
@@ -0,0 +1,314 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gpu-dev-platform/models"
+	"github.com/docker/docker/api/types/container"
+)
+
+var ErrRecreatePasswordRequired = errors.New("重建容器需要提供新的服务登录密码")
+
+// RecreateRequiredError 请求修改了无法在线调整的项（共享内存、GPU），需要重建容器
+type RecreateRequiredError struct {
+	Fields []string
+}
+
+func (e *RecreateRequiredError) Error() string {
+	return fmt.Sprintf("%s 无法在运行中修改，需要重建容器。家目录会保留，容器内家目录以外的改动和进程会丢失", strings.Join(e.Fields, "、"))
+}
+
+// ResizeRequest 调整容器资源的请求，留空的项保持当前值
+type ResizeRequest struct {
+	Resources   models.ContainerResources
+	GPUDevices  *string
+	GPUMode     string
+	Recreate    bool   // 确认重建容器
+	Password    string // 重建后的服务登录密码，系统账户密码不会保留在家目录中
+	KeepStopped bool   // 重建后不启动，旧容器原来在运行也一样
+	ActorID     int
+	ActorName   string
+}
+
+// ResizeResult 调整结果，重建时附带操作记录
+type ResizeResult struct {
+	Container *models.Container `json:"container"`
+	Recreated bool              `json:"recreated"`
+	Operation *models.Operation `json:"operation,omitempty"`
+}
+
+// ResizeContainer 调整容器资源。CPU、内存、交换空间和进程数通过 Docker update 接口在线生效；
+// 共享内存和 GPU 需要重建容器，未确认 Recreate 时返回 RecreateRequiredError
+func (s *ContainerService) ResizeContainer(containerID string, req *ResizeRequest) (*ResizeResult, error) {
+	cont, err := s.GetContainerByID(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := currentResources(cont)
+	if req.Resources.CPUs != "" {
+		spec.CPUs = req.Resources.CPUs
+	}
+	if req.Resources.Memory != "" {
+		spec.Memory = req.Resources.Memory
+	}
+	if req.Resources.Swap != "" {
+		spec.Swap = req.Resources.Swap
+	}
+	if req.Resources.PidsLimit != 0 {
+		spec.PidsLimit = req.Resources.PidsLimit
+	}
+	if req.Resources.ShmSize != "" {
+		spec.ShmSize = req.Resources.ShmSize
+	}
+
+	limits, err := DefaultResourcePolicy().Resolve(spec)
+	if err != nil {
+		return nil, err
+	}
+	resolved := limits.Spec()
+
+	gpuService := DefaultGPUService()
+	gpu := GPURequest{Devices: cont.GPUDevices, Mode: cont.GPUMode}
+	// 调度分配的容器记录的是 UUID，与请求中的序号比较前同样规范化
+	currentDevices := cont.GPUDevices
+	if currentDevices != "" && gpuService.Enabled() {
+		if gpus, err := gpuService.Resolve(currentDevices); err == nil {
+			currentDevices = NormalizeDevices(currentDevices, gpus)
+		}
+	}
+	if req.GPUDevices != nil {
+		gpu.Devices = strings.TrimSpace(*req.GPUDevices)
+		// 按清单规范化后再比较，"1, 0" 与 "0,1" 视为相同
+		if gpu.Devices != "" && gpuService.Enabled() {
+			gpus, err := gpuService.Resolve(gpu.Devices)
+			if err != nil {
+				return nil, err
+			}
+			gpu.Devices = NormalizeDevices(gpu.Devices, gpus)
+		}
+	}
+	if req.GPUMode != "" {
+		if gpu.Mode, err = gpuService.Mode(req.GPUMode); err != nil {
+			return nil, err
+		}
+	}
+
+	var offline []string
+	if resolved.ShmSize != cont.ShmSize {
+		offline = append(offline, "shm_size")
+	}
+	if req.GPUDevices != nil && gpu.Devices != currentDevices {
+		offline = append(offline, "gpu_devices")
+	}
+	if gpu.Mode != cont.GPUMode && gpu.Devices != "" {
+		offline = append(offline, "gpu_mode")
+	}
+	if len(offline) > 0 {
+		if !req.Recreate {
+			return nil, &RecreateRequiredError{Fields: offline}
+		}
+		if req.Password == "" {
+			return nil, ErrRecreatePasswordRequired
+		}
+		return s.recreateContainer(cont, gpu, resolved, req)
+	}
+
+	if err := s.updateResources(cont.ID, limits); err != nil {
+		return nil, err
+	}
+	cont.CPULimit = resolved.CPUs
+	cont.MemoryLimit = resolved.Memory
+	cont.SwapLimit = resolved.Swap
+	cont.PidsLimit = resolved.PidsLimit
+	return &ResizeResult{Container: cont}, nil
+}
+
+// updateResources 在线修改资源限制并同步容器记录
+func (s *ContainerService) updateResources(containerID string, limits *ContainerLimits) error {
+	hostConfig := &container.HostConfig{}
+	limits.apply(hostConfig)
+
+	_, err := s.dockerClient.ContainerUpdate(context.Background(), containerID, container.UpdateConfig{
+		Resources: container.Resources{
+			NanoCPUs:   hostConfig.Resources.NanoCPUs,
+			Memory:     hostConfig.Resources.Memory,
+			MemorySwap: hostConfig.Resources.MemorySwap,
+			PidsLimit:  hostConfig.Resources.PidsLimit,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("调整容器资源失败: %v", err)
+	}
+
+	spec := limits.Spec()
+	_, err = s.db.Exec(`UPDATE containers SET cpu_limit = ?, memory_limit = ?, swap_limit = ?, pids_limit = ?, updated_at = ? WHERE id = ?`,
+		spec.CPUs, spec.Memory, spec.Swap, spec.PidsLimit, time.Now(), containerID)
+	return err
+}
+
+// recreateContainer 按新的资源和 GPU 配置重建容器。家目录是绑定挂载，重建后保留。
+// 旧容器先停止并改名，新容器以原名创建成功后才删除旧容器，旧容器原来在运行时新容器也要启动成功；
+// 任一步失败时删除新容器，把旧容器改回原名并恢复原来的运行状态，用户不会失去容器
+func (s *ContainerService) recreateContainer(old *models.Container, gpu GPURequest, resources *models.ContainerResources, req *ResizeRequest) (*ResizeResult, error) {
+	user, err := NewUserService().GetUserByID(old.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 动旧容器前确认新的 GPU 可以解析；冲突仅警告时照常重建，警告随新容器返回
+	gpuService := DefaultGPUService()
+	if gpu.Devices != "" && gpuService.Enabled() {
+		gpus, err := gpuService.Resolve(gpu.Devices)
+		if err != nil {
+			return nil, err
+		}
+		mode, err := gpuService.Mode(gpu.Mode)
+		if err != nil {
+			return nil, err
+		}
+		claim := gpuClaim(user, "", gpu)
+		claim.Replaces = old.ID
+		conflicts, err := gpuService.Conflicts(gpus, mode, claim)
+		if err != nil {
+			return nil, err
+		}
+		if len(conflicts) > 0 && !gpuService.WarnOnConflict() {
+			return nil, &GPUConflictError{Conflicts: conflicts}
+		}
+	}
+
+	wasRunning := false
+	if status, err := s.GetContainerActualStatus(old.ID); err == nil {
+		wasRunning = status == "running"
+	}
+	start := wasRunning && !req.KeepStopped
+
+	operations := NewOperationService()
+	op := &models.Operation{
+		Type:          models.OperationContainerRecreate,
+		TargetType:    "container",
+		TargetID:      old.ID,
+		TargetName:    old.Name,
+		CreatedBy:     req.ActorID,
+		CreatedByName: req.ActorName,
+	}
+	if err := operations.Create(op); err != nil {
+		return nil, err
+	}
+	defer operations.Finish(op)
+
+	// 旧容器必须停止才能释放端口，停止失败时不做任何改动
+	err = s.StopContainer(old.ID)
+	op.AddStep("stop_container", old.ID, err)
+	if err != nil {
+		return &ResizeResult{Operation: op}, fmt.Errorf("停止旧容器失败，容器未改动: %v", err)
+	}
+
+	// 新容器使用原来的容器名，旧容器先让出名字
+	backupName := fmt.Sprintf("%s-replaced-%d", old.Name, time.Now().Unix())
+	err = s.dockerClient.ContainerRename(context.Background(), old.ID, backupName)
+	op.AddStep("rename_old_container", backupName, err)
+	operations.Save(op)
+	if err != nil {
+		s.restoreContainer(op, old, "", start)
+		return &ResizeResult{Operation: op}, fmt.Errorf("重命名旧容器失败，已恢复原容器: %v", err)
+	}
+
+	cont, err := s.createContainer(user, gpu, req.Password, resources, old.ID, start)
+	detail := old.Name
+	if cont != nil {
+		detail = cont.ID
+	}
+	op.AddStep("create_container", detail, err)
+	operations.Save(op)
+	if err != nil {
+		if cont != nil {
+			op.AddStep("remove_new_container", cont.ID, s.RemoveContainer(cont.ID))
+		}
+		s.restoreContainer(op, old, backupName, start)
+		return &ResizeResult{Operation: op}, fmt.Errorf("新容器创建失败，已恢复原容器: %v", err)
+	}
+
+	// 新容器已就绪，旧容器删除失败不影响结果，只需手动清理
+	if err := s.RemoveContainer(old.ID); err != nil {
+		op.AddStep("remove_old_container", backupName, err)
+		cont.Warnings = append(cont.Warnings, fmt.Sprintf("旧容器 %s 删除失败，请手动删除: %v", backupName, err))
+	} else {
+		op.AddStep("remove_old_container", old.ID, nil)
+	}
+
+	return &ResizeResult{Container: cont, Recreated: true, Operation: op}, nil
+}
+
+// restoreContainer 重建失败时恢复旧容器：改回原名、重新指向用户，start 为 true 时重新启动。
+// backupName 为空表示尚未改名
+func (s *ContainerService) restoreContainer(op *models.Operation, old *models.Container, backupName string, start bool) {
+	if backupName != "" {
+		err := s.dockerClient.ContainerRename(context.Background(), old.ID, old.Name)
+		op.AddStep("rollback_rename", old.Name, err)
+	}
+
+	_, err := s.db.Exec("UPDATE users SET container_id = ? WHERE id = ?", old.ID, old.UserID)
+	op.AddStep("rollback_user_container", old.ID, err)
+
+	if start {
+		op.AddStep("rollback_start", old.ID, s.StartContainer(old.ID))
+	}
+}
+
+// currentResources 容器记录中的资源限制，旧容器未设置限制的项留空，由策略默认值补全
+func currentResources(cont *models.Container) *models.ContainerResources {
+	spec := &models.ContainerResources{
+		CPUs:      cont.CPULimit,
+		Memory:    cont.MemoryLimit,
+		Swap:      cont.SwapLimit,
+		PidsLimit: cont.PidsLimit,
+		ShmSize:   cont.ShmSize,
+	}
+	if spec.CPUs == "unlimited" {
+		spec.CPUs = ""
+	}
+	if spec.Memory == "unlimited" {
+		spec.Memory = ""
+	}
+	if spec.Swap == "unlimited" {
+		spec.Swap = ""
+	}
+	return spec
+}
+
+// RecreateWithGPUs 按新的 GPU 配置重建容器，资源限制和服务登录密码保持不变，供 GPU 租约挂载和卸载时使用。
+// 密码取自旧容器创建时的 DEV_PASSWORD，容器内另外修改过的系统账户密码会恢复为该密码。keepStopped 为 true 时重建后不启动
+func (s *ContainerService) RecreateWithGPUs(containerID string, gpu GPURequest, actorName string, keepStopped bool) (*ResizeResult, error) {
+	cont, err := s.GetContainerByID(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := s.dockerClient.ContainerInspect(context.Background(), containerID)
+	if err != nil {
+		return nil, fmt.Errorf("无法获取容器配置: %v", err)
+	}
+	var password string
+	for _, env := range info.Config.Env {
+		if strings.HasPrefix(env, "DEV_PASSWORD=") {
+			password = strings.TrimPrefix(env, "DEV_PASSWORD=")
+			break
+		}
+	}
+	if password == "" {
+		return nil, ErrRecreatePasswordRequired
+	}
+
+	limits, err := DefaultResourcePolicy().Resolve(currentResources(cont))
+	if err != nil {
+		// 上限调低后原有的限制可能不再合法，此时使用默认值，避免租约一直无法挂载
+		limits, _ = DefaultResourcePolicy().Resolve(nil)
+	}
+	return s.recreateContainer(cont, gpu, limits.Spec(), &ResizeRequest{Password: password, ActorName: actorName, KeepStopped: keepStopped})
+}
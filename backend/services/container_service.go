@@ -52,11 +52,66 @@ func NewContainerService() (*ContainerService, error) {
 }
 
 func (s *ContainerService) CreateContainer(user *models.User, gpuDevices string) (*models.Container, error) {
-	return s.CreateContainerWithPassword(user, gpuDevices, "defaultpass")
+	return s.CreateContainerWithPassword(user, GPURequest{Devices: gpuDevices}, "defaultpass", nil)
 }
 
-func (s *ContainerService) CreateContainerWithPassword(user *models.User, gpuDevices, password string) (*models.Container, error) {
+// CreateContainerWithPassword 创建并启动用户容器，resources 为 nil 或其中留空的项使用平台默认的资源限制。
+// 配置了 GPU 盘点时按清单解析 GPU 并检查与其他容器的冲突，按 GPU_CONFLICT_POLICY 拒绝或在 Warnings 中提示；
+// 请求按数量分配时由调度器挑选空闲的 GPU
+func (s *ContainerService) CreateContainerWithPassword(user *models.User, gpu GPURequest, password string, resources *models.ContainerResources) (*models.Container, error) {
+	cont, err := s.createContainer(user, gpu, password, resources, "", true)
+	if err != nil {
+		return nil, err
+	}
+	return cont, nil
+}
+
+// createContainer 创建用户容器，start 为 true 时随即启动。replaces 为重建时被替换、已停止并改名的旧容器，其 GPU 占用不计入冲突；
+// 启动失败时同时返回已创建的容器，由调用方决定是否删除
+func (s *ContainerService) createContainer(user *models.User, gpu GPURequest, password string, resources *models.ContainerResources, replaces string, start bool) (*models.Container, error) {
 	containerName := fmt.Sprintf("dev-%s", user.Username)
+
+	limits, err := DefaultResourcePolicy().Resolve(resources)
+	if err != nil {
+		return nil, err
+	}
+
+	gpuService := DefaultGPUService()
+	gpuDevices := strings.TrimSpace(gpu.Devices)
+	gpuMode, err := gpuService.Mode(gpu.Mode)
+	if err != nil {
+		return nil, err
+	}
+	var gpus []*models.GPU
+	var warnings []string
+	claim := gpuClaim(user, "", gpu)
+	claim.Replaces = replaces
+	scheduled := gpu.Scheduled()
+	if scheduled {
+		// 容器记录中保存调度选出的 UUID，序号变化后仍能对应到同一块 GPU
+		if gpus, err = gpuService.Schedule(gpu, gpuMode, claim); err != nil {
+			return nil, err
+		}
+		gpuDevices = strings.Join(gpuUUIDs(gpus), ",")
+	} else if gpuDevices != "" && gpuService.Enabled() {
+		gpus, err = gpuService.Resolve(gpuDevices)
+		if err != nil {
+			return nil, err
+		}
+		if gpus == nil {
+			warnings = append(warnings, "GPU 清单为空，未检查 GPU 冲突")
+		} else {
+			// 创建 Docker 容器前先检查一次，记录占用时在事务中再检查
+			conflicts, err := gpuService.Conflicts(gpus, gpuMode, claim)
+			if err != nil {
+				return nil, err
+			}
+			if len(conflicts) > 0 && !gpuService.WarnOnConflict() {
+				return nil, &GPUConflictError{Conflicts: conflicts}
+			}
+			gpuDevices = NormalizeDevices(gpuDevices, gpus)
+		}
+	}
 	
 	// 创建容器配置
 	config := &container.Config{
@@ -110,7 +165,6 @@ func (s *ContainerService) CreateContainerWithPassword(user *models.User, gpuDev
 	userDir := fmt.Sprintf("%s/%s", usersDataPath, user.Username)
 	os.MkdirAll(userDir, 0755)
 	os.MkdirAll(sharedDataPath, 0755)  
-	os.MkdirAll(workspaceDataPath, 0755)
 
 	// 从环境变量获取宿主机绝对路径
 	hostSharedPath := os.Getenv("HOST_SHARED_RO_PATH")
@@ -118,11 +172,6 @@ func (s *ContainerService) CreateContainerWithPassword(user *models.User, gpuDev
 		return nil, fmt.Errorf("HOST_SHARED_RO_PATH environment variable not set")
 	}
 	
-	hostWorkspacePath := os.Getenv("HOST_SHARED_RW_PATH")
-	if hostWorkspacePath == "" {
-		return nil, fmt.Errorf("HOST_SHARED_RW_PATH environment variable not set")
-	}
-	
 	hostUsersPath := os.Getenv("HOST_USERS_PATH")
 	if hostUsersPath == "" {
 		return nil, fmt.Errorf("HOST_USERS_PATH environment variable not set")
@@ -130,34 +179,62 @@ func (s *ContainerService) CreateContainerWithPassword(user *models.User, gpuDev
 	
 	hostUserDir := fmt.Sprintf("%s/%s", hostUsersPath, user.Username)
 
+	mounts := []mount.Mount{
+		{
+			Type:   mount.TypeBind,
+			Source: hostUserDir,
+			Target: containerHomePath,
+		},
+		{
+			Type:     mount.TypeBind,
+			Source:   hostSharedPath,
+			Target:   containerSharedPath,
+			ReadOnly: true,
+		},
+	}
+
+	// 全局读写工作区所有用户互相可见，需显式开启
+	if envBool("ENABLE_SHARED_WORKSPACE", false) {
+		hostWorkspacePath := os.Getenv("HOST_SHARED_RW_PATH")
+		if hostWorkspacePath == "" {
+			return nil, fmt.Errorf("HOST_SHARED_RW_PATH environment variable not set")
+		}
+		os.MkdirAll(workspaceDataPath, 0755)
+
+		mounts = append(mounts, mount.Mount{
+			Type:   mount.TypeBind,
+			Source: hostWorkspacePath,
+			Target: containerWorkspacePath,
+		})
+	}
+
+	// 挂载用户所在各组的共享目录
+	groupMounts, groupIDs, err := s.groupMounts(user)
+	if err != nil {
+		return nil, err
+	}
+	mounts = append(mounts, groupMounts...)
+	// 入口脚本按 组名:GID 创建组并把用户加入，组员才能访问 2770 的共享目录
+	config.Env = append(config.Env, "DEV_GROUPS="+strings.Join(groupIDs, ","))
+
 	hostConfig := &container.HostConfig{
 		PortBindings: s.getPortBindings(user),
-		Mounts: []mount.Mount{
-			{
-				Type:   mount.TypeBind,
-				Source: hostUserDir,
-				Target: containerHomePath,
-			},
-			{
-				Type:     mount.TypeBind,
-				Source:   hostSharedPath,
-				Target:   containerSharedPath,
-				ReadOnly: true,
-			},
-			{
-				Type:   mount.TypeBind,
-				Source: hostWorkspacePath,
-				Target: containerWorkspacePath,
-			},
-		},
-		Resources: container.Resources{},
+		Mounts:       mounts,
 		RestartPolicy: container.RestartPolicy{
 			Name: "unless-stopped",
 		},
 	}
+	limits.apply(hostConfig)
 
 	// 如果有GPU设备，添加GPU配置
-	if gpuDevices != "" {
+	if len(gpus) > 0 {
+		// 按 UUID 指定，GPU 序号变化后容器仍使用同一块 GPU
+		hostConfig.DeviceRequests = []container.DeviceRequest{{
+			Driver:       "nvidia",
+			Capabilities: [][]string{{"gpu"}},
+			DeviceIDs:    gpuUUIDs(gpus),
+		}}
+	} else if gpuDevices != "" {
 		// 解析GPU设备ID
 		deviceIDs := []string{}
 		if gpuDevices != "all" {
@@ -197,42 +274,72 @@ func (s *ContainerService) CreateContainerWithPassword(user *models.User, gpuDev
 	}
 
 	// 保存到数据库
+	spec := limits.Spec()
 	cont := &models.Container{
 		ID:          resp.ID,
 		UserID:      user.ID,
 		Name:        containerName,
 		Status:      "created",
 		ImageName:   userContainerImage,
-		CPULimit:    "unlimited",
-		MemoryLimit: "unlimited",
+		CPULimit:    spec.CPUs,
+		MemoryLimit: spec.Memory,
+		SwapLimit:   spec.Swap,
+		PidsLimit:   spec.PidsLimit,
+		ShmSize:     spec.ShmSize,
 		GPUDevices:  gpuDevices,
+		GPUMode:     gpuMode,
+		Warnings:    warnings,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 		LastSeen:    time.Now(),
 	}
 
 	query := `
-		INSERT INTO containers (id, user_id, name, status, image_name, cpu_limit, memory_limit, gpu_devices, created_at, updated_at, last_seen)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO containers (id, user_id, name, status, image_name, cpu_limit, memory_limit, swap_limit, pids_limit, shm_size, gpu_devices, created_at, updated_at, last_seen)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 	
 	_, err = s.db.Exec(query, cont.ID, cont.UserID, cont.Name, cont.Status,
-		cont.ImageName, cont.CPULimit, cont.MemoryLimit, cont.GPUDevices,
+		cont.ImageName, cont.CPULimit, cont.MemoryLimit, cont.SwapLimit, cont.PidsLimit, cont.ShmSize, cont.GPUDevices,
 		cont.CreatedAt, cont.UpdatedAt, cont.LastSeen)
 	if err != nil {
+		// 不留下没有记录的 Docker 容器，否则会一直占用容器名
+		s.RemoveContainer(resp.ID)
 		return nil, err
 	}
 
+	claim.ContainerID = cont.ID
+	if scheduled {
+		if err := gpuService.AssignScheduled(claim, gpus, gpuMode); err != nil {
+			s.RemoveContainer(cont.ID)
+			return nil, err
+		}
+	} else if len(gpus) > 0 {
+		conflicts, err := gpuService.Assign(claim, gpus, gpuMode)
+		if err != nil {
+			// 并发创建时被其他容器抢先占用
+			s.RemoveContainer(cont.ID)
+			return nil, err
+		}
+		cont.Warnings = append(cont.Warnings, GPUConflictWarnings(conflicts)...)
+	}
+
 	// 更新用户的容器ID
 	_, err = s.db.Exec("UPDATE users SET container_id = ? WHERE id = ?", 
 		cont.ID, user.ID)
 	if err != nil {
+		s.RemoveContainer(cont.ID)
 		return nil, err
 	}
 
+	// 重建原本已停止的容器时保持停止
+	if !start {
+		return cont, nil
+	}
+
 	// 自动启动容器
 	if err = s.StartContainer(cont.ID); err != nil {
-		return nil, fmt.Errorf("容器创建成功但启动失败: %v", err)
+		return cont, fmt.Errorf("容器创建成功但启动失败: %v", err)
 	}
 
 	// 更新状态为运行中
@@ -241,6 +348,47 @@ func (s *ContainerService) CreateContainerWithPassword(user *models.User, gpuDev
 	return cont, nil
 }
 
+// gpuClaim 容器使用 GPU 的时段：租约挂载的 GPU 到租约结束，否则到账户到期，未设置到期时间时为长期
+func gpuClaim(user *models.User, containerID string, gpu GPURequest) GPUClaim {
+	claim := GPUClaim{UserID: user.ID, ContainerID: containerID, Until: gpu.Until}
+	if claim.Until.IsZero() && user.ExpiresAt != nil {
+		claim.Until = *user.ExpiresAt
+	}
+	return claim
+}
+
+// groupMounts 为用户所在的每个组生成共享目录挂载，并返回 组名:GID 列表，成员变更在重建容器后生效
+func (s *ContainerService) groupMounts(user *models.User) ([]mount.Mount, []string, error) {
+	groups, err := NewGroupService().GroupsForUser(user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(groups) == 0 {
+		return nil, nil, nil
+	}
+
+	hostGroupsPath := os.Getenv("HOST_GROUPS_PATH")
+	if hostGroupsPath == "" {
+		return nil, nil, fmt.Errorf("HOST_GROUPS_PATH environment variable not set")
+	}
+	containerGroupsPath := getEnvWithDefault("CONTAINER_GROUPS_PATH", "/groups")
+
+	mounts := []mount.Mount{}
+	groupIDs := []string{}
+	for _, group := range groups {
+		if err := ensureGroupDir(group); err != nil {
+			return nil, nil, err
+		}
+		mounts = append(mounts, mount.Mount{
+			Type:   mount.TypeBind,
+			Source: fmt.Sprintf("%s/%s", hostGroupsPath, group.Name),
+			Target: fmt.Sprintf("%s/%s", containerGroupsPath, group.Name),
+		})
+		groupIDs = append(groupIDs, fmt.Sprintf("%s:%d", group.Name, GroupGID(group.ID)))
+	}
+	return mounts, groupIDs, nil
+}
+
 func (s *ContainerService) StartContainer(containerID string) error {
 	err := s.dockerClient.ContainerStart(context.Background(), containerID, types.ContainerStartOptions{})
 	if err != nil {
@@ -287,15 +435,19 @@ func (s *ContainerService) RemoveContainer(containerID string) error {
 func (s *ContainerService) GetContainerByID(containerID string) (*models.Container, error) {
 	container := &models.Container{}
 	query := `
-		SELECT id, user_id, name, status, image_name, cpu_limit, memory_limit, 
-		       COALESCE(gpu_devices, ''), created_at, updated_at, last_seen
+		SELECT id, user_id, name, status, image_name, cpu_limit, memory_limit,
+		       COALESCE(swap_limit, 'unlimited'), COALESCE(pids_limit, 0), COALESCE(shm_size, '64m'),
+		       COALESCE(gpu_devices, ''),
+		       COALESCE((SELECT MIN(mode) FROM gpu_assignments a WHERE a.container_id = containers.id), ''),
+		       created_at, updated_at, last_seen
 		FROM containers WHERE id = ?
 	`
 	
 	err := s.db.QueryRow(query, containerID).Scan(
 		&container.ID, &container.UserID, &container.Name, &container.Status,
 		&container.ImageName, &container.CPULimit, &container.MemoryLimit,
-		&container.GPUDevices, &container.CreatedAt, &container.UpdatedAt,
+		&container.SwapLimit, &container.PidsLimit, &container.ShmSize,
+		&container.GPUDevices, &container.GPUMode, &container.CreatedAt, &container.UpdatedAt,
 		&container.LastSeen,
 	)
 	
@@ -323,8 +475,11 @@ func (s *ContainerService) GetContainerActualStatus(containerID string) (string,
 
 func (s *ContainerService) ListContainers() ([]interface{}, error) {
 	query := `
-		SELECT id, user_id, name, status, image_name, cpu_limit, memory_limit, 
-		       COALESCE(gpu_devices, ''), created_at, updated_at, last_seen
+		SELECT id, user_id, name, status, image_name, cpu_limit, memory_limit,
+		       COALESCE(swap_limit, 'unlimited'), COALESCE(pids_limit, 0), COALESCE(shm_size, '64m'),
+		       COALESCE(gpu_devices, ''),
+		       COALESCE((SELECT MIN(mode) FROM gpu_assignments a WHERE a.container_id = containers.id), ''),
+		       created_at, updated_at, last_seen
 		FROM containers ORDER BY created_at DESC
 	`
 	
@@ -340,7 +495,8 @@ func (s *ContainerService) ListContainers() ([]interface{}, error) {
 		err := rows.Scan(
 			&container.ID, &container.UserID, &container.Name, &container.Status,
 			&container.ImageName, &container.CPULimit, &container.MemoryLimit,
-			&container.GPUDevices, &container.CreatedAt, &container.UpdatedAt,
+			&container.SwapLimit, &container.PidsLimit, &container.ShmSize,
+			&container.GPUDevices, &container.GPUMode, &container.CreatedAt, &container.UpdatedAt,
 			&container.LastSeen,
 		)
 		if err != nil {
@@ -366,7 +522,11 @@ func (s *ContainerService) ListContainers() ([]interface{}, error) {
 			"image_name": container.ImageName,
 			"cpu_limit": container.CPULimit,
 			"memory_limit": container.MemoryLimit,
+			"swap_limit": container.SwapLimit,
+			"pids_limit": container.PidsLimit,
+			"shm_size": container.ShmSize,
 			"gpu_devices": container.GPUDevices,
+			"gpu_mode": container.GPUMode,
 			"created_at": container.CreatedAt,
 			"updated_at": container.UpdatedAt,
 			"last_seen": container.LastSeen,
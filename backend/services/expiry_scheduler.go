@@ -0,0 +1,240 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"gpu-dev-platform/database"
+	"gpu-dev-platform/models"
+)
+
+// ExpiryScheduler 定期处理账户到期：提前提醒、到期禁用并停止容器、宽限期后归档家目录并删除容器
+type ExpiryScheduler struct {
+	db               *sql.DB
+	userService      *UserService
+	containerService *ContainerService
+	sessionService   *SessionService
+	audit            *AuditService
+	notifier         Notifier
+
+	interval    time.Duration
+	warnBefore  time.Duration
+	gracePeriod time.Duration
+}
+
+func NewExpiryScheduler() (*ExpiryScheduler, error) {
+	containerService, err := NewContainerService()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExpiryScheduler{
+		db:               database.DB,
+		userService:      NewUserService(),
+		containerService: containerService,
+		sessionService:   NewSessionService(),
+		audit:            NewAuditService(),
+		notifier:         NewNotifier(),
+		interval:         parseDurationEnv("ACCOUNT_EXPIRY_CHECK_INTERVAL", time.Hour),
+		warnBefore:       time.Duration(envInt("ACCOUNT_EXPIRY_WARN_DAYS", 7)) * 24 * time.Hour,
+		gracePeriod:      time.Duration(envInt("ACCOUNT_EXPIRY_GRACE_DAYS", 14)) * 24 * time.Hour,
+	}, nil
+}
+
+// Run 启动后立即检查一次，之后按间隔循环，应在独立 goroutine 中调用
+func (s *ExpiryScheduler) Run() {
+	log.Printf("Account expiry scheduler started (interval %s, warn %s ahead, grace %s)",
+		s.interval, s.warnBefore, s.gracePeriod)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.RunOnce()
+		<-ticker.C
+	}
+}
+
+// RunOnce 执行一轮检查，单个账户失败只记录日志，下一轮会重试
+func (s *ExpiryScheduler) RunOnce() {
+	now := time.Now()
+
+	if err := s.warnExpiring(now); err != nil {
+		log.Printf("expiry scheduler: warning step failed: %v", err)
+	}
+	if err := s.disableExpired(now); err != nil {
+		log.Printf("expiry scheduler: disable step failed: %v", err)
+	}
+	if err := s.deprovisionExpired(now); err != nil {
+		log.Printf("expiry scheduler: deprovision step failed: %v", err)
+	}
+}
+
+// warnExpiring 对即将到期且尚未提醒过的账户发送提醒
+func (s *ExpiryScheduler) warnExpiring(now time.Time) error {
+	ids, err := s.userIDs(`
+		SELECT id FROM users
+		WHERE is_active = TRUE AND deleted_at IS NULL AND expiry_warned_at IS NULL AND expires_at > ? AND expires_at <= ?
+	`, now, now.Add(s.warnBefore))
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		user, err := s.userService.GetUserByID(id)
+		if err != nil || user.ExpiresAt == nil {
+			continue
+		}
+
+		days := int(user.ExpiresAt.Sub(now).Hours()/24) + 1
+		err = s.notifier.Notify(&Notification{
+			Event:    NotifyAccountExpiring,
+			UserID:   user.ID,
+			Username: user.Username,
+			Email:    user.Email,
+			Message:  fmt.Sprintf("账户 %s 将于 %s 到期（约%d天后），到期后将无法登录，容器会被停止，请及时备份数据", user.Username, user.ExpiresAt.Format("2006-01-02 15:04"), days),
+			Data:     map[string]interface{}{"expires_at": user.ExpiresAt},
+			Time:     now,
+		})
+		if err != nil {
+			// 不标记已提醒，下一轮重试
+			log.Printf("expiry scheduler: failed to notify %s: %v", user.Username, err)
+			continue
+		}
+		s.db.Exec("UPDATE users SET expiry_warned_at = ? WHERE id = ?", now, id)
+	}
+	return nil
+}
+
+// disableExpired 禁用已到期的账户，吊销会话并停止容器
+func (s *ExpiryScheduler) disableExpired(now time.Time) error {
+	ids, err := s.userIDs("SELECT id FROM users WHERE is_active = TRUE AND deleted_at IS NULL AND expires_at <= ?", now)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		user, err := s.userService.GetUserByID(id)
+		if err != nil {
+			continue
+		}
+
+		err = s.userService.UpdateUser(id, map[string]interface{}{"is_active": false})
+		if err == nil {
+			s.sessionService.RevokeUserSessions(id)
+			if user.ContainerID != "" {
+				if stopErr := s.containerService.StopContainer(user.ContainerID); stopErr != nil {
+					// 容器可能已经停止或被手动删除，账户仍然视为已禁用
+					log.Printf("expiry scheduler: failed to stop container of %s: %v", user.Username, stopErr)
+				}
+			}
+		}
+
+		event := s.newEvent(models.AuditUserExpire, id)
+		event.SetChange("is_active", true, false)
+		s.audit.Record(event, err)
+		if err != nil {
+			log.Printf("expiry scheduler: failed to disable %s: %v", user.Username, err)
+			continue
+		}
+
+		s.notify(&Notification{
+			Event:    NotifyAccountExpired,
+			UserID:   user.ID,
+			Username: user.Username,
+			Email:    user.Email,
+			Message:  fmt.Sprintf("账户 %s 已到期并被禁用，数据将在%d天后归档", user.Username, int(s.gracePeriod.Hours()/24)),
+			Time:     now,
+		})
+	}
+	return nil
+}
+
+// deprovisionExpired 宽限期结束后归档家目录并删除容器，账户记录保留
+func (s *ExpiryScheduler) deprovisionExpired(now time.Time) error {
+	ids, err := s.userIDs(`
+		SELECT id FROM users
+		WHERE is_active = FALSE AND deleted_at IS NULL AND deprovisioned_at IS NULL AND expires_at <= ?
+	`, now.Add(-s.gracePeriod))
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		user, err := s.userService.GetUserByID(id)
+		if err != nil {
+			continue
+		}
+
+		event := s.newEvent(models.AuditUserDeprovision, id)
+		// archiveUserData 已校验路径，不安全的用户名在这里失败，不会删除任何目录
+		archive, err := archiveUserData(user.Username)
+		if err == nil && archive != "" {
+			event.SetChange("archive", nil, archive)
+			var dir string
+			if dir, err = userDataDir(user.Username); err == nil {
+				err = os.RemoveAll(dir)
+			}
+		}
+		if err == nil && user.ContainerID != "" {
+			event.SetChange("container_id", user.ContainerID, nil)
+			err = s.containerService.RemoveContainer(user.ContainerID)
+		}
+		if err == nil {
+			_, err = s.db.Exec("UPDATE users SET deprovisioned_at = ? WHERE id = ?", now, id)
+		}
+		s.audit.Record(event, err)
+		if err != nil {
+			log.Printf("expiry scheduler: failed to deprovision %s: %v", user.Username, err)
+			continue
+		}
+
+		s.notify(&Notification{
+			Event:    NotifyAccountDeprovisioned,
+			UserID:   user.ID,
+			Username: user.Username,
+			Email:    user.Email,
+			Message:  fmt.Sprintf("账户 %s 的容器已删除，家目录已归档", user.Username),
+			Data:     map[string]interface{}{"archive": archive},
+			Time:     now,
+		})
+	}
+	return nil
+}
+
+func (s *ExpiryScheduler) userIDs(query string, args ...interface{}) ([]int, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// newEvent 调度器执行的操作以 system 身份写审计日志
+func (s *ExpiryScheduler) newEvent(action string, userID int) *models.AuditEvent {
+	return &models.AuditEvent{
+		ActorName:  "system",
+		Action:     action,
+		TargetType: "user",
+		TargetID:   strconv.Itoa(userID),
+	}
+}
+
+func (s *ExpiryScheduler) notify(n *Notification) {
+	if err := s.notifier.Notify(n); err != nil {
+		log.Printf("expiry scheduler: failed to send %s notification for %s: %v", n.Event, n.Username, err)
+	}
+}
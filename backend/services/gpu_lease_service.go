@@ -0,0 +1,512 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gpu-dev-platform/database"
+	"gpu-dev-platform/models"
+)
+
+var (
+	ErrInvalidLease  = errors.New("invalid GPU lease")
+	ErrLeaseNotFound = errors.New("GPU lease not found")
+	ErrLeaseFinished = errors.New("租约已结束或已取消")
+)
+
+// LeaseConflictError 请求的时段内 GPU 已被其他租约以不兼容的方式预约，或用户在该时段已有租约
+type LeaseConflictError struct {
+	Leases []*models.GPULease
+}
+
+func (e *LeaseConflictError) Error() string {
+	parts := make([]string, len(e.Leases))
+	for i, l := range e.Leases {
+		parts[i] = fmt.Sprintf("GPU %s 在 %s 至 %s 已被预约（%s）", joinIndexes(l.GPUIndexes),
+			l.StartsAt.Format("2006-01-02 15:04"), l.EndsAt.Format("2006-01-02 15:04"), gpuModeName(l.Mode))
+	}
+	return "租约时间冲突: " + strings.Join(parts, "；")
+}
+
+// LeaseRequest 预约 GPU 的请求。GPU 可以用 Devices 指定，也可以按 Count、Model、MinMemoryMB 由调度器挑选
+type LeaseRequest struct {
+	UserID   int
+	GPU      GPURequest
+	StartsAt time.Time // 早于当前时间时从现在开始
+	EndsAt   time.Time
+	Note     string
+	// 用户自助预约时限制时长和提前量，管理员代为预约不受限制
+	SelfService bool
+	ActorID     int
+	ActorName   string
+}
+
+// LeaseFilter 查询条件，From/To 为零值时不限制时间
+type LeaseFilter struct {
+	UserID          int
+	From            time.Time
+	To              time.Time
+	IncludeFinished bool
+}
+
+// GPULeaseService 管理 GPU 租约的预约、取消和查询，挂载和卸载由 GPULeaseWorker 执行
+type GPULeaseService struct {
+	db   *sql.DB
+	gpus *GPUService
+
+	maxDuration time.Duration
+	maxAdvance  time.Duration
+	selfService bool
+}
+
+func NewGPULeaseService() *GPULeaseService {
+	return &GPULeaseService{
+		db:          database.DB,
+		gpus:        DefaultGPUService(),
+		maxDuration: parseDurationEnv("GPU_LEASE_MAX_DURATION", 72*time.Hour),
+		maxAdvance:  parseDurationEnv("GPU_LEASE_MAX_ADVANCE", 30*24*time.Hour),
+		selfService: envBool("GPU_LEASE_SELF_SERVICE", true),
+	}
+}
+
+// SelfServiceEnabled 普通用户是否可以自己预约
+func (s *GPULeaseService) SelfServiceEnabled() bool {
+	return s.selfService
+}
+
+// Create 校验时段并在事务中检查与其他租约的重叠后记录租约。
+// 租约之间按 GPU 分配方式判断冲突：独占与同一 GPU 上的任何租约冲突，共享租约可以重叠；
+// 同一用户的租约不能重叠。GPU 当前被其他容器长期占用时仍可预约，但在 Warnings 中提示
+func (s *GPULeaseService) Create(req *LeaseRequest) (*models.GPULease, error) {
+	now := time.Now()
+	startsAt, endsAt := req.StartsAt, req.EndsAt
+	if startsAt.Before(now) {
+		startsAt = now
+	}
+	if !endsAt.After(startsAt) {
+		return nil, fmt.Errorf("%w: 结束时间必须晚于开始时间和当前时间", ErrInvalidLease)
+	}
+	if req.SelfService {
+		if endsAt.Sub(startsAt) > s.maxDuration {
+			return nil, fmt.Errorf("%w: 租约时长不能超过 %s", ErrInvalidLease, s.maxDuration)
+		}
+		if startsAt.Sub(now) > s.maxAdvance {
+			return nil, fmt.Errorf("%w: 最多只能提前 %s 预约", ErrInvalidLease, s.maxAdvance)
+		}
+	}
+	if !s.gpus.Enabled() {
+		return nil, fmt.Errorf("%w: 预约 GPU 需要配置 GPU 盘点 (GPU_INVENTORY_PROVIDER)", ErrInvalidLease)
+	}
+	mode, err := s.gpus.Mode(req.GPU.Mode)
+	if err != nil {
+		return nil, err
+	}
+
+	s.gpus.refreshIfStale()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// 锁定全部 GPU 记录，并发的预约在此串行化
+	present, err := lockPresentGPUs(tx)
+	if err != nil {
+		return nil, err
+	}
+	if len(present) == 0 {
+		return nil, fmt.Errorf("%w: GPU 清单为空，请检查 GPU 盘点", ErrInsufficientGPUs)
+	}
+
+	overlapping, err := s.overlapping(tx, startsAt, endsAt)
+	if err != nil {
+		return nil, err
+	}
+	var own []*models.GPULease
+	for _, l := range overlapping {
+		if l.UserID == req.UserID {
+			own = append(own, l)
+		}
+	}
+	if len(own) > 0 {
+		return nil, &LeaseConflictError{Leases: own}
+	}
+
+	held, err := s.heldAssignments(req.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	var gpus []*models.GPU
+	if req.GPU.Scheduled() {
+		gpus, err = s.schedule(req.GPU, mode, present, overlapping, held)
+	} else {
+		// 清单已在事务前刷新，这里不能再触发盘点，盘点会更新已被锁定的 GPU 记录
+		gpus, err = s.gpus.resolve(req.GPU.Devices)
+		if err == nil && len(gpus) == 0 {
+			err = fmt.Errorf("%w: 请指定要预约的 GPU", ErrInvalidLease)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if conflicts := leaseConflicts(gpus, mode, overlapping); len(conflicts) > 0 {
+		return nil, &LeaseConflictError{Leases: conflicts}
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO gpu_leases (user_id, mode, starts_at, ends_at, status, note, created_by, created_by_name, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, req.UserID, mode, startsAt, endsAt, models.GPULeaseScheduled, req.Note, req.ActorID, req.ActorName, now)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	for _, gpu := range gpus {
+		if _, err := tx.Exec("INSERT INTO gpu_lease_gpus (lease_id, gpu_uuid) VALUES (?, ?)", id, gpu.UUID); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	lease, err := s.Get(int(id))
+	if err != nil {
+		return nil, err
+	}
+	conflicts := heldConflicts(gpus, mode, held)
+	if req.SelfService {
+		// 自助预约的用户不能看到其他用户和容器的名称
+		lease.Warnings = anonymousHeldWarnings(conflicts)
+	} else {
+		lease.Warnings = GPUConflictWarnings(conflicts)
+	}
+	return lease, nil
+}
+
+// anonymousHeldWarnings 只列出被占用的 GPU 序号，不包含占用者
+func anonymousHeldWarnings(conflicts []*models.GPUAssignment) []string {
+	if len(conflicts) == 0 {
+		return nil
+	}
+	var indexes []int
+	seen := make(map[int]bool)
+	for _, c := range conflicts {
+		if !seen[c.GPUIndex] {
+			seen[c.GPUIndex] = true
+			indexes = append(indexes, c.GPUIndex)
+		}
+	}
+	return []string{fmt.Sprintf("GPU %s 当前被其他容器占用，占用在租约开始前释放后才能挂载", joinIndexes(indexes))}
+}
+
+// Cancel 取消租约。未开始的租约直接取消；进行中的租约把结束时间改为现在，
+// 由 GPULeaseWorker 提醒用户并在 GPU_LEASE_NOTICE 之后卸载 GPU
+func (s *GPULeaseService) Cancel(id int) (*models.GPULease, error) {
+	lease, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch lease.Status {
+	case models.GPULeaseScheduled:
+		_, err = s.db.Exec("UPDATE gpu_leases SET status = ? WHERE id = ? AND status = ?",
+			models.GPULeaseCancelled, id, models.GPULeaseScheduled)
+	case models.GPULeaseActive:
+		_, err = s.db.Exec("UPDATE gpu_leases SET ends_at = ? WHERE id = ? AND ends_at > ?", time.Now(), id, time.Now())
+	default:
+		return nil, ErrLeaseFinished
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(id)
+}
+
+func (s *GPULeaseService) Get(id int) (*models.GPULease, error) {
+	leases, err := s.query(s.db, "WHERE l.id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(leases) == 0 {
+		return nil, ErrLeaseNotFound
+	}
+	return leases[0], nil
+}
+
+// List 按开始时间排序的租约，默认只包括未开始和进行中的
+func (s *GPULeaseService) List(filter LeaseFilter) ([]*models.GPULease, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.UserID != 0 {
+		conditions = append(conditions, "l.user_id = ?")
+		args = append(args, filter.UserID)
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "l.ends_at > ?")
+		args = append(args, filter.From)
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "l.starts_at < ?")
+		args = append(args, filter.To)
+	}
+	if !filter.IncludeFinished {
+		conditions = append(conditions, "l.status IN (?, ?)")
+		args = append(args, models.GPULeaseScheduled, models.GPULeaseActive)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	leases, err := s.query(s.db, where, args...)
+	if err != nil {
+		return nil, err
+	}
+	if leases == nil {
+		leases = []*models.GPULease{}
+	}
+	return leases, nil
+}
+
+// overlapping 与时段重叠、仍然有效的租约
+func (s *GPULeaseService) overlapping(q queryer, startsAt, endsAt time.Time) ([]*models.GPULease, error) {
+	return s.query(q, "WHERE l.status IN (?, ?) AND l.starts_at < ? AND l.ends_at > ?",
+		models.GPULeaseScheduled, models.GPULeaseActive, endsAt, startsAt)
+}
+
+// query 查询租约及其 GPU，where 为附加的条件子句
+func (s *GPULeaseService) query(q queryer, where string, args ...interface{}) ([]*models.GPULease, error) {
+	rows, err := q.Query(`
+		SELECT l.id, l.user_id, COALESCE(u.username, ''), l.mode, l.starts_at, l.ends_at, l.status,
+		       COALESCE(l.note, ''), COALESCE(l.last_error, ''), l.attached_at, l.detached_at,
+		       COALESCE(l.created_by, 0), COALESCE(l.created_by_name, ''), l.created_at,
+		       COALESCE(l.previous_gpu_devices, ''), COALESCE(l.previous_gpu_mode, ''), COALESCE(l.previous_container_id, ''),
+		       l.start_warned_at, l.end_warned_at
+		FROM gpu_leases l
+		LEFT JOIN users u ON u.id = l.user_id
+		`+where+`
+		ORDER BY l.starts_at, l.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var leases []*models.GPULease
+	byID := make(map[int]*models.GPULease)
+	for rows.Next() {
+		l := &models.GPULease{GPUUUIDs: []string{}, GPUIndexes: []int{}}
+		var attachedAt, detachedAt, startWarnedAt, endWarnedAt sql.NullTime
+		if err := rows.Scan(&l.ID, &l.UserID, &l.Username, &l.Mode, &l.StartsAt, &l.EndsAt, &l.Status,
+			&l.Note, &l.LastError, &attachedAt, &detachedAt,
+			&l.CreatedBy, &l.CreatedByName, &l.CreatedAt,
+			&l.PreviousGPUDevices, &l.PreviousGPUMode, &l.PreviousContainerID,
+			&startWarnedAt, &endWarnedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if attachedAt.Valid {
+			l.AttachedAt = &attachedAt.Time
+		}
+		if detachedAt.Valid {
+			l.DetachedAt = &detachedAt.Time
+		}
+		if startWarnedAt.Valid {
+			l.StartWarnedAt = &startWarnedAt.Time
+		}
+		if endWarnedAt.Valid {
+			l.EndWarnedAt = &endWarnedAt.Time
+		}
+		leases = append(leases, l)
+		byID[l.ID] = l
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(leases) == 0 {
+		return leases, nil
+	}
+
+	ids := make([]interface{}, 0, len(leases))
+	for _, l := range leases {
+		ids = append(ids, l.ID)
+	}
+	gpuRows, err := q.Query(`
+		SELECT lg.lease_id, lg.gpu_uuid, g.gpu_index
+		FROM gpu_lease_gpus lg JOIN gpus g ON g.uuid = lg.gpu_uuid
+		WHERE lg.lease_id IN (`+placeholders(len(ids))+`)
+		ORDER BY g.gpu_index`, ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer gpuRows.Close()
+	for gpuRows.Next() {
+		var leaseID, index int
+		var uuid string
+		if err := gpuRows.Scan(&leaseID, &uuid, &index); err != nil {
+			return nil, err
+		}
+		if l := byID[leaseID]; l != nil {
+			l.GPUUUIDs = append(l.GPUUUIDs, uuid)
+			l.GPUIndexes = append(l.GPUIndexes, index)
+		}
+	}
+	return leases, gpuRows.Err()
+}
+
+// heldAssignments 容器对 GPU 的长期占用：不包括预约用户自己的容器，也不包括通过进行中的租约挂载的 GPU，
+// 这两类占用会在租约开始或上一个租约结束时随容器重建释放
+func (s *GPULeaseService) heldAssignments(userID int) ([]*models.GPUAssignment, error) {
+	assignments, err := s.gpus.assignments("", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	leased := map[int]bool{userID: true}
+	rows, err := s.db.Query("SELECT DISTINCT user_id FROM gpu_leases WHERE status = ?", models.GPULeaseActive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		leased[id] = true
+	}
+
+	var held []*models.GPUAssignment
+	for _, a := range assignments {
+		if !leased[a.UserID] {
+			held = append(held, a)
+		}
+	}
+	return held, rows.Err()
+}
+
+// schedule 按数量、型号和显存挑选在时段内可以预约的 GPU，优先选择没有被容器长期占用的
+func (s *GPULeaseService) schedule(req GPURequest, mode string, present []*models.GPU, overlapping []*models.GPULease, held []*models.GPUAssignment) ([]*models.GPU, error) {
+	if strings.TrimSpace(req.Devices) != "" {
+		return nil, fmt.Errorf("%w: gpu_devices 与 gpu_count 不能同时指定", ErrInvalidGPURequest)
+	}
+	count := req.Count
+	if count == 0 {
+		count = 1
+	}
+	if count < 0 || count > maxGPUCount {
+		return nil, fmt.Errorf("%w: GPU 数量无效: %d", ErrInvalidGPURequest, req.Count)
+	}
+
+	load := make(map[string]int)
+	for _, l := range overlapping {
+		for _, uuid := range l.GPUUUIDs {
+			load[uuid]++
+		}
+	}
+
+	var free, busy []gpuCandidate
+	matched := 0
+	for _, gpu := range present {
+		if !gpuMatches(gpu, req) {
+			continue
+		}
+		matched++
+		if len(leaseConflicts([]*models.GPU{gpu}, mode, overlapping)) > 0 {
+			continue
+		}
+		candidate := gpuCandidate{gpu: gpu, load: load[gpu.UUID]}
+		if len(heldConflicts([]*models.GPU{gpu}, mode, held)) > 0 {
+			busy = append(busy, candidate)
+		} else {
+			free = append(free, candidate)
+		}
+	}
+	if len(free)+len(busy) < count {
+		return nil, fmt.Errorf("%w: 请求 %d 块%s，符合要求的 %d 块中该时段只有 %d 块可以按%s方式预约",
+			ErrInsufficientGPUs, count, describeGPURequirement(req), matched, len(free)+len(busy), gpuModeName(mode))
+	}
+
+	s.gpus.sortCandidates(free)
+	s.gpus.sortCandidates(busy)
+	candidates := append(free, busy...)
+	chosen := make([]*models.GPU, count)
+	for i := range chosen {
+		chosen[i] = candidates[i].gpu
+	}
+	sort.Slice(chosen, func(i, j int) bool { return chosen[i].Index < chosen[j].Index })
+	return chosen, nil
+}
+
+// lockPresentGPUs 以 FOR UPDATE 读取在位的 GPU
+func lockPresentGPUs(tx *sql.Tx) ([]*models.GPU, error) {
+	rows, err := tx.Query("SELECT uuid, gpu_index, COALESCE(model, ''), memory_mb, present, last_seen FROM gpus WHERE present = TRUE ORDER BY gpu_index FOR UPDATE")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var gpus []*models.GPU
+	for rows.Next() {
+		gpu := &models.GPU{}
+		if err := rows.Scan(&gpu.UUID, &gpu.Index, &gpu.Model, &gpu.MemoryMB, &gpu.Present, &gpu.LastSeen); err != nil {
+			return nil, err
+		}
+		gpus = append(gpus, gpu)
+	}
+	return gpus, rows.Err()
+}
+
+// leaseConflicts 与请求的 GPU 和分配方式不兼容的租约
+func leaseConflicts(gpus []*models.GPU, mode string, leases []*models.GPULease) []*models.GPULease {
+	requested := make(map[string]bool, len(gpus))
+	for _, gpu := range gpus {
+		requested[gpu.UUID] = true
+	}
+
+	var conflicts []*models.GPULease
+	for _, l := range leases {
+		if mode != models.GPUModeExclusive && l.Mode != models.GPUModeExclusive {
+			continue
+		}
+		for _, uuid := range l.GPUUUIDs {
+			if requested[uuid] {
+				conflicts = append(conflicts, l)
+				break
+			}
+		}
+	}
+	return conflicts
+}
+
+// heldConflicts 与请求不兼容的长期占用
+func heldConflicts(gpus []*models.GPU, mode string, held []*models.GPUAssignment) []*models.GPUAssignment {
+	requested := make(map[string]bool, len(gpus))
+	for _, gpu := range gpus {
+		requested[gpu.UUID] = true
+	}
+
+	var conflicts []*models.GPUAssignment
+	for _, a := range held {
+		if requested[a.GPUUUID] && (mode == models.GPUModeExclusive || a.Mode == models.GPUModeExclusive) {
+			conflicts = append(conflicts, a)
+		}
+	}
+	return conflicts
+}
+
+func joinIndexes(indexes []int) string {
+	parts := make([]string, len(indexes))
+	for i, index := range indexes {
+		parts[i] = fmt.Sprintf("%d", index)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"testing"
+
+	"gpu-dev-platform/models"
+)
+
+var (
+	testGPU0 = &models.GPU{Index: 0, UUID: "GPU-0"}
+	testGPU1 = &models.GPU{Index: 1, UUID: "GPU-1"}
+)
+
+func TestLeaseConflicts(t *testing.T) {
+	exclusive0 := &models.GPULease{ID: 1, Mode: models.GPUModeExclusive, GPUUUIDs: []string{"GPU-0"}}
+	shared0 := &models.GPULease{ID: 2, Mode: models.GPUModeShared, GPUUUIDs: []string{"GPU-0"}}
+	shared01 := &models.GPULease{ID: 3, Mode: models.GPUModeShared, GPUUUIDs: []string{"GPU-0", "GPU-1"}}
+	exclusive1 := &models.GPULease{ID: 4, Mode: models.GPUModeExclusive, GPUUUIDs: []string{"GPU-1"}}
+
+	tests := []struct {
+		name   string
+		gpus   []*models.GPU
+		mode   string
+		leases []*models.GPULease
+		want   []int
+	}{
+		{name: "exclusive request vs exclusive lease", gpus: []*models.GPU{testGPU0}, mode: models.GPUModeExclusive,
+			leases: []*models.GPULease{exclusive0}, want: []int{1}},
+		{name: "exclusive request vs shared lease", gpus: []*models.GPU{testGPU0}, mode: models.GPUModeExclusive,
+			leases: []*models.GPULease{shared0}, want: []int{2}},
+		{name: "shared request vs exclusive lease", gpus: []*models.GPU{testGPU0}, mode: models.GPUModeShared,
+			leases: []*models.GPULease{exclusive0}, want: []int{1}},
+		{name: "shared request vs shared lease", gpus: []*models.GPU{testGPU0}, mode: models.GPUModeShared,
+			leases: []*models.GPULease{shared0, shared01}, want: nil},
+		{name: "different GPU", gpus: []*models.GPU{testGPU0}, mode: models.GPUModeExclusive,
+			leases: []*models.GPULease{exclusive1}, want: nil},
+		{name: "multi-GPU lease reported once", gpus: []*models.GPU{testGPU0, testGPU1}, mode: models.GPUModeExclusive,
+			leases: []*models.GPULease{shared01}, want: []int{3}},
+		{name: "only incompatible leases", gpus: []*models.GPU{testGPU0, testGPU1}, mode: models.GPUModeShared,
+			leases: []*models.GPULease{exclusive0, shared0, shared01, exclusive1}, want: []int{1, 4}},
+		{name: "no leases", gpus: []*models.GPU{testGPU0}, mode: models.GPUModeExclusive, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			for _, l := range leaseConflicts(tt.gpus, tt.mode, tt.leases) {
+				got = append(got, l.ID)
+			}
+			if !equalInts(got, tt.want) {
+				t.Errorf("conflicting leases = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeldConflicts(t *testing.T) {
+	held := func(id int, uuid, mode string) *models.GPUAssignment {
+		return &models.GPUAssignment{UserID: id, GPUUUID: uuid, Mode: mode}
+	}
+
+	tests := []struct {
+		name string
+		gpus []*models.GPU
+		mode string
+		held []*models.GPUAssignment
+		want []int
+	}{
+		{name: "exclusive request vs exclusive holder", gpus: []*models.GPU{testGPU0}, mode: models.GPUModeExclusive,
+			held: []*models.GPUAssignment{held(1, "GPU-0", models.GPUModeExclusive)}, want: []int{1}},
+		{name: "exclusive request vs shared holder", gpus: []*models.GPU{testGPU0}, mode: models.GPUModeExclusive,
+			held: []*models.GPUAssignment{held(1, "GPU-0", models.GPUModeShared)}, want: []int{1}},
+		{name: "shared request vs exclusive holder", gpus: []*models.GPU{testGPU0}, mode: models.GPUModeShared,
+			held: []*models.GPUAssignment{held(1, "GPU-0", models.GPUModeExclusive)}, want: []int{1}},
+		{name: "shared request vs shared holders", gpus: []*models.GPU{testGPU0}, mode: models.GPUModeShared,
+			held: []*models.GPUAssignment{held(1, "GPU-0", models.GPUModeShared), held(2, "GPU-0", models.GPUModeShared)}, want: nil},
+		{name: "different GPU", gpus: []*models.GPU{testGPU0}, mode: models.GPUModeExclusive,
+			held: []*models.GPUAssignment{held(1, "GPU-1", models.GPUModeExclusive)}, want: nil},
+		{name: "each incompatible holder reported", gpus: []*models.GPU{testGPU0, testGPU1}, mode: models.GPUModeShared,
+			held: []*models.GPUAssignment{
+				held(1, "GPU-0", models.GPUModeShared),
+				held(2, "GPU-1", models.GPUModeExclusive),
+				held(3, "GPU-0", models.GPUModeExclusive),
+			}, want: []int{2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			for _, a := range heldConflicts(tt.gpus, tt.mode, tt.held) {
+				got = append(got, a.UserID)
+			}
+			if !equalInts(got, tt.want) {
+				t.Errorf("conflicting holders = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,402 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gpu-dev-platform/database"
+	"gpu-dev-platform/models"
+)
+
+// GPULeaseWorker 定期处理 GPU 租约：开始和结束前提醒用户，开始时重建容器挂载租约的 GPU，
+// 结束时重建容器恢复租约前的 GPU 配置
+type GPULeaseWorker struct {
+	db               *sql.DB
+	leases           *GPULeaseService
+	gpus             *GPUService
+	userService      *UserService
+	containerService *ContainerService
+	audit            *AuditService
+	notifier         Notifier
+
+	interval time.Duration
+	notice   time.Duration
+}
+
+func NewGPULeaseWorker() (*GPULeaseWorker, error) {
+	containerService, err := NewContainerService()
+	if err != nil {
+		return nil, err
+	}
+
+	return &GPULeaseWorker{
+		db:               database.DB,
+		leases:           NewGPULeaseService(),
+		gpus:             DefaultGPUService(),
+		userService:      NewUserService(),
+		containerService: containerService,
+		audit:            NewAuditService(),
+		notifier:         NewNotifier(),
+		interval:         parseDurationEnv("GPU_LEASE_CHECK_INTERVAL", time.Minute),
+		notice:           parseDurationEnv("GPU_LEASE_NOTICE", 15*time.Minute),
+	}, nil
+}
+
+// Run 启动后立即检查一次，之后按间隔循环，应在独立 goroutine 中调用
+func (w *GPULeaseWorker) Run() {
+	log.Printf("GPU lease worker started (interval %s, notice %s ahead)", w.interval, w.notice)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		w.RunOnce()
+		<-ticker.C
+	}
+}
+
+// RunOnce 执行一轮检查。先结束到期的租约再开始新的租约，前后衔接的租约可以交接同一块 GPU；
+// 挂载或卸载失败的租约保持原状态，下一轮重试
+func (w *GPULeaseWorker) RunOnce() {
+	now := time.Now()
+
+	if err := w.warnStarting(now); err != nil {
+		log.Printf("gpu lease worker: start notice step failed: %v", err)
+	}
+	if err := w.warnEnding(now); err != nil {
+		log.Printf("gpu lease worker: end notice step failed: %v", err)
+	}
+	if err := w.endLeases(now); err != nil {
+		log.Printf("gpu lease worker: end step failed: %v", err)
+	}
+	if err := w.expireUnstarted(now); err != nil {
+		log.Printf("gpu lease worker: expire step failed: %v", err)
+	}
+	if err := w.startLeases(now); err != nil {
+		log.Printf("gpu lease worker: start step failed: %v", err)
+	}
+}
+
+// warnStarting 提醒即将开始的租约，开始时容器会重建。
+// 包括已到开始时间但还没有提醒过的租约（如立即开始的租约），这些租约推迟到提醒满 GPU_LEASE_NOTICE 后再挂载
+func (w *GPULeaseWorker) warnStarting(now time.Time) error {
+	leases, err := w.leases.query(w.db, "WHERE l.status = ? AND l.start_warned_at IS NULL AND l.starts_at <= ? AND l.ends_at > ?",
+		models.GPULeaseScheduled, now.Add(w.notice), now)
+	if err != nil {
+		return err
+	}
+
+	for _, lease := range leases {
+		err := w.notifyUser(lease, NotifyGPULeaseStarting, fmt.Sprintf(
+			"GPU 租约 #%d（GPU %s）将于 %s 开始，届时容器会重建以挂载 GPU，家目录以外的改动和运行中的进程会丢失，请提前保存",
+			lease.ID, joinIndexes(lease.GPUIndexes), w.noticeDeadline(lease.StartsAt, now).Format("2006-01-02 15:04")))
+		if err != nil {
+			// 不标记已提醒，下一轮重试
+			continue
+		}
+		w.db.Exec("UPDATE gpu_leases SET start_warned_at = ? WHERE id = ?", now, lease.ID)
+	}
+	return nil
+}
+
+// warnEnding 提醒即将结束的租约，结束时 GPU 会被卸载。包括被取消而立即到期、还没有提醒过的租约
+func (w *GPULeaseWorker) warnEnding(now time.Time) error {
+	leases, err := w.leases.query(w.db, "WHERE l.status = ? AND l.end_warned_at IS NULL AND l.ends_at <= ?",
+		models.GPULeaseActive, now.Add(w.notice))
+	if err != nil {
+		return err
+	}
+
+	for _, lease := range leases {
+		err := w.notifyUser(lease, NotifyGPULeaseEnding, fmt.Sprintf(
+			"GPU 租约 #%d（GPU %s）将于 %s 结束，届时容器会重建并卸载这些 GPU，运行中的任务会被终止，请保存训练进度",
+			lease.ID, joinIndexes(lease.GPUIndexes), w.noticeDeadline(lease.EndsAt, now).Format("2006-01-02 15:04")))
+		if err != nil {
+			continue
+		}
+		w.db.Exec("UPDATE gpu_leases SET end_warned_at = ? WHERE id = ?", now, lease.ID)
+	}
+	return nil
+}
+
+// noticeDeadline 计划时间与现在起满提醒时长两者中较晚的一个，即容器最早可以重建的时间
+func (w *GPULeaseWorker) noticeDeadline(at, now time.Time) time.Time {
+	if earliest := now.Add(w.notice); at.Before(earliest) {
+		return earliest
+	}
+	return at
+}
+
+// noticeGiven 提醒是否已发出满 GPU_LEASE_NOTICE，未满时不能重建容器
+func (w *GPULeaseWorker) noticeGiven(warnedAt *time.Time, now time.Time) bool {
+	return warnedAt != nil && !now.Before(warnedAt.Add(w.notice))
+}
+
+func (w *GPULeaseWorker) endLeases(now time.Time) error {
+	leases, err := w.leases.query(w.db, "WHERE l.status = ? AND l.ends_at <= ?", models.GPULeaseActive, now)
+	if err != nil {
+		return err
+	}
+	for _, lease := range leases {
+		w.detach(lease, now)
+	}
+	return nil
+}
+
+// expireUnstarted 一直未能挂载的租约到期后结束，保留最后的错误
+func (w *GPULeaseWorker) expireUnstarted(now time.Time) error {
+	_, err := w.db.Exec("UPDATE gpu_leases SET status = ? WHERE status = ? AND ends_at <= ?",
+		models.GPULeaseEnded, models.GPULeaseScheduled, now)
+	return err
+}
+
+func (w *GPULeaseWorker) startLeases(now time.Time) error {
+	leases, err := w.leases.query(w.db, "WHERE l.status = ? AND l.starts_at <= ? AND l.ends_at > ?",
+		models.GPULeaseScheduled, now, now)
+	if err != nil {
+		return err
+	}
+	for _, lease := range leases {
+		w.attach(lease, now)
+	}
+	return nil
+}
+
+// attach 重建用户容器挂载租约的 GPU，并记录原来的 GPU 配置以便结束时恢复
+func (w *GPULeaseWorker) attach(lease *models.GPULease, now time.Time) {
+	user, err := w.userService.GetUserByID(lease.UserID)
+	if err != nil {
+		log.Printf("gpu lease worker: user of lease %d not found: %v", lease.ID, err)
+		return
+	}
+	// 停用、到期或已删除的账户不再挂载，直接结束租约释放 GPU
+	if !user.CanLogin() {
+		_, err := w.db.Exec("UPDATE gpu_leases SET status = ?, last_error = ? WHERE id = ?",
+			models.GPULeaseEnded, "账户已停用、到期或已删除，租约未挂载", lease.ID)
+		if err != nil {
+			log.Printf("gpu lease worker: failed to end lease %d: %v", lease.ID, err)
+		}
+		return
+	}
+
+	if user.ContainerID == "" {
+		if lease.PreviousContainerID != "" {
+			// 之前的挂载尝试已经开始重建，容器不见了不能当作本来就没有容器，保留记录的原配置
+			w.fail(lease, fmt.Sprintf("挂载过程中容器 %s 丢失，用户重新创建容器后将继续挂载", lease.PreviousContainerID))
+			return
+		}
+		// 没有容器时租约照常开始，GPU 为该用户保留，创建容器时可以指定这些 GPU
+		w.markActive(lease, now, "用户没有容器，GPU 已保留但未挂载")
+		w.notifyUser(lease, NotifyGPULeaseStarted, fmt.Sprintf(
+			"GPU 租约 #%d 已开始，GPU %s 为您保留至 %s，您还没有容器，创建容器时请指定这些 GPU",
+			lease.ID, joinIndexes(lease.GPUIndexes), lease.EndsAt.Format("2006-01-02 15:04")))
+		return
+	}
+	cont, err := w.containerService.GetContainerByID(user.ContainerID)
+	if err != nil {
+		w.fail(lease, "容器不存在: "+err.Error())
+		return
+	}
+
+	devices := strings.Join(lease.GPUUUIDs, ",")
+	gpus, err := w.gpus.Resolve(devices)
+	if err == nil && len(gpus) == 0 {
+		err = fmt.Errorf("GPU 清单为空")
+	}
+	if err != nil {
+		w.fail(lease, "GPU 不可用: "+err.Error())
+		return
+	}
+	// 不论 GPU_CONFLICT_POLICY 如何，GPU 仍被占用时都不挂载，等待占用释放
+	gpu := GPURequest{Devices: devices, Mode: lease.Mode, Until: lease.EndsAt}
+	conflicts, err := w.gpus.Conflicts(gpus, lease.Mode, gpuClaim(user, cont.ID, gpu))
+	if err != nil {
+		w.fail(lease, err.Error())
+		return
+	}
+	if len(conflicts) > 0 {
+		w.fail(lease, "GPU 仍被占用: "+describeGPUConflicts(conflicts))
+		return
+	}
+
+	// 重建会终止容器内的进程，提醒发出满 GPU_LEASE_NOTICE 前保持未开始，下一轮再检查
+	if !w.noticeGiven(lease.StartWarnedAt, now) {
+		return
+	}
+
+	// 第一次尝试重建前保存原来的 GPU 配置，重试时容器可能已是部分完成的状态，不能再覆盖
+	if lease.PreviousContainerID == "" {
+		if err := w.recordPrevious(lease, cont); err != nil {
+			w.fail(lease, "无法记录原来的 GPU 配置: "+err.Error())
+			return
+		}
+	}
+
+	event := w.newEvent(models.AuditGPULeaseAttach, lease)
+	event.SetChange("gpu_devices", cont.GPUDevices, devices)
+	result, err := w.containerService.RecreateWithGPUs(cont.ID, gpu, "system", false)
+	if result != nil && result.Operation != nil {
+		event.SetChange("operation_id", nil, result.Operation.ID)
+	}
+	w.audit.Record(event, err)
+	if err != nil {
+		w.fail(lease, "重建容器失败: "+err.Error())
+		return
+	}
+
+	w.markActive(lease, now, "")
+	w.notifyUser(lease, NotifyGPULeaseStarted, fmt.Sprintf("GPU 租约 #%d 已开始，容器已挂载 GPU %s，租约于 %s 结束",
+		lease.ID, joinIndexes(lease.GPUIndexes), lease.EndsAt.Format("2006-01-02 15:04")))
+}
+
+// detach 重建用户容器恢复租约前的 GPU 配置。原来的 GPU 已被占用时不再挂载任何 GPU，
+// 账户停用、到期或已删除时重建后的容器保持停止
+func (w *GPULeaseWorker) detach(lease *models.GPULease, now time.Time) {
+	var note string
+	user, err := w.userService.GetUserByID(lease.UserID)
+	if err == nil && user.ContainerID != "" {
+		cont, err := w.containerService.GetContainerByID(user.ContainerID)
+		if err == nil {
+			restore := GPURequest{Devices: lease.PreviousGPUDevices, Mode: lease.PreviousGPUMode}
+			if restore.Devices != "" {
+				if err := w.restorable(restore, user, cont.ID); err != nil {
+					note = fmt.Sprintf("租约前的 GPU %s 无法恢复（%v），容器未挂载 GPU", restore.Devices, err)
+					restore = GPURequest{}
+				}
+			}
+
+			if cont.GPUDevices != restore.Devices {
+				// 提前取消的租约同样要先提醒，满 GPU_LEASE_NOTICE 后才重建
+				if !w.noticeGiven(lease.EndWarnedAt, now) {
+					return
+				}
+				event := w.newEvent(models.AuditGPULeaseDetach, lease)
+				event.SetChange("gpu_devices", cont.GPUDevices, restore.Devices)
+				// 账户已不能登录时只恢复 GPU 配置，不启动容器
+				result, err := w.containerService.RecreateWithGPUs(cont.ID, restore, "system", !user.CanLogin())
+				if result != nil && result.Operation != nil {
+					event.SetChange("operation_id", nil, result.Operation.ID)
+				}
+				w.audit.Record(event, err)
+				if err != nil {
+					// 保持进行中，GPU 仍然被占用，下一轮重试
+					w.fail(lease, "卸载 GPU 失败: "+err.Error())
+					return
+				}
+			}
+		}
+	}
+
+	_, err = w.db.Exec("UPDATE gpu_leases SET status = ?, detached_at = ?, last_error = ? WHERE id = ?",
+		models.GPULeaseEnded, now, nullIfEmpty(note), lease.ID)
+	if err != nil {
+		log.Printf("gpu lease worker: failed to end lease %d: %v", lease.ID, err)
+		return
+	}
+
+	message := fmt.Sprintf("GPU 租约 #%d 已结束，GPU %s 已卸载", lease.ID, joinIndexes(lease.GPUIndexes))
+	if note != "" {
+		message += "。" + note
+	}
+	w.notifyUser(lease, NotifyGPULeaseEnded, message)
+}
+
+// restorable 检查租约前的 GPU 是否仍然存在、未被其他容器占用且没有被其他用户预约
+func (w *GPULeaseWorker) restorable(gpu GPURequest, user *models.User, containerID string) error {
+	if !w.gpus.Enabled() {
+		return nil
+	}
+	gpus, err := w.gpus.Resolve(gpu.Devices)
+	if err != nil || gpus == nil {
+		return err
+	}
+	mode, err := w.gpus.Mode(gpu.Mode)
+	if err != nil {
+		return err
+	}
+	conflicts, err := w.gpus.Conflicts(gpus, mode, gpuClaim(user, containerID, gpu))
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		return &GPUConflictError{Conflicts: conflicts}
+	}
+	return nil
+}
+
+// recordPrevious 保存租约开始前容器的 GPU 配置，结束时恢复
+func (w *GPULeaseWorker) recordPrevious(lease *models.GPULease, cont *models.Container) error {
+	_, err := w.db.Exec(`
+		UPDATE gpu_leases SET previous_gpu_devices = ?, previous_gpu_mode = ?, previous_container_id = ?
+		WHERE id = ? AND previous_container_id IS NULL
+	`, cont.GPUDevices, cont.GPUMode, cont.ID, lease.ID)
+	if err != nil {
+		return err
+	}
+	lease.PreviousGPUDevices, lease.PreviousGPUMode, lease.PreviousContainerID = cont.GPUDevices, cont.GPUMode, cont.ID
+	return nil
+}
+
+// markActive 记录租约已开始
+func (w *GPULeaseWorker) markActive(lease *models.GPULease, now time.Time, note string) {
+	_, err := w.db.Exec("UPDATE gpu_leases SET status = ?, attached_at = ?, last_error = ? WHERE id = ?",
+		models.GPULeaseActive, now, nullIfEmpty(note), lease.ID)
+	if err != nil {
+		log.Printf("gpu lease worker: failed to activate lease %d: %v", lease.ID, err)
+	}
+}
+
+// fail 记录挂载或卸载失败的原因，同一原因只通知一次
+func (w *GPULeaseWorker) fail(lease *models.GPULease, message string) {
+	log.Printf("gpu lease worker: lease %d: %s", lease.ID, message)
+	if lease.LastError == message {
+		return
+	}
+	w.db.Exec("UPDATE gpu_leases SET last_error = ? WHERE id = ?", message, lease.ID)
+	w.notifyUser(lease, NotifyGPULeaseFailed, fmt.Sprintf("GPU 租约 #%d 处理失败，将自动重试: %s", lease.ID, message))
+}
+
+func (w *GPULeaseWorker) notifyUser(lease *models.GPULease, event, message string) error {
+	n := &Notification{
+		Event:    event,
+		UserID:   lease.UserID,
+		Username: lease.Username,
+		Message:  message,
+		Data: map[string]interface{}{
+			"lease_id":  lease.ID,
+			"gpu_uuids": lease.GPUUUIDs,
+			"starts_at": lease.StartsAt,
+			"ends_at":   lease.EndsAt,
+		},
+		Time: time.Now(),
+	}
+	if user, err := w.userService.GetUserByID(lease.UserID); err == nil {
+		n.Email = user.Email
+	}
+
+	err := w.notifier.Notify(n)
+	if err != nil {
+		log.Printf("gpu lease worker: failed to send %s notification for %s: %v", event, lease.Username, err)
+	}
+	return err
+}
+
+// newEvent 租约挂载和卸载以 system 身份写审计日志
+func (w *GPULeaseWorker) newEvent(action string, lease *models.GPULease) *models.AuditEvent {
+	return &models.AuditEvent{
+		ActorName:  "system",
+		Action:     action,
+		TargetType: "gpu_lease",
+		TargetID:   strconv.Itoa(lease.ID),
+	}
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
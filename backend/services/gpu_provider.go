@@ -0,0 +1,198 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"gpu-dev-platform/models"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// nvidia-smi 查询参数，输出每行为 "index, uuid, name, memory.total(MiB)"
+var nvidiaSMIArgs = []string{"--query-gpu=index,uuid,name,memory.total", "--format=csv,noheader,nounits"}
+
+// GPUProvider 盘点主机上的 GPU
+type GPUProvider interface {
+	Name() string
+	ListGPUs() ([]*models.GPU, error)
+}
+
+// NewGPUProviderFromEnv 根据 GPU_INVENTORY_PROVIDER 选择盘点方式，none 表示不盘点，返回 nil
+func NewGPUProviderFromEnv() (GPUProvider, error) {
+	name := getEnvWithDefault("GPU_INVENTORY_PROVIDER", "nvidia-smi")
+	switch name {
+	case "nvidia-smi":
+		return &NvidiaSMIProvider{Command: getEnvWithDefault("GPU_INVENTORY_COMMAND", "nvidia-smi")}, nil
+	case "docker":
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			return nil, err
+		}
+		return &DockerGPUProvider{client: cli, Image: getEnvWithDefault("GPU_INVENTORY_IMAGE", userContainerImage)}, nil
+	case "fake":
+		return NewFakeGPUProvider(fakeGPUs(envInt("GPU_FAKE_COUNT", 4))), nil
+	case "none", "":
+		return nil, nil
+	}
+	return nil, errors.New("unknown GPU inventory provider: " + name)
+}
+
+// ParseNvidiaSMI 解析 nvidia-smi --query-gpu 的 CSV 输出
+func ParseNvidiaSMI(output string) ([]*models.GPU, error) {
+	var gpus []*models.GPU
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("unexpected nvidia-smi output: %q", line)
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+
+		index, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("unexpected GPU index in nvidia-smi output: %q", line)
+		}
+		// 部分型号不报告显存时输出 [N/A]
+		memory, _ := strconv.Atoi(fields[3])
+
+		gpus = append(gpus, &models.GPU{
+			Index:    index,
+			UUID:     fields[1],
+			Model:    fields[2],
+			MemoryMB: memory,
+			Present:  true,
+		})
+	}
+	return gpus, nil
+}
+
+// NvidiaSMIProvider 在后端所在环境直接执行 nvidia-smi，适用于后端运行在 GPU 主机上且能访问驱动的部署
+type NvidiaSMIProvider struct {
+	Command string
+}
+
+func (p *NvidiaSMIProvider) Name() string { return "nvidia-smi" }
+
+func (p *NvidiaSMIProvider) ListGPUs() ([]*models.GPU, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Command, nvidiaSMIArgs...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %v %s", p.Command, err, strings.TrimSpace(stderr.String()))
+	}
+	return ParseNvidiaSMI(string(out))
+}
+
+// DockerGPUProvider 启动一个申请全部 GPU 的临时容器执行 nvidia-smi，适用于后端运行在没有驱动的容器中
+type DockerGPUProvider struct {
+	client *client.Client
+	Image  string
+}
+
+func (p *DockerGPUProvider) Name() string { return "docker" }
+
+func (p *DockerGPUProvider) ListGPUs() ([]*models.GPU, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	resp, err := p.client.ContainerCreate(ctx,
+		&container.Config{
+			Image:      p.Image,
+			Entrypoint: []string{"nvidia-smi"},
+			Cmd:        nvidiaSMIArgs,
+		},
+		&container.HostConfig{
+			Resources: container.Resources{
+				DeviceRequests: []container.DeviceRequest{{
+					Driver:       "nvidia",
+					Count:        -1,
+					Capabilities: [][]string{{"gpu"}},
+				}},
+			},
+		},
+		nil, nil, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GPU inventory container: %v", err)
+	}
+	defer p.client.ContainerRemove(context.Background(), resp.ID, types.ContainerRemoveOptions{Force: true})
+
+	if err := p.client.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return nil, fmt.Errorf("failed to start GPU inventory container: %v", err)
+	}
+
+	statusCh, errCh := p.client.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	var exitCode int64
+	select {
+	case err := <-errCh:
+		return nil, fmt.Errorf("failed to wait for GPU inventory container: %v", err)
+	case status := <-statusCh:
+		exitCode = status.StatusCode
+	}
+
+	logs, err := p.client.ContainerLogs(ctx, resp.ID, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return nil, err
+	}
+	defer logs.Close()
+
+	var stdout, stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, logs); err != nil {
+		return nil, err
+	}
+	if exitCode != 0 {
+		return nil, fmt.Errorf("nvidia-smi exited with %d: %s", exitCode, strings.TrimSpace(stderr.String()))
+	}
+	return ParseNvidiaSMI(stdout.String())
+}
+
+// FakeGPUProvider 返回固定的 GPU 列表，用于测试和没有 GPU 的开发环境
+type FakeGPUProvider struct {
+	GPUs []*models.GPU
+}
+
+func NewFakeGPUProvider(gpus []*models.GPU) *FakeGPUProvider {
+	return &FakeGPUProvider{GPUs: gpus}
+}
+
+func (p *FakeGPUProvider) Name() string { return "fake" }
+
+func (p *FakeGPUProvider) ListGPUs() ([]*models.GPU, error) {
+	gpus := make([]*models.GPU, len(p.GPUs))
+	for i, gpu := range p.GPUs {
+		copied := *gpu
+		gpus[i] = &copied
+	}
+	return gpus, nil
+}
+
+func fakeGPUs(count int) []*models.GPU {
+	gpus := make([]*models.GPU, count)
+	for i := range gpus {
+		gpus[i] = &models.GPU{
+			Index:    i,
+			UUID:     fmt.Sprintf("GPU-00000000-0000-0000-0000-%012d", i),
+			Model:    "Fake GPU",
+			MemoryMB: 24576,
+			Present:  true,
+		}
+	}
+	return gpus
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseNvidiaSMI(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    []string // "index uuid model memory"
+		wantErr bool
+	}{
+		{name: "empty output", output: "", want: nil},
+		{name: "single GPU", output: "0, GPU-aaa, NVIDIA A100-SXM4-80GB, 81920\n",
+			want: []string{"0 GPU-aaa NVIDIA A100-SXM4-80GB 81920"}},
+		{name: "multiple GPUs with blank lines", output: "\n0, GPU-aaa, Tesla T4, 15360\n\n1, GPU-bbb, Tesla T4, 15360\n",
+			want: []string{"0 GPU-aaa Tesla T4 15360", "1 GPU-bbb Tesla T4 15360"}},
+		{name: "memory not reported", output: "0, GPU-aaa, NVIDIA GRID, [N/A]",
+			want: []string{"0 GPU-aaa NVIDIA GRID 0"}},
+		{name: "CRLF line endings", output: "0, GPU-aaa, Tesla T4, 15360\r\n1, GPU-bbb, Tesla T4, 15360\r\n",
+			want: []string{"0 GPU-aaa Tesla T4 15360", "1 GPU-bbb Tesla T4 15360"}},
+		{name: "too few fields", output: "0, GPU-aaa, 15360", wantErr: true},
+		{name: "too many fields", output: "0, GPU-aaa, Tesla, T4, 15360", wantErr: true},
+		{name: "non-numeric index", output: "x, GPU-aaa, Tesla T4, 15360", wantErr: true},
+		{name: "error message instead of CSV", output: "NVIDIA-SMI has failed because it couldn't communicate with the NVIDIA driver.", wantErr: true},
+		{name: "malformed line after valid one", output: "0, GPU-aaa, Tesla T4, 15360\ngarbage", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gpus, err := ParseNvidiaSMI(tt.output)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseNvidiaSMI(%q) = %v, want error", tt.output, gpus)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, gpu := range gpus {
+				if !gpu.Present {
+					t.Errorf("GPU %d not marked present", gpu.Index)
+				}
+				got = append(got, fmt.Sprintf("%d %s %s %d", gpu.Index, gpu.UUID, gpu.Model, gpu.MemoryMB))
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("ParseNvidiaSMI(%q) = %q, want %q", tt.output, got, tt.want)
+			}
+		})
+	}
+}
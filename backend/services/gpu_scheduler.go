@@ -0,0 +1,161 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"gpu-dev-platform/models"
+)
+
+// GPU 调度策略
+const (
+	GPUStrategyPack   = "pack"   // 优先使用已被共享的 GPU 和满足要求的最小显存 GPU，保留完整的大显存 GPU
+	GPUStrategySpread = "spread" // 优先使用负载最少的 GPU，共享容器尽量分散到不同 GPU
+)
+
+var (
+	ErrInvalidGPURequest = errors.New("invalid GPU request")
+	ErrInsufficientGPUs  = errors.New("not enough free GPUs")
+)
+
+// 最多可以请求的 GPU 数量，防止误填的大数字
+const maxGPUCount = 64
+
+// gpuCandidate 满足型号和显存要求、且可以按请求方式使用的 GPU，load 为现有占用数
+type gpuCandidate struct {
+	gpu  *models.GPU
+	load int
+}
+
+func scheduleStrategyFromEnv() string {
+	strategy := getEnvWithDefault("GPU_SCHEDULE_STRATEGY", GPUStrategyPack)
+	if strategy != GPUStrategyPack && strategy != GPUStrategySpread {
+		log.Printf("WARNING: invalid GPU_SCHEDULE_STRATEGY %q, using %s", strategy, GPUStrategyPack)
+		return GPUStrategyPack
+	}
+	return strategy
+}
+
+// Schedule 按数量、型号和显存要求挑选可用的 GPU，按序号排序返回。
+// 独占请求只考虑没有任何占用的 GPU，共享请求只排除被独占的 GPU，其他用户在 claim 时段内的租约视同占用。
+// claim.ContainerID 的现有占用不计入，用于重建容器
+func (s *GPUService) Schedule(req GPURequest, mode string, claim GPUClaim) ([]*models.GPU, error) {
+	if strings.TrimSpace(req.Devices) != "" {
+		return nil, fmt.Errorf("%w: gpu_devices 与 gpu_count 不能同时指定", ErrInvalidGPURequest)
+	}
+	count := req.Count
+	if count == 0 {
+		// 只写了型号或显存要求时分配一块
+		count = 1
+	}
+	if count < 0 || count > maxGPUCount {
+		return nil, fmt.Errorf("%w: GPU 数量无效: %d", ErrInvalidGPURequest, req.Count)
+	}
+	if req.MinMemoryMB < 0 {
+		return nil, fmt.Errorf("%w: 显存要求无效", ErrInvalidGPURequest)
+	}
+	if !s.Enabled() {
+		return nil, fmt.Errorf("%w: 自动分配 GPU 需要配置 GPU 盘点 (GPU_INVENTORY_PROVIDER)", ErrInvalidGPURequest)
+	}
+
+	s.refreshIfStale()
+	present, err := s.queryGPUs("SELECT uuid, gpu_index, COALESCE(model, ''), memory_mb, present, last_seen FROM gpus WHERE present = TRUE ORDER BY gpu_index")
+	if err != nil {
+		return nil, err
+	}
+	if len(present) == 0 {
+		return nil, fmt.Errorf("%w: GPU 清单为空，请检查 GPU 盘点", ErrInsufficientGPUs)
+	}
+	assignments, err := s.claimed(s.db, claim, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	load := make(map[string]int)
+	exclusive := make(map[string]bool)
+	for _, a := range assignments {
+		load[a.GPUUUID]++
+		if a.Mode == models.GPUModeExclusive {
+			exclusive[a.GPUUUID] = true
+		}
+	}
+
+	var candidates []gpuCandidate
+	matched := 0
+	for _, gpu := range present {
+		if !gpuMatches(gpu, req) {
+			continue
+		}
+		matched++
+		if exclusive[gpu.UUID] || (mode == models.GPUModeExclusive && load[gpu.UUID] > 0) {
+			continue
+		}
+		candidates = append(candidates, gpuCandidate{gpu: gpu, load: load[gpu.UUID]})
+	}
+
+	if len(candidates) < count {
+		return nil, fmt.Errorf("%w: 请求 %d 块%s，符合要求的 %d 块中只有 %d 块可以按%s方式分配",
+			ErrInsufficientGPUs, count, describeGPURequirement(req), matched, len(candidates), gpuModeName(mode))
+	}
+
+	s.sortCandidates(candidates)
+	chosen := make([]*models.GPU, count)
+	for i := range chosen {
+		chosen[i] = candidates[i].gpu
+	}
+	sort.Slice(chosen, func(i, j int) bool { return chosen[i].Index < chosen[j].Index })
+	return chosen, nil
+}
+
+// sortCandidates 按调度策略排序，相同条件下按序号
+func (s *GPUService) sortCandidates(candidates []gpuCandidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.load != b.load {
+			if s.strategy == GPUStrategySpread {
+				return a.load < b.load
+			}
+			return a.load > b.load
+		}
+		if s.strategy == GPUStrategyPack && a.gpu.MemoryMB != b.gpu.MemoryMB {
+			return a.gpu.MemoryMB < b.gpu.MemoryMB
+		}
+		return a.gpu.Index < b.gpu.Index
+	})
+}
+
+// gpuMatches 型号按关键字匹配；nvidia-smi 报告的显存略小于标称值（如 24GB 的卡报告 24564MiB），
+// 允许 2% 的差额，以免按标称显存填写的要求排除掉符合的卡
+func gpuMatches(gpu *models.GPU, req GPURequest) bool {
+	if req.Model != "" && !strings.Contains(strings.ToLower(gpu.Model), strings.ToLower(strings.TrimSpace(req.Model))) {
+		return false
+	}
+	if req.MinMemoryMB > 0 && gpu.MemoryMB < req.MinMemoryMB-req.MinMemoryMB/50 {
+		return false
+	}
+	return true
+}
+
+func describeGPURequirement(req GPURequest) string {
+	var parts []string
+	if req.Model != "" {
+		parts = append(parts, "型号包含 "+req.Model)
+	}
+	if req.MinMemoryMB > 0 {
+		parts = append(parts, fmt.Sprintf("显存不少于 %dMiB", req.MinMemoryMB))
+	}
+	if len(parts) == 0 {
+		return " GPU"
+	}
+	return " GPU（" + strings.Join(parts, "，") + "）"
+}
+
+func gpuModeName(mode string) string {
+	if mode == models.GPUModeShared {
+		return "共享"
+	}
+	return "独占"
+}
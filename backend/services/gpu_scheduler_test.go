@@ -0,0 +1,98 @@
+package services
+
+import (
+	"testing"
+
+	"gpu-dev-platform/models"
+)
+
+func TestGPUMatches(t *testing.T) {
+	rtx := &models.GPU{Index: 0, Model: "NVIDIA GeForce RTX 4090", MemoryMB: 24564}
+
+	tests := []struct {
+		name string
+		gpu  *models.GPU
+		req  GPURequest
+		want bool
+	}{
+		{name: "no requirements", gpu: rtx, req: GPURequest{}, want: true},
+		{name: "model keyword", gpu: rtx, req: GPURequest{Model: "4090"}, want: true},
+		{name: "model is case-insensitive and trimmed", gpu: rtx, req: GPURequest{Model: " rtx 4090 "}, want: true},
+		{name: "different model", gpu: rtx, req: GPURequest{Model: "A100"}, want: false},
+		{name: "memory below reported size", gpu: rtx, req: GPURequest{MinMemoryMB: 16384}, want: true},
+		{name: "nominal 24GB within 2% tolerance", gpu: rtx, req: GPURequest{MinMemoryMB: 24576}, want: true},
+		{name: "exactly at the tolerance", gpu: &models.GPU{MemoryMB: 9800}, req: GPURequest{MinMemoryMB: 10000}, want: true},
+		{name: "just below the tolerance", gpu: &models.GPU{MemoryMB: 9799}, req: GPURequest{MinMemoryMB: 10000}, want: false},
+		{name: "larger card required", gpu: rtx, req: GPURequest{MinMemoryMB: 40960}, want: false},
+		{name: "memory not reported", gpu: &models.GPU{Model: "NVIDIA GRID"}, req: GPURequest{MinMemoryMB: 1024}, want: false},
+		{name: "model and memory both required", gpu: rtx, req: GPURequest{Model: "4090", MinMemoryMB: 40960}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := gpuMatches(tt.gpu, tt.req); got != tt.want {
+				t.Errorf("gpuMatches(%+v, %+v) = %v, want %v", tt.gpu, tt.req, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortCandidates(t *testing.T) {
+	// 序号 0-3：0 为空闲的 80GB，1 为空闲的 24GB，2 已有 2 个共享容器，3 已有 1 个共享容器
+	candidates := func() []gpuCandidate {
+		return []gpuCandidate{
+			{gpu: &models.GPU{Index: 0, MemoryMB: 81920}, load: 0},
+			{gpu: &models.GPU{Index: 1, MemoryMB: 24576}, load: 0},
+			{gpu: &models.GPU{Index: 2, MemoryMB: 24576}, load: 2},
+			{gpu: &models.GPU{Index: 3, MemoryMB: 81920}, load: 1},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		strategy   string
+		candidates []gpuCandidate
+		want       []int
+	}{
+		// pack：先填满已共享的 GPU，再用显存小的空闲 GPU，保留完整的大显存 GPU
+		{name: "pack", strategy: GPUStrategyPack, candidates: candidates(), want: []int{2, 3, 1, 0}},
+		// spread：负载少的优先，相同负载按序号，不考虑显存
+		{name: "spread", strategy: GPUStrategySpread, candidates: candidates(), want: []int{0, 1, 3, 2}},
+		{name: "pack ties broken by index", strategy: GPUStrategyPack, candidates: []gpuCandidate{
+			{gpu: &models.GPU{Index: 5, MemoryMB: 24576}},
+			{gpu: &models.GPU{Index: 4, MemoryMB: 24576}},
+		}, want: []int{4, 5}},
+		{name: "spread ties broken by index", strategy: GPUStrategySpread, candidates: []gpuCandidate{
+			{gpu: &models.GPU{Index: 3, MemoryMB: 81920}, load: 1},
+			{gpu: &models.GPU{Index: 1, MemoryMB: 24576}, load: 1},
+		}, want: []int{1, 3}},
+		{name: "empty", strategy: GPUStrategyPack, candidates: nil, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &GPUService{strategy: tt.strategy}
+			s.sortCandidates(tt.candidates)
+
+			var got []int
+			for _, c := range tt.candidates {
+				got = append(got, c.gpu.Index)
+			}
+			if !equalInts(got, tt.want) {
+				t.Errorf("order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,561 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gpu-dev-platform/database"
+	"gpu-dev-platform/models"
+)
+
+var (
+	ErrUnknownGPU     = errors.New("unknown GPU")
+	ErrInvalidGPUMode = errors.New("invalid GPU mode")
+)
+
+// GPUConflictError 请求的 GPU 已被其他容器以不兼容的方式占用
+type GPUConflictError struct {
+	Conflicts []*models.GPUAssignment
+}
+
+func (e *GPUConflictError) Error() string {
+	return "GPU 冲突: " + describeGPUConflicts(e.Conflicts)
+}
+
+// GPURequest 容器请求的 GPU。Devices 为 all、逗号分隔的序号或 UUID，Mode 留空时使用 GPU_DEFAULT_MODE。
+// 不指定 Devices 而设置 Count 时由调度器按型号和显存要求挑选空闲的 GPU
+type GPURequest struct {
+	Devices     string
+	Mode        string
+	Count       int
+	Model       string // 型号关键字，不区分大小写，如 A100
+	MinMemoryMB int
+	Until       time.Time // 只使用到该时间，如租约挂载的 GPU；零值表示长期使用
+}
+
+// Scheduled 是否需要调度器挑选 GPU
+func (r GPURequest) Scheduled() bool {
+	return r.Count != 0 || strings.TrimSpace(r.Model) != "" || r.MinMemoryMB != 0
+}
+
+// GPUClaim 要使用 GPU 的用户和时段。ContainerID 为刚创建的容器，Replaces 为重建时被替换的旧容器，
+// 两者的现有占用都不计入；Until 为预计使用的截止时间，零值表示长期使用。
+// 该时段内其他用户已预约的 GPU 视为被占用，用户自己的租约不算冲突
+type GPUClaim struct {
+	UserID      int
+	ContainerID string
+	Replaces    string
+	Until       time.Time
+}
+
+// GPUService 维护 GPU 清单和容器对 GPU 的占用
+type GPUService struct {
+	db              *sql.DB
+	provider        GPUProvider
+	refreshInterval time.Duration
+	defaultMode     string
+	warnOnConflict  bool
+	strategy        string
+
+	mu          sync.Mutex
+	lastRefresh time.Time
+}
+
+var (
+	defaultGPUService     *GPUService
+	defaultGPUServiceOnce sync.Once
+)
+
+// DefaultGPUService 返回共用的 GPU 服务，盘点结果的刷新时间在各处共享
+func DefaultGPUService() *GPUService {
+	defaultGPUServiceOnce.Do(func() {
+		defaultGPUService = newGPUService()
+	})
+	return defaultGPUService
+}
+
+func newGPUService() *GPUService {
+	provider, err := NewGPUProviderFromEnv()
+	if err != nil {
+		log.Printf("WARNING: %v, GPU inventory disabled", err)
+	}
+
+	mode := getEnvWithDefault("GPU_DEFAULT_MODE", models.GPUModeExclusive)
+	if !models.ValidGPUMode(mode) {
+		log.Printf("WARNING: invalid GPU_DEFAULT_MODE %q, using %s", mode, models.GPUModeExclusive)
+		mode = models.GPUModeExclusive
+	}
+
+	return &GPUService{
+		db:              database.DB,
+		provider:        provider,
+		refreshInterval: parseDurationEnv("GPU_INVENTORY_REFRESH", 5*time.Minute),
+		defaultMode:     mode,
+		warnOnConflict:  getEnvWithDefault("GPU_CONFLICT_POLICY", "reject") == "warn",
+		strategy:        scheduleStrategyFromEnv(),
+	}
+}
+
+// Enabled 是否配置了 GPU 盘点，未配置时 GPU 设备按原样传给 Docker，不做冲突检查
+func (s *GPUService) Enabled() bool {
+	return s.provider != nil
+}
+
+// Refresh 重新盘点 GPU：更新型号、显存和序号，消失的 GPU 标记为不在位，
+// 并为盘点前创建、只记录了序号的容器补充占用记录
+func (s *GPUService) Refresh() error {
+	if s.provider == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refreshLocked()
+}
+
+func (s *GPUService) refreshLocked() error {
+	gpus, err := s.provider.ListGPUs()
+	// 失败时也记录时间，避免每个请求都重新执行盘点
+	s.lastRefresh = time.Now()
+	if err != nil {
+		return fmt.Errorf("GPU inventory via %s failed: %v", s.provider.Name(), err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE gpus SET present = FALSE"); err != nil {
+		return err
+	}
+	for _, gpu := range gpus {
+		_, err := tx.Exec(`
+			INSERT INTO gpus (uuid, gpu_index, model, memory_mb, present, last_seen) VALUES (?, ?, ?, ?, TRUE, ?)
+			ON DUPLICATE KEY UPDATE gpu_index = VALUES(gpu_index), model = VALUES(model), memory_mb = VALUES(memory_mb),
+				present = TRUE, last_seen = VALUES(last_seen)
+		`, gpu.UUID, gpu.Index, gpu.Model, gpu.MemoryMB, s.lastRefresh)
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if err := s.backfillAssignments(); err != nil {
+		log.Printf("gpu inventory: failed to backfill assignments: %v", err)
+	}
+	return nil
+}
+
+// refreshIfStale 超过 GPU_INVENTORY_REFRESH 未盘点时重新盘点，失败时沿用数据库中的清单
+func (s *GPUService) refreshIfStale() {
+	if s.provider == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.lastRefresh) < s.refreshInterval {
+		return
+	}
+	if err := s.refreshLocked(); err != nil {
+		log.Printf("gpu inventory: %v", err)
+	}
+}
+
+// List 全部 GPU 及其占用情况，不在位的 GPU 排在最后
+func (s *GPUService) List() ([]*models.GPU, error) {
+	s.refreshIfStale()
+
+	gpus, err := s.queryGPUs("SELECT uuid, gpu_index, COALESCE(model, ''), memory_mb, present, last_seen FROM gpus ORDER BY present DESC, gpu_index")
+	if err != nil {
+		return nil, err
+	}
+	assignments, err := s.assignments("", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	byUUID := make(map[string]*models.GPU, len(gpus))
+	for _, gpu := range gpus {
+		gpu.Assignments = []*models.GPUAssignment{}
+		byUUID[gpu.UUID] = gpu
+	}
+	for _, a := range assignments {
+		if gpu := byUUID[a.GPUUUID]; gpu != nil {
+			gpu.Assignments = append(gpu.Assignments, a)
+		}
+	}
+	return gpus, nil
+}
+
+// Resolve 把 all、序号或 UUID 解析为在位的 GPU。清单为空（盘点未配置或未成功）时返回 nil，调用方应按原样处理
+func (s *GPUService) Resolve(devices string) ([]*models.GPU, error) {
+	s.refreshIfStale()
+	return s.resolve(devices)
+}
+
+func (s *GPUService) resolve(devices string) ([]*models.GPU, error) {
+	present, err := s.queryGPUs("SELECT uuid, gpu_index, COALESCE(model, ''), memory_mb, present, last_seen FROM gpus WHERE present = TRUE ORDER BY gpu_index")
+	if err != nil {
+		return nil, err
+	}
+	if len(present) == 0 {
+		return nil, nil
+	}
+
+	devices = strings.TrimSpace(devices)
+	if devices == "all" {
+		return present, nil
+	}
+
+	var resolved []*models.GPU
+	seen := make(map[string]bool)
+	for _, token := range strings.Split(devices, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+
+		var match *models.GPU
+		for _, gpu := range present {
+			if strconv.Itoa(gpu.Index) == token || strings.EqualFold(gpu.UUID, token) {
+				match = gpu
+				break
+			}
+		}
+		if match == nil {
+			available := make([]string, len(present))
+			for i, gpu := range present {
+				available[i] = strconv.Itoa(gpu.Index)
+			}
+			return nil, fmt.Errorf("%w: GPU %s 不存在，可用的 GPU: %s", ErrUnknownGPU, token, strings.Join(available, ","))
+		}
+		if !seen[match.UUID] {
+			seen[match.UUID] = true
+			resolved = append(resolved, match)
+		}
+	}
+
+	sort.Slice(resolved, func(i, j int) bool { return resolved[i].Index < resolved[j].Index })
+	return resolved, nil
+}
+
+// Mode 校验分配方式，留空时返回默认方式
+func (s *GPUService) Mode(mode string) (string, error) {
+	if mode == "" {
+		return s.defaultMode, nil
+	}
+	if !models.ValidGPUMode(mode) {
+		return "", fmt.Errorf("%w: %s，可选 %s 或 %s", ErrInvalidGPUMode, mode, models.GPUModeExclusive, models.GPUModeShared)
+	}
+	return mode, nil
+}
+
+// WarnOnConflict GPU_CONFLICT_POLICY=warn 时冲突只作为警告返回，仍然分配
+func (s *GPUService) WarnOnConflict() bool {
+	return s.warnOnConflict
+}
+
+// Conflicts 返回与请求不兼容的现有占用和其他用户的预约：独占请求与任何占用冲突，共享请求只与独占占用冲突
+func (s *GPUService) Conflicts(gpus []*models.GPU, mode string, claim GPUClaim) ([]*models.GPUAssignment, error) {
+	return s.conflicts(s.db, gpus, mode, claim)
+}
+
+// Assign 在事务中锁定 GPU 记录、再次检查冲突并为 claim.ContainerID 记录占用。拒绝冲突时返回 GPUConflictError，
+// 设置为仅警告时照常记录并返回冲突列表
+func (s *GPUService) Assign(claim GPUClaim, gpus []*models.GPU, mode string) ([]*models.GPUAssignment, error) {
+	return s.assign(claim, gpus, mode, s.warnOnConflict)
+}
+
+// AssignScheduled 记录调度器挑选的 GPU。调度时这些 GPU 是空闲的，出现冲突说明被并发请求抢先，
+// 不论 GPU_CONFLICT_POLICY 如何都拒绝
+func (s *GPUService) AssignScheduled(claim GPUClaim, gpus []*models.GPU, mode string) error {
+	_, err := s.assign(claim, gpus, mode, false)
+	return err
+}
+
+func (s *GPUService) assign(claim GPUClaim, gpus []*models.GPU, mode string, allowConflicts bool) ([]*models.GPUAssignment, error) {
+	if len(gpus) == 0 {
+		return nil, nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	uuids := gpuUUIDs(gpus)
+	rows, err := tx.Query("SELECT uuid FROM gpus WHERE uuid IN ("+placeholders(len(uuids))+") FOR UPDATE", stringArgs(uuids)...)
+	if err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	conflicts, err := s.conflicts(tx, gpus, mode, claim)
+	if err != nil {
+		return nil, err
+	}
+	if len(conflicts) > 0 && !allowConflicts {
+		return conflicts, &GPUConflictError{Conflicts: conflicts}
+	}
+
+	for _, uuid := range uuids {
+		if _, err := tx.Exec("INSERT INTO gpu_assignments (container_id, gpu_uuid, mode, created_at) VALUES (?, ?, ?, ?)",
+			claim.ContainerID, uuid, mode, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	return conflicts, tx.Commit()
+}
+
+// ContainerMode 容器当前占用 GPU 的方式，没有占用记录时返回默认方式
+func (s *GPUService) ContainerMode(containerID string) string {
+	var mode string
+	if err := s.db.QueryRow("SELECT mode FROM gpu_assignments WHERE container_id = ? LIMIT 1", containerID).Scan(&mode); err != nil {
+		return s.defaultMode
+	}
+	return mode
+}
+
+// NormalizeDevices 以序号表示解析后的 GPU，all 保持不变，用于保存到容器记录和比较
+func NormalizeDevices(devices string, gpus []*models.GPU) string {
+	if strings.TrimSpace(devices) == "all" || gpus == nil {
+		return strings.TrimSpace(devices)
+	}
+	indexes := make([]string, len(gpus))
+	for i, gpu := range gpus {
+		indexes[i] = strconv.Itoa(gpu.Index)
+	}
+	return strings.Join(indexes, ",")
+}
+
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func (s *GPUService) conflicts(q queryer, gpus []*models.GPU, mode string, claim GPUClaim) ([]*models.GPUAssignment, error) {
+	if len(gpus) == 0 {
+		return nil, nil
+	}
+	existing, err := s.claimed(q, claim, gpuUUIDs(gpus))
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []*models.GPUAssignment
+	for _, a := range existing {
+		if mode == models.GPUModeExclusive || a.Mode == models.GPUModeExclusive {
+			conflicts = append(conflicts, a)
+		}
+	}
+	return conflicts, nil
+}
+
+// claimed 其他容器的占用，加上与 claim 时段重叠的其他用户租约的预约。
+// 租约已挂载到容器时只保留容器的占用，避免同一 GPU 重复列出
+func (s *GPUService) claimed(q queryer, claim GPUClaim, uuids []string) ([]*models.GPUAssignment, error) {
+	existing, err := s.assignmentsWith(q, []string{claim.ContainerID, claim.Replaces}, uuids)
+	if err != nil {
+		return nil, err
+	}
+	reserved, err := s.leaseReservations(q, claim, uuids)
+	if err != nil {
+		return nil, err
+	}
+
+	held := make(map[string]bool, len(existing))
+	for _, a := range existing {
+		held[fmt.Sprintf("%s/%d", a.GPUUUID, a.UserID)] = true
+	}
+	for _, r := range reserved {
+		if !held[fmt.Sprintf("%s/%d", r.GPUUUID, r.UserID)] {
+			existing = append(existing, r)
+		}
+	}
+	return existing, nil
+}
+
+// leaseReservations 与 claim 时段重叠、未结束的其他用户租约，每块 GPU 一条，uuids 为空时返回全部
+func (s *GPUService) leaseReservations(q queryer, claim GPUClaim, uuids []string) ([]*models.GPUAssignment, error) {
+	query := `
+		SELECT lg.gpu_uuid, g.gpu_index, l.id, l.user_id, COALESCE(u.username, ''), l.mode, l.starts_at, l.ends_at
+		FROM gpu_leases l
+		JOIN gpu_lease_gpus lg ON lg.lease_id = l.id
+		JOIN gpus g ON g.uuid = lg.gpu_uuid
+		LEFT JOIN users u ON u.id = l.user_id
+		WHERE l.status IN (?, ?) AND l.user_id <> ? AND l.ends_at > ?`
+	args := []interface{}{models.GPULeaseScheduled, models.GPULeaseActive, claim.UserID, time.Now()}
+	if !claim.Until.IsZero() {
+		query += " AND l.starts_at < ?"
+		args = append(args, claim.Until)
+	}
+	if len(uuids) > 0 {
+		query += " AND lg.gpu_uuid IN (" + placeholders(len(uuids)) + ")"
+		args = append(args, stringArgs(uuids)...)
+	}
+	query += " ORDER BY g.gpu_index, l.starts_at"
+
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*models.GPUAssignment
+	for rows.Next() {
+		r := &models.GPUAssignment{}
+		var endsAt time.Time
+		if err := rows.Scan(&r.GPUUUID, &r.GPUIndex, &r.LeaseID, &r.UserID, &r.Username, &r.Mode, &r.CreatedAt, &endsAt); err != nil {
+			return nil, err
+		}
+		r.ContainerName = fmt.Sprintf("租约 #%d", r.LeaseID)
+		r.EndsAt = &endsAt
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+func (s *GPUService) assignments(excludeContainerID string, uuids []string) ([]*models.GPUAssignment, error) {
+	return s.assignmentsWith(s.db, []string{excludeContainerID}, uuids)
+}
+
+// assignmentsWith 查询占用记录，附带容器名称、用户和 GPU 序号，不包括 exclude 中的容器，uuids 为空时返回全部
+func (s *GPUService) assignmentsWith(q queryer, exclude []string, uuids []string) ([]*models.GPUAssignment, error) {
+	query := `
+		SELECT a.gpu_uuid, g.gpu_index, a.container_id, c.name, c.user_id, COALESCE(u.username, ''), a.mode, a.created_at
+		FROM gpu_assignments a
+		JOIN gpus g ON g.uuid = a.gpu_uuid
+		JOIN containers c ON c.id = a.container_id
+		LEFT JOIN users u ON u.id = c.user_id
+		WHERE a.container_id NOT IN (`+placeholders(len(exclude))+`)`
+	args := stringArgs(exclude)
+	if len(uuids) > 0 {
+		query += " AND a.gpu_uuid IN (" + placeholders(len(uuids)) + ")"
+		args = append(args, stringArgs(uuids)...)
+	}
+	query += " ORDER BY g.gpu_index, a.created_at"
+
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*models.GPUAssignment
+	for rows.Next() {
+		a := &models.GPUAssignment{}
+		if err := rows.Scan(&a.GPUUUID, &a.GPUIndex, &a.ContainerID, &a.ContainerName, &a.UserID, &a.Username, &a.Mode, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, a)
+	}
+	return result, rows.Err()
+}
+
+func (s *GPUService) queryGPUs(query string, args ...interface{}) ([]*models.GPU, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var gpus []*models.GPU
+	for rows.Next() {
+		gpu := &models.GPU{}
+		if err := rows.Scan(&gpu.UUID, &gpu.Index, &gpu.Model, &gpu.MemoryMB, &gpu.Present, &gpu.LastSeen); err != nil {
+			return nil, err
+		}
+		gpus = append(gpus, gpu)
+	}
+	return gpus, rows.Err()
+}
+
+// backfillAssignments 盘点前创建的容器只记录了 GPU 序号，按当前序号补充共享方式的占用记录，
+// 使新的独占请求不会分到这些 GPU
+func (s *GPUService) backfillAssignments() error {
+	rows, err := s.db.Query(`
+		SELECT id, gpu_devices FROM containers c
+		WHERE COALESCE(gpu_devices, '') <> ''
+		  AND NOT EXISTS (SELECT 1 FROM gpu_assignments a WHERE a.container_id = c.id)
+	`)
+	if err != nil {
+		return err
+	}
+	legacy := map[string]string{}
+	for rows.Next() {
+		var id, devices string
+		if err := rows.Scan(&id, &devices); err == nil {
+			legacy[id] = devices
+		}
+	}
+	rows.Close()
+
+	for id, devices := range legacy {
+		// 盘点过程中已持有锁，不能再触发刷新
+		gpus, err := s.resolve(devices)
+		if err != nil || len(gpus) == 0 {
+			log.Printf("gpu inventory: cannot map GPUs %q of container %s: %v", devices, id, err)
+			continue
+		}
+		for _, uuid := range gpuUUIDs(gpus) {
+			s.db.Exec("INSERT IGNORE INTO gpu_assignments (container_id, gpu_uuid, mode, created_at) VALUES (?, ?, ?, ?)",
+				id, uuid, models.GPUModeShared, time.Now())
+		}
+	}
+	return nil
+}
+
+func describeGPUConflicts(conflicts []*models.GPUAssignment) string {
+	parts := make([]string, len(conflicts))
+	for i, c := range conflicts {
+		mode := "共享"
+		if c.Mode == models.GPUModeExclusive {
+			mode = "独占"
+		}
+		if c.LeaseID != 0 {
+			parts[i] = fmt.Sprintf("GPU %d 已被 %s 预约（租约 #%d，%s 至 %s，%s）", c.GPUIndex, c.Username, c.LeaseID,
+				c.CreatedAt.Format("2006-01-02 15:04"), c.EndsAt.Format("2006-01-02 15:04"), mode)
+			continue
+		}
+		parts[i] = fmt.Sprintf("GPU %d 已被 %s（%s，%s）使用", c.GPUIndex, c.ContainerName, c.Username, mode)
+	}
+	return strings.Join(parts, "；")
+}
+
+// GPUConflictWarnings 冲突仅警告时返回给调用方的提示
+func GPUConflictWarnings(conflicts []*models.GPUAssignment) []string {
+	if len(conflicts) == 0 {
+		return nil
+	}
+	return []string{"GPU 冲突（已按 GPU_CONFLICT_POLICY=warn 继续分配）: " + describeGPUConflicts(conflicts)}
+}
+
+func gpuUUIDs(gpus []*models.GPU) []string {
+	uuids := make([]string, len(gpus))
+	for i, gpu := range gpus {
+		uuids[i] = gpu.UUID
+	}
+	return uuids
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+func stringArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
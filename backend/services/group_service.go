@@ -0,0 +1,217 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"gpu-dev-platform/database"
+	"gpu-dev-platform/models"
+)
+
+var ErrInvalidGroupName = errors.New("invalid group name")
+
+// GroupService 用户组、成员关系和组共享目录
+type GroupService struct {
+	db *sql.DB
+}
+
+func NewGroupService() *GroupService {
+	return &GroupService{db: database.DB}
+}
+
+func (s *GroupService) ListGroups() ([]*models.Group, error) {
+	rows, err := s.db.Query(`
+		SELECT g.id, g.name, COALESCE(g.description, ''), g.created_at, COUNT(m.user_id)
+		FROM user_groups g LEFT JOIN group_members m ON m.group_id = g.id
+		GROUP BY g.id, g.name, g.description, g.created_at
+		ORDER BY g.name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return scanGroups(rows)
+}
+
+func (s *GroupService) GetGroup(id int) (*models.Group, error) {
+	group := &models.Group{}
+	err := s.db.QueryRow(`
+		SELECT g.id, g.name, COALESCE(g.description, ''), g.created_at,
+		       (SELECT COUNT(*) FROM group_members m WHERE m.group_id = g.id)
+		FROM user_groups g WHERE g.id = ?
+	`, id).Scan(&group.ID, &group.Name, &group.Description, &group.CreatedAt, &group.MemberCount)
+	if err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// CreateGroup 创建组并准备共享目录
+func (s *GroupService) CreateGroup(name, description string) (*models.Group, error) {
+	if !models.ValidGroupName(name) {
+		return nil, ErrInvalidGroupName
+	}
+
+	result, err := s.db.Exec("INSERT INTO user_groups (name, description, created_at) VALUES (?, ?, ?)",
+		name, description, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	// 共享目录的属组由组ID决定，因此先插入记录；目录创建失败时删除记录，避免留下没有目录的组
+	if err := ensureGroupDir(&models.Group{ID: int(id), Name: name}); err != nil {
+		s.db.Exec("DELETE FROM user_groups WHERE id = ?", id)
+		return nil, err
+	}
+
+	return s.GetGroup(int(id))
+}
+
+// DeleteGroup 删除组和成员关系，共享目录中的数据保留在磁盘上
+func (s *GroupService) DeleteGroup(id int) error {
+	result, err := s.db.Exec("DELETE FROM user_groups WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *GroupService) ListMembers(groupID int) ([]*models.GroupMember, error) {
+	rows, err := s.db.Query(`
+		SELECT m.group_id, m.user_id, u.username, COALESCE(u.email, ''), m.is_admin, m.joined_at
+		FROM group_members m JOIN users u ON u.id = m.user_id
+		WHERE m.group_id = ? ORDER BY u.username
+	`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*models.GroupMember{}
+	for rows.Next() {
+		member := &models.GroupMember{}
+		err := rows.Scan(&member.GroupID, &member.UserID, &member.Username, &member.Email,
+			&member.IsAdmin, &member.JoinedAt)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// GetMember 返回组内某个成员，不是成员时返回 sql.ErrNoRows
+func (s *GroupService) GetMember(groupID, userID int) (*models.GroupMember, error) {
+	member := &models.GroupMember{}
+	err := s.db.QueryRow(`
+		SELECT m.group_id, m.user_id, u.username, COALESCE(u.email, ''), m.is_admin, m.joined_at
+		FROM group_members m JOIN users u ON u.id = m.user_id
+		WHERE m.group_id = ? AND m.user_id = ?
+	`, groupID, userID).Scan(&member.GroupID, &member.UserID, &member.Username, &member.Email,
+		&member.IsAdmin, &member.JoinedAt)
+	if err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// SetMember 添加成员或修改成员的组管理员标记
+func (s *GroupService) SetMember(groupID, userID int, isAdmin bool) error {
+	_, err := s.db.Exec(`
+		INSERT INTO group_members (group_id, user_id, is_admin, joined_at) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE is_admin = VALUES(is_admin)
+	`, groupID, userID, isAdmin, time.Now())
+	return err
+}
+
+func (s *GroupService) RemoveMember(groupID, userID int) error {
+	result, err := s.db.Exec("DELETE FROM group_members WHERE group_id = ? AND user_id = ?", groupID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GroupsForUser 返回用户所属的全部组
+func (s *GroupService) GroupsForUser(userID int) ([]*models.Group, error) {
+	rows, err := s.db.Query(`
+		SELECT g.id, g.name, COALESCE(g.description, ''), g.created_at,
+		       (SELECT COUNT(*) FROM group_members c WHERE c.group_id = g.id)
+		FROM user_groups g JOIN group_members m ON m.group_id = g.id
+		WHERE m.user_id = ? ORDER BY g.name
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanGroups(rows)
+}
+
+// IsGroupAdmin 调用者是否为指定组的组管理员
+func (s *GroupService) IsGroupAdmin(adminID, groupID int) (bool, error) {
+	var exists int
+	err := s.db.QueryRow("SELECT 1 FROM group_members WHERE group_id = ? AND user_id = ? AND is_admin = TRUE",
+		groupID, adminID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ManagesUser 调用者是否为目标用户所在任一组的组管理员
+func (s *GroupService) ManagesUser(adminID, userID int) (bool, error) {
+	var exists int
+	err := s.db.QueryRow(`
+		SELECT 1 FROM group_members a JOIN group_members m ON m.group_id = a.group_id
+		WHERE a.user_id = ? AND a.is_admin = TRUE AND m.user_id = ?
+		LIMIT 1
+	`, adminID, userID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GroupGID 组共享目录的属组ID，成员容器中以同一个GID创建组并加入
+func GroupGID(groupID int) int {
+	return envInt("GROUP_GID_BASE", 20000) + groupID
+}
+
+// ensureGroupDir 在管理后端可见的路径下创建组共享目录
+// 目录属于组自己的GID，权限 2770：只有组成员可以访问，新建的文件继承组的GID
+func ensureGroupDir(group *models.Group) error {
+	dir := fmt.Sprintf("%s/%s", getEnvWithDefault("GROUPS_DATA_PATH", "/app/groups"), group.Name)
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return err
+	}
+	if err := os.Chown(dir, -1, GroupGID(group.ID)); err != nil {
+		return err
+	}
+	// 也会收紧旧版本创建的 0777 目录
+	return os.Chmod(dir, os.ModeSetgid|0770)
+}
+
+func scanGroups(rows *sql.Rows) ([]*models.Group, error) {
+	defer rows.Close()
+
+	groups := []*models.Group{}
+	for rows.Next() {
+		group := &models.Group{}
+		if err := rows.Scan(&group.ID, &group.Name, &group.Description, &group.CreatedAt, &group.MemberCount); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
@@ -0,0 +1,447 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gpu-dev-platform/database"
+	"gpu-dev-platform/models"
+)
+
+// gpuSpecPattern GPU 规格：all 或逗号分隔的设备编号
+var gpuSpecPattern = regexp.MustCompile(`^(all|[0-9]+(,[0-9]+)*)$`)
+
+// ImportOptions 批量导入参数，操作人信息用于写审计日志
+type ImportOptions struct {
+	DryRun           bool
+	CreateContainers bool
+	ActorID          int
+	ActorName        string
+	SourceIP         string
+}
+
+// ImportCredential 导入时下发的初始凭据，只保存在内存中供下载报告
+type ImportCredential struct {
+	Password          string
+	ContainerPassword string
+	BasePort          int
+}
+
+type importReport struct {
+	credentials map[int]ImportCredential // 按行号索引
+	expiresAt   time.Time
+}
+
+// ImportService 批量开通用户（及容器），任务状态和逐行结果存库，生成的密码只在内存中保留一段时间
+type ImportService struct {
+	db               *sql.DB
+	userService      *UserService
+	containerService *ContainerService
+	audit            *AuditService
+	maxRows          int
+	reportTTL        time.Duration
+
+	mu      sync.Mutex
+	reports map[int]*importReport
+}
+
+func NewImportService(containerService *ContainerService) *ImportService {
+	return &ImportService{
+		db:               database.DB,
+		userService:      NewUserService(),
+		containerService: containerService,
+		audit:            NewAuditService(),
+		maxRows:          envInt("IMPORT_MAX_ROWS", 1000),
+		reportTTL:        parseDurationEnv("IMPORT_REPORT_TTL", 24*time.Hour),
+		reports:          make(map[int]*importReport),
+	}
+}
+
+// ParseImportCSV 解析带表头的 CSV，列顺序不限，支持 username, email, password, gpu_devices
+func (s *ImportService) ParseImportCSV(r io.Reader) ([]*models.ImportRow, []int, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("无法读取CSV表头: %v", err)
+	}
+	// Excel 导出的 UTF-8 CSV 会带 BOM
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	if _, ok := columns["username"]; !ok {
+		return nil, nil, fmt.Errorf("CSV缺少 username 列")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	rows := []*models.ImportRow{}
+	lines := []int{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("CSV格式错误: %v", err)
+		}
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, &models.ImportRow{
+			Username:   field(record, "username"),
+			Email:      field(record, "email"),
+			Password:   field(record, "password"),
+			GPUDevices: field(record, "gpu_devices"),
+		})
+		lines = append(lines, line)
+		if len(rows) > s.maxRows {
+			return nil, nil, fmt.Errorf("单次最多导入%d个用户", s.maxRows)
+		}
+	}
+	return rows, lines, nil
+}
+
+// ParseImportJSON 解析 JSON 数组，或 {"users": [...]} 形式
+func (s *ImportService) ParseImportJSON(r io.Reader) ([]*models.ImportRow, []int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows []*models.ImportRow
+	if err := json.Unmarshal(data, &rows); err != nil {
+		var wrapped struct {
+			Users []*models.ImportRow `json:"users"`
+		}
+		if err := json.Unmarshal(data, &wrapped); err != nil {
+			return nil, nil, fmt.Errorf("JSON格式错误: %v", err)
+		}
+		rows = wrapped.Users
+	}
+	if len(rows) > s.maxRows {
+		return nil, nil, fmt.Errorf("单次最多导入%d个用户", s.maxRows)
+	}
+
+	// JSON 以数组下标（从1开始）作为行号
+	lines := make([]int, len(rows))
+	for i, row := range rows {
+		if row == nil {
+			rows[i] = &models.ImportRow{}
+		}
+		lines[i] = i + 1
+	}
+	return rows, lines, nil
+}
+
+// StartJob 创建导入任务并在后台执行，立即返回任务记录
+func (s *ImportService) StartJob(rows []*models.ImportRow, lines []int, opts ImportOptions) (*models.ImportJob, error) {
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("导入列表为空")
+	}
+
+	job := &models.ImportJob{
+		CreatedBy:        opts.ActorID,
+		CreatedByName:    opts.ActorName,
+		Status:           models.ImportJobPending,
+		DryRun:           opts.DryRun,
+		CreateContainers: opts.CreateContainers,
+		Total:            len(rows),
+		CreatedAt:        time.Now(),
+	}
+
+	result, err := s.db.Exec(`
+		INSERT INTO import_jobs (created_by, created_by_name, status, dry_run, create_containers, total, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, job.CreatedBy, job.CreatedByName, job.Status, job.DryRun, job.CreateContainers, job.Total, job.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	job.ID = int(id)
+
+	go s.run(job, rows, lines, opts)
+	return job, nil
+}
+
+func (s *ImportService) run(job *models.ImportJob, rows []*models.ImportRow, lines []int, opts ImportOptions) {
+	job.Status = models.ImportJobRunning
+	s.saveProgress(job)
+
+	report := &importReport{credentials: make(map[int]ImportCredential)}
+	seen := make(map[string]bool)
+
+	for i, row := range rows {
+		res, cred := s.importRow(row, lines[i], seen, opts)
+		job.Results = append(job.Results, res)
+		switch res.Status {
+		case models.ImportRowCreated:
+			job.Succeeded++
+		case models.ImportRowFailed:
+			job.Failed++
+		}
+		if cred != nil {
+			report.credentials[res.Line] = *cred
+		}
+
+		// 定期写回进度，便于前端轮询
+		if (i+1)%10 == 0 {
+			s.saveProgress(job)
+		}
+	}
+
+	if !opts.DryRun {
+		s.mu.Lock()
+		report.expiresAt = time.Now().Add(s.reportTTL)
+		s.reports[job.ID] = report
+		s.mu.Unlock()
+	}
+
+	now := time.Now()
+	job.FinishedAt = &now
+	job.Status = models.ImportJobCompleted
+	s.saveProgress(job)
+	log.Printf("Import job %d finished: %d succeeded, %d failed (dry_run=%v)", job.ID, job.Succeeded, job.Failed, job.DryRun)
+}
+
+// importRow 校验并开通一行，dry-run 时只校验；返回的凭据为 nil 表示没有下发密码
+func (s *ImportService) importRow(row *models.ImportRow, line int, seen map[string]bool, opts ImportOptions) (*models.ImportRowResult, *ImportCredential) {
+	res := &models.ImportRowResult{
+		Line:       line,
+		Username:   row.Username,
+		Email:      row.Email,
+		GPUDevices: normalizeGPUSpec(row.GPUDevices),
+		Status:     models.ImportRowFailed,
+	}
+	policy := DefaultPasswordPolicy()
+
+	if row.Username == "" {
+		res.Status = models.ImportRowSkipped
+		res.Error = "用户名为空"
+		return res, nil
+	}
+	if !models.ValidUsername(row.Username) {
+		res.Error = "用户名只能包含字母、数字、下划线、点和连字符，且不超过50个字符"
+		return res, nil
+	}
+	if seen[strings.ToLower(row.Username)] {
+		res.Error = "用户名在导入列表中重复"
+		return res, nil
+	}
+	seen[strings.ToLower(row.Username)] = true
+
+	if row.Email != "" && !strings.Contains(row.Email, "@") {
+		res.Error = "邮箱格式不正确"
+		return res, nil
+	}
+	if res.GPUDevices != "" && !gpuSpecPattern.MatchString(res.GPUDevices) {
+		res.Error = "GPU规格应为 all 或逗号分隔的设备编号"
+		return res, nil
+	}
+	if res.GPUDevices != "" && !opts.CreateContainers {
+		res.Error = "指定了GPU但未开启创建容器"
+		return res, nil
+	}
+	if row.Password != "" {
+		if err := policy.Validate(row.Password, row.Username); err != nil {
+			res.Error = err.Error()
+			return res, nil
+		}
+	}
+	if existing, err := s.userService.GetUserByUsername(row.Username); err == nil && existing != nil {
+		res.Status = models.ImportRowSkipped
+		res.Error = "用户名已存在"
+		return res, nil
+	} else if err != nil && err != sql.ErrNoRows {
+		res.Error = err.Error()
+		return res, nil
+	}
+
+	if opts.DryRun {
+		res.Status = models.ImportRowCreated
+		return res, nil
+	}
+
+	cred := &ImportCredential{Password: row.Password}
+	if cred.Password == "" {
+		generated, err := policy.Generate(row.Username)
+		if err != nil {
+			res.Error = err.Error()
+			return res, nil
+		}
+		cred.Password = generated
+	}
+
+	// 管理员下发的初始密码，首次登录后必须修改
+	user, err := s.userService.CreateUser(row.Username, cred.Password, row.Email, true)
+	event := s.newEvent(opts, models.AuditUserCreate, "user")
+	event.SetChange("username", nil, row.Username)
+	event.SetChange("email", nil, row.Email)
+	if err == nil {
+		event.TargetID = strconv.Itoa(user.ID)
+	}
+	s.audit.Record(event, err)
+	if err != nil {
+		res.Error = err.Error()
+		return res, nil
+	}
+	res.UserID = user.ID
+	cred.BasePort = user.BasePort
+
+	if opts.CreateContainers {
+		containerPassword, err := policy.Generate(row.Username)
+		if err != nil {
+			res.Error = "用户已创建，容器密码生成失败: " + err.Error()
+			return res, cred
+		}
+		container, err := s.containerService.CreateContainerWithPassword(user, GPURequest{Devices: res.GPUDevices}, containerPassword, nil)
+		event := s.newEvent(opts, models.AuditContainerCreate, "container")
+		event.SetChange("user_id", nil, user.ID)
+		event.SetChange("gpu_devices", nil, res.GPUDevices)
+		if container != nil {
+			event.TargetID = container.ID
+			res.ContainerID = container.ID
+		}
+		s.audit.Record(event, err)
+		if err != nil {
+			res.Error = "用户已创建，容器创建失败: " + err.Error()
+			return res, cred
+		}
+		cred.ContainerPassword = containerPassword
+	}
+
+	res.Status = models.ImportRowCreated
+	return res, cred
+}
+
+func (s *ImportService) newEvent(opts ImportOptions, action, targetType string) *models.AuditEvent {
+	return &models.AuditEvent{
+		ActorID:    opts.ActorID,
+		ActorName:  opts.ActorName,
+		Action:     action,
+		TargetType: targetType,
+		SourceIP:   opts.SourceIP,
+	}
+}
+
+func (s *ImportService) saveProgress(job *models.ImportJob) {
+	results, err := json.Marshal(job.Results)
+	if err != nil {
+		log.Printf("failed to encode import job %d results: %v", job.ID, err)
+		return
+	}
+	_, err = s.db.Exec(`
+		UPDATE import_jobs SET status = ?, succeeded = ?, failed = ?, results = ?, error = ?, finished_at = ?
+		WHERE id = ?
+	`, job.Status, job.Succeeded, job.Failed, string(results), job.Error, job.FinishedAt, job.ID)
+	if err != nil {
+		log.Printf("failed to save import job %d: %v", job.ID, err)
+	}
+}
+
+// GetJob 返回任务及逐行结果
+func (s *ImportService) GetJob(id int) (*models.ImportJob, error) {
+	job := &models.ImportJob{}
+	var results string
+	var finishedAt sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT id, created_by, created_by_name, status, dry_run, create_containers, total, succeeded, failed,
+		       COALESCE(results, ''), COALESCE(error, ''), created_at, finished_at
+		FROM import_jobs WHERE id = ?
+	`, id).Scan(&job.ID, &job.CreatedBy, &job.CreatedByName, &job.Status, &job.DryRun, &job.CreateContainers,
+		&job.Total, &job.Succeeded, &job.Failed, &results, &job.Error, &job.CreatedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	if results != "" {
+		json.Unmarshal([]byte(results), &job.Results)
+	}
+	return job, nil
+}
+
+// ListJobs 按时间倒序返回最近的任务，不含逐行结果
+func (s *ImportService) ListJobs(limit int) ([]*models.ImportJob, error) {
+	rows, err := s.db.Query(`
+		SELECT id, created_by, created_by_name, status, dry_run, create_containers, total, succeeded, failed,
+		       COALESCE(error, ''), created_at, finished_at
+		FROM import_jobs ORDER BY id DESC LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*models.ImportJob{}
+	for rows.Next() {
+		job := &models.ImportJob{}
+		var finishedAt sql.NullTime
+		if err := rows.Scan(&job.ID, &job.CreatedBy, &job.CreatedByName, &job.Status, &job.DryRun, &job.CreateContainers,
+			&job.Total, &job.Succeeded, &job.Failed, &job.Error, &job.CreatedAt, &finishedAt); err != nil {
+			return nil, err
+		}
+		if finishedAt.Valid {
+			job.FinishedAt = &finishedAt.Time
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// Credentials 返回任务下发的凭据，报告过期或服务重启后返回 false
+func (s *ImportService) Credentials(jobID int) (map[int]ImportCredential, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, report := range s.reports {
+		if now.After(report.expiresAt) {
+			delete(s.reports, id)
+		}
+	}
+	report, ok := s.reports[jobID]
+	if !ok {
+		return nil, false
+	}
+	return report.credentials, true
+}
+
+// MarkInterruptedJobs 服务重启时把未完成的任务标记为失败，已处理的行结果保留
+func (s *ImportService) MarkInterruptedJobs() error {
+	_, err := s.db.Exec(`
+		UPDATE import_jobs SET status = ?, error = ?, finished_at = ?
+		WHERE status IN (?, ?)
+	`, models.ImportJobFailed, "服务重启，任务被中断", time.Now(), models.ImportJobPending, models.ImportJobRunning)
+	return err
+}
+
+// normalizeGPUSpec 允许用分号或空格分隔设备编号，避免 CSV 中需要给逗号加引号
+func normalizeGPUSpec(spec string) string {
+	spec = strings.TrimSpace(spec)
+	spec = strings.NewReplacer(";", ",", " ", ",").Replace(spec)
+	parts := []string{}
+	for _, part := range strings.Split(spec, ",") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gpu-dev-platform/models"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPConfig LDAP/AD 认证配置
+type LDAPConfig struct {
+	URL           string // ldap://host:389 或 ldaps://host:636
+	StartTLS      bool
+	SkipTLSVerify bool
+	BindDN        string // 用于查找用户的服务账号，留空则匿名查找
+	BindPassword  string
+	BaseDN        string
+	UserFilter    string // %s 替换为转义后的用户名
+	UsernameAttr  string
+	EmailAttr     string
+	GroupAttr     string // 用户条目上列出所属组的属性，AD为 memberOf
+	AdminGroupDN  string // 该组成员映射为管理员，留空则不改变管理员标记
+	SyncEmail     bool
+}
+
+func LoadLDAPConfig() (*LDAPConfig, error) {
+	cfg := &LDAPConfig{
+		URL:           os.Getenv("LDAP_URL"),
+		StartTLS:      envBool("LDAP_START_TLS", false),
+		SkipTLSVerify: envBool("LDAP_SKIP_TLS_VERIFY", false),
+		BindDN:        os.Getenv("LDAP_BIND_DN"),
+		BindPassword:  os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:        os.Getenv("LDAP_BASE_DN"),
+		UserFilter:    getEnvWithDefault("LDAP_USER_FILTER", "(uid=%s)"),
+		UsernameAttr:  getEnvWithDefault("LDAP_USERNAME_ATTR", "uid"),
+		EmailAttr:     getEnvWithDefault("LDAP_EMAIL_ATTR", "mail"),
+		GroupAttr:     getEnvWithDefault("LDAP_GROUP_ATTR", "memberOf"),
+		AdminGroupDN:  os.Getenv("LDAP_ADMIN_GROUP_DN"),
+		SyncEmail:     envBool("LDAP_SYNC_EMAIL", true),
+	}
+
+	if cfg.URL == "" || cfg.BaseDN == "" {
+		return nil, errors.New("LDAP_URL and LDAP_BASE_DN are required for the ldap auth backend")
+	}
+	if !strings.Contains(cfg.UserFilter, "%s") {
+		return nil, errors.New("LDAP_USER_FILTER must contain %s")
+	}
+
+	return cfg, nil
+}
+
+// LDAPConn LDAP连接中用到的操作，便于在测试中替换为内存实现
+type LDAPConn interface {
+	Bind(username, password string) error
+	Search(req *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+// ExternalUserProvisioner 按外部身份查找或开通本地账户，由 UserService 实现
+type ExternalUserProvisioner interface {
+	ProvisionExternalUser(identity ExternalIdentity) (*models.User, error)
+}
+
+// LDAPAuthenticator 先用服务账号查找用户DN，再以用户DN和密码绑定验证
+type LDAPAuthenticator struct {
+	config      *LDAPConfig
+	userService ExternalUserProvisioner
+	Dial        func() (LDAPConn, error)
+}
+
+func NewLDAPAuthenticator(cfg *LDAPConfig, userService ExternalUserProvisioner) *LDAPAuthenticator {
+	a := &LDAPAuthenticator{config: cfg, userService: userService}
+	a.Dial = a.dial
+	return a
+}
+
+func (a *LDAPAuthenticator) Name() string {
+	return "ldap"
+}
+
+func (a *LDAPAuthenticator) dial() (LDAPConn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: a.config.SkipTLSVerify}
+
+	conn, err := ldap.DialURL(a.config.URL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+
+	if a.config.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+func (a *LDAPAuthenticator) Authenticate(username, password string) (*models.User, error) {
+	// 空密码在很多目录服务上会被当作匿名绑定而"成功"
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := a.Dial()
+	if err != nil {
+		return nil, fmt.Errorf("LDAP dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	if a.config.BindDN != "" {
+		if err := conn.Bind(a.config.BindDN, a.config.BindPassword); err != nil {
+			return nil, fmt.Errorf("LDAP service bind failed: %v", err)
+		}
+	}
+
+	attrs := []string{"dn", a.config.UsernameAttr, a.config.EmailAttr, a.config.GroupAttr}
+	result, err := conn.Search(ldap.NewSearchRequest(
+		a.config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(a.config.UserFilter, ldap.EscapeFilter(username)),
+		attrs,
+		nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("LDAP search failed: %v", err)
+	}
+
+	if len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("LDAP user bind failed: %v", err)
+	}
+
+	// 平台用户名以目录中的值为准，避免大小写不同产生重复账户
+	localName := entry.GetAttributeValue(a.config.UsernameAttr)
+	if localName == "" {
+		localName = username
+	}
+
+	email := ""
+	if a.config.SyncEmail {
+		email = entry.GetAttributeValue(a.config.EmailAttr)
+	}
+
+	// 只有配置了管理员组时才由目录决定管理员标记，否则保留平台上的设置，
+	// 不会因为与本地账户同名而继承其权限：同名未关联的账户会被拒绝登录
+	var isAdmin *bool
+	if a.config.AdminGroupDN != "" {
+		member := false
+		for _, group := range entry.GetAttributeValues(a.config.GroupAttr) {
+			if strings.EqualFold(group, a.config.AdminGroupDN) {
+				member = true
+				break
+			}
+		}
+		isAdmin = &member
+	}
+
+	return a.userService.ProvisionExternalUser(ExternalIdentity{
+		Provider: a.Name(),
+		Subject:  localName,
+		Username: localName,
+		Email:    email,
+		IsAdmin:  isAdmin,
+	})
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gpu-dev-platform/models"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// fakeDirectory 内存中的目录服务，按 (attr=value) 形式的过滤器匹配条目
+type fakeDirectory struct {
+	serviceDN       string
+	servicePassword string
+	entries         []*ldap.Entry
+	passwords       map[string]string // DN -> 密码
+	dialErr         error
+
+	boundAs string
+	closed  bool
+}
+
+func (d *fakeDirectory) Bind(username, password string) error {
+	if username == d.serviceDN && password == d.servicePassword {
+		d.boundAs = username
+		return nil
+	}
+	if pw, ok := d.passwords[username]; ok && pw == password {
+		d.boundAs = username
+		return nil
+	}
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+}
+
+func (d *fakeDirectory) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	if d.serviceDN != "" && d.boundAs != d.serviceDN {
+		return nil, ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("anonymous search not allowed"))
+	}
+
+	filter := strings.TrimSuffix(strings.TrimPrefix(req.Filter, "("), ")")
+	attr, value, _ := strings.Cut(filter, "=")
+
+	result := &ldap.SearchResult{}
+	for _, entry := range d.entries {
+		if strings.HasSuffix(entry.DN, req.BaseDN) && strings.EqualFold(entry.GetAttributeValue(attr), value) {
+			result.Entries = append(result.Entries, entry)
+		}
+	}
+	return result, nil
+}
+
+func (d *fakeDirectory) Close() error {
+	d.closed = true
+	return nil
+}
+
+// fakeProvisioner 模拟 UserService 的关联规则：已关联的身份直接登录，与未关联的同名账户冲突时拒绝
+type fakeProvisioner struct {
+	links  map[string]*models.User // provider + "/" + subject
+	locals map[string]*models.User // 未关联外部身份的本地账户
+	got    []ExternalIdentity
+}
+
+func (p *fakeProvisioner) ProvisionExternalUser(identity ExternalIdentity) (*models.User, error) {
+	p.got = append(p.got, identity)
+
+	user, ok := p.links[identity.Provider+"/"+identity.Subject]
+	if !ok {
+		if _, taken := p.locals[identity.Username]; taken {
+			return nil, ErrIdentityNotLinked
+		}
+		user = &models.User{ID: 100 + len(p.links), Username: identity.Username, IsActive: true}
+		p.links[identity.Provider+"/"+identity.Subject] = user
+	}
+	if identity.Email != "" {
+		user.Email = identity.Email
+	}
+	if identity.IsAdmin != nil {
+		user.IsAdmin = *identity.IsAdmin
+	}
+	return user, nil
+}
+
+func newTestDirectory() *fakeDirectory {
+	return &fakeDirectory{
+		serviceDN:       "cn=svc,dc=example,dc=com",
+		servicePassword: "svc-secret",
+		entries: []*ldap.Entry{
+			ldap.NewEntry("uid=alice,ou=people,dc=example,dc=com", map[string][]string{
+				"uid":      {"alice"},
+				"mail":     {"alice@example.com"},
+				"memberOf": {"CN=Platform-Admins,ou=groups,dc=example,dc=com", "cn=dev,ou=groups,dc=example,dc=com"},
+			}),
+			ldap.NewEntry("uid=bob,ou=people,dc=example,dc=com", map[string][]string{
+				"uid":      {"bob"},
+				"mail":     {"bob@example.com"},
+				"memberOf": {"cn=dev,ou=groups,dc=example,dc=com"},
+			}),
+			ldap.NewEntry("uid=root,ou=people,dc=example,dc=com", map[string][]string{
+				"uid": {"root"},
+			}),
+			// 两个条目匹配同一用户名时不能确定身份
+			ldap.NewEntry("uid=dup,ou=people,dc=example,dc=com", map[string][]string{"uid": {"dup"}}),
+			ldap.NewEntry("uid=dup,ou=contractors,dc=example,dc=com", map[string][]string{"uid": {"dup"}}),
+		},
+		passwords: map[string]string{
+			"uid=alice,ou=people,dc=example,dc=com": "alice-pw",
+			"uid=bob,ou=people,dc=example,dc=com":   "bob-pw",
+			"uid=root,ou=people,dc=example,dc=com":  "root-pw",
+			"uid=dup,ou=people,dc=example,dc=com":   "dup-pw",
+		},
+	}
+}
+
+func newTestLDAPAuthenticator(dir *fakeDirectory, cfg func(*LDAPConfig)) (*LDAPAuthenticator, *fakeProvisioner) {
+	config := &LDAPConfig{
+		URL:          "ldap://directory.example.com",
+		BindDN:       dir.serviceDN,
+		BindPassword: dir.servicePassword,
+		BaseDN:       "dc=example,dc=com",
+		UserFilter:   "(uid=%s)",
+		UsernameAttr: "uid",
+		EmailAttr:    "mail",
+		GroupAttr:    "memberOf",
+		SyncEmail:    true,
+	}
+	if cfg != nil {
+		cfg(config)
+	}
+
+	provisioner := &fakeProvisioner{
+		links: map[string]*models.User{},
+		// 平台上已有的本地管理员，与目录中的 root 同名
+		locals: map[string]*models.User{"root": {ID: 1, Username: "root", IsAdmin: true, IsActive: true}},
+	}
+	a := NewLDAPAuthenticator(config, provisioner)
+	a.Dial = func() (LDAPConn, error) {
+		if dir.dialErr != nil {
+			return nil, dir.dialErr
+		}
+		return dir, nil
+	}
+	return a, provisioner
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	const adminGroup = "cn=platform-admins,ou=groups,dc=example,dc=com"
+
+	tests := []struct {
+		name      string
+		username  string
+		password  string
+		config    func(*LDAPConfig)
+		wantErr   error // nil 表示成功；errAny 表示任意非凭据错误
+		wantUser  string
+		wantEmail string
+		wantAdmin *bool
+	}{
+		{name: "valid credentials", username: "alice", password: "alice-pw",
+			wantUser: "alice", wantEmail: "alice@example.com"},
+		{name: "username canonicalized from directory", username: "ALICE", password: "alice-pw",
+			wantUser: "alice", wantEmail: "alice@example.com"},
+		{name: "wrong password", username: "alice", password: "nope", wantErr: ErrInvalidCredentials},
+		{name: "empty password is not an anonymous bind", username: "alice", password: "", wantErr: ErrInvalidCredentials},
+		{name: "unknown user", username: "mallory", password: "x", wantErr: ErrInvalidCredentials},
+		{name: "ambiguous user", username: "dup", password: "dup-pw", wantErr: ErrInvalidCredentials},
+		{name: "filter metacharacters are escaped", username: "*", password: "alice-pw", wantErr: ErrInvalidCredentials},
+		{name: "service bind failure", username: "alice", password: "alice-pw",
+			config: func(c *LDAPConfig) { c.BindPassword = "wrong" }, wantErr: errAny},
+		{name: "admin group member", username: "alice", password: "alice-pw",
+			config: func(c *LDAPConfig) { c.AdminGroupDN = adminGroup },
+			wantUser: "alice", wantEmail: "alice@example.com", wantAdmin: boolPtr(true)},
+		{name: "not an admin group member", username: "bob", password: "bob-pw",
+			config: func(c *LDAPConfig) { c.AdminGroupDN = adminGroup },
+			wantUser: "bob", wantEmail: "bob@example.com", wantAdmin: boolPtr(false)},
+		{name: "email sync disabled", username: "bob", password: "bob-pw",
+			config: func(c *LDAPConfig) { c.SyncEmail = false }, wantUser: "bob"},
+		{name: "collides with local-only account", username: "root", password: "root-pw", wantErr: ErrIdentityNotLinked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newTestDirectory()
+			a, provisioner := newTestLDAPAuthenticator(dir, tt.config)
+
+			user, err := a.Authenticate(tt.username, tt.password)
+			switch {
+			case tt.wantErr == errAny:
+				if err == nil || err == ErrInvalidCredentials {
+					t.Fatalf("err = %v, want backend error", err)
+				}
+				return
+			case tt.wantErr != nil:
+				if err != tt.wantErr {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			case err != nil:
+				t.Fatal(err)
+			}
+
+			if user.Username != tt.wantUser {
+				t.Errorf("username = %q, want %q", user.Username, tt.wantUser)
+			}
+			got := provisioner.got[len(provisioner.got)-1]
+			if got.Provider != "ldap" || got.Subject != tt.wantUser {
+				t.Errorf("identity = %s/%s", got.Provider, got.Subject)
+			}
+			if got.Email != tt.wantEmail {
+				t.Errorf("email = %q, want %q", got.Email, tt.wantEmail)
+			}
+			if (got.IsAdmin == nil) != (tt.wantAdmin == nil) || (got.IsAdmin != nil && *got.IsAdmin != *tt.wantAdmin) {
+				t.Errorf("IsAdmin = %v, want %v", got.IsAdmin, tt.wantAdmin)
+			}
+			if !dir.closed {
+				t.Error("connection not closed")
+			}
+		})
+	}
+}
+
+// 与本地管理员同名的目录账户不能继承其管理员权限
+func TestLDAPDoesNotInheritLocalPrivileges(t *testing.T) {
+	a, provisioner := newTestLDAPAuthenticator(newTestDirectory(), nil)
+
+	user, err := a.Authenticate("root", "root-pw")
+	if err != ErrIdentityNotLinked || user != nil {
+		t.Fatalf("Authenticate = %v, %v; want ErrIdentityNotLinked", user, err)
+	}
+
+	// 管理员关联后才能登录，且未配置管理员组时不改变平台上的管理员标记
+	provisioner.links["ldap/root"] = provisioner.locals["root"]
+	user, err = a.Authenticate("root", "root-pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != 1 || provisioner.got[len(provisioner.got)-1].IsAdmin != nil {
+		t.Errorf("linked login = %+v, IsAdmin %v", user, provisioner.got[len(provisioner.got)-1].IsAdmin)
+	}
+}
+
+func TestLDAPDialFailure(t *testing.T) {
+	dir := newTestDirectory()
+	dir.dialErr = errors.New("connection refused")
+	a, _ := newTestLDAPAuthenticator(dir, nil)
+
+	if _, err := a.Authenticate("alice", "alice-pw"); err == nil || err == ErrInvalidCredentials {
+		t.Fatalf("err = %v, want backend error", err)
+	}
+}
+
+var errAny = errors.New("any backend error")
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"database/sql"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gpu-dev-platform/database"
+)
+
+// 登录限流维度
+const (
+	throttleScopeIP   = "ip"
+	throttleScopeUser = "user"
+)
+
+// LoginThrottleConfig 失败次数在窗口期内达到上限后锁定一段时间
+type LoginThrottleConfig struct {
+	MaxFailuresPerUser int
+	MaxFailuresPerIP   int
+	Window             time.Duration
+	Lockout            time.Duration
+}
+
+func LoadLoginThrottleConfig() *LoginThrottleConfig {
+	return &LoginThrottleConfig{
+		MaxFailuresPerUser: envInt("LOGIN_MAX_FAILURES_PER_USER", 5),
+		MaxFailuresPerIP:   envInt("LOGIN_MAX_FAILURES_PER_IP", 20),
+		Window:             parseDurationEnv("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		Lockout:            parseDurationEnv("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+	}
+}
+
+// LoginThrottleService 按IP和用户名统计登录失败，计数存库以便重启后仍然有效
+type LoginThrottleService struct {
+	db     *sql.DB
+	config *LoginThrottleConfig
+}
+
+func NewLoginThrottleService() *LoginThrottleService {
+	return &LoginThrottleService{
+		db:     database.DB,
+		config: LoadLoginThrottleConfig(),
+	}
+}
+
+// Check 返回IP或用户名仍处于锁定状态时的剩余时间，未锁定时返回0
+// 用户名不区分是否存在，避免通过锁定行为判断账户是否存在
+func (s *LoginThrottleService) Check(ip, username string) (time.Duration, error) {
+	var wait time.Duration
+	now := time.Now()
+
+	rows, err := s.db.Query(`
+		SELECT locked_until FROM login_attempts
+		WHERE ((scope = ? AND subject = ?) OR (scope = ? AND subject = ?)) AND locked_until > ?
+	`, throttleScopeIP, ip, throttleScopeUser, normalizeLoginName(username), now)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var lockedUntil time.Time
+		if err := rows.Scan(&lockedUntil); err != nil {
+			return 0, err
+		}
+		if d := lockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait, rows.Err()
+}
+
+// RecordFailure 记录一次失败（密码错误、两步验证失败等）
+func (s *LoginThrottleService) RecordFailure(ip, username string) error {
+	if err := s.recordFailure(throttleScopeIP, ip, s.config.MaxFailuresPerIP); err != nil {
+		return err
+	}
+	return s.recordFailure(throttleScopeUser, normalizeLoginName(username), s.config.MaxFailuresPerUser)
+}
+
+func (s *LoginThrottleService) recordFailure(scope, subject string, max int) error {
+	if subject == "" || max <= 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var failures int
+	var windowStart time.Time
+	err = tx.QueryRow("SELECT failures, window_start FROM login_attempts WHERE scope = ? AND subject = ? FOR UPDATE",
+		scope, subject).Scan(&failures, &windowStart)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	// 窗口期已过则重新计数
+	if err == sql.ErrNoRows || now.Sub(windowStart) > s.config.Window {
+		failures = 0
+		windowStart = now
+	}
+	failures++
+
+	var lockedUntil interface{}
+	if failures >= max {
+		lockedUntil = now.Add(s.config.Lockout)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO login_attempts (scope, subject, failures, window_start, locked_until, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE failures = VALUES(failures), window_start = VALUES(window_start),
+			locked_until = VALUES(locked_until), updated_at = VALUES(updated_at)
+	`, scope, subject, failures, windowStart, lockedUntil, now)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RecordSuccess 登录成功后清除该用户名的失败计数，IP计数按窗口自然过期
+func (s *LoginThrottleService) RecordSuccess(username string) error {
+	_, err := s.db.Exec("DELETE FROM login_attempts WHERE scope = ? AND subject = ?",
+		throttleScopeUser, normalizeLoginName(username))
+	return err
+}
+
+// Unlock 管理员手动解除用户名锁定
+func (s *LoginThrottleService) Unlock(username string) error {
+	return s.RecordSuccess(username)
+}
+
+func normalizeLoginName(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+func envInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gpu-dev-platform/database"
+)
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnrolled    = errors.New("two-factor authentication is not enrolled")
+	ErrInvalidMFACode    = errors.New("invalid two-factor code")
+)
+
+const recoveryCodeCount = 10
+
+var totpIssuer = getEnvWithDefault("TOTP_ISSUER", "AI4S GPU Platform")
+
+type MFAStatus struct {
+	Enabled                bool `json:"enabled"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+// MFAService 管理TOTP两步验证和恢复码
+type MFAService struct {
+	db *sql.DB
+}
+
+func NewMFAService() *MFAService {
+	return &MFAService{db: database.DB}
+}
+
+func (s *MFAService) Status(userID int) (*MFAStatus, error) {
+	status := &MFAStatus{}
+	err := s.db.QueryRow("SELECT enabled FROM user_totp WHERE user_id = ?", userID).Scan(&status.Enabled)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	err = s.db.QueryRow("SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = ? AND used_at IS NULL",
+		userID).Scan(&status.RecoveryCodesRemaining)
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// IsEnabled 用户是否已启用两步验证
+func (s *MFAService) IsEnabled(userID int) (bool, error) {
+	status, err := s.Status(userID)
+	if err != nil {
+		return false, err
+	}
+	return status.Enabled, nil
+}
+
+// BeginEnrollment 生成新密钥（未确认前不生效），返回密钥和otpauth地址
+func (s *MFAService) BeginEnrollment(userID int, username string) (string, string, error) {
+	enabled, err := s.IsEnabled(userID)
+	if err != nil {
+		return "", "", err
+	}
+	if enabled {
+		return "", "", ErrMFAAlreadyEnabled
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO user_totp (user_id, secret, enabled, last_used_step, created_at)
+		VALUES (?, ?, FALSE, 0, ?)
+		ON DUPLICATE KEY UPDATE secret = VALUES(secret), enabled = FALSE, last_used_step = 0, created_at = VALUES(created_at)
+	`, userID, secret, time.Now())
+	if err != nil {
+		return "", "", err
+	}
+
+	return secret, TOTPURI(totpIssuer, username, secret), nil
+}
+
+// ConfirmEnrollment 用第一个验证码确认绑定，启用后生成恢复码
+func (s *MFAService) ConfirmEnrollment(userID int, code string) ([]string, error) {
+	var secret string
+	var enabled bool
+	err := s.db.QueryRow("SELECT secret, enabled FROM user_totp WHERE user_id = ?", userID).Scan(&secret, &enabled)
+	if err == sql.ErrNoRows {
+		return nil, ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	step := ValidateTOTP(secret, code, time.Now())
+	if step < 0 {
+		return nil, ErrInvalidMFACode
+	}
+
+	_, err = s.db.Exec("UPDATE user_totp SET enabled = TRUE, last_used_step = ?, confirmed_at = ? WHERE user_id = ?",
+		step, time.Now(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.RegenerateRecoveryCodes(userID)
+}
+
+// Verify 校验TOTP验证码或恢复码，恢复码和已用过的时间步都只能使用一次
+func (s *MFAService) Verify(userID int, code string) error {
+	return s.verify(userID, code, time.Now())
+}
+
+func (s *MFAService) verify(userID int, code string, now time.Time) error {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return ErrInvalidMFACode
+	}
+
+	var secret string
+	var lastStep int64
+	err := s.db.QueryRow("SELECT secret, last_used_step FROM user_totp WHERE user_id = ? AND enabled = TRUE",
+		userID).Scan(&secret, &lastStep)
+	if err == sql.ErrNoRows {
+		return ErrMFANotEnrolled
+	}
+	if err != nil {
+		return err
+	}
+
+	if step := ValidateTOTP(secret, code, now); step >= 0 {
+		// 条件更新保证同一验证码不能被重放
+		result, err := s.db.Exec("UPDATE user_totp SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?",
+			step, userID, step)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE user_recovery_codes SET used_at = ?
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`, now, userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes 作废旧恢复码并生成新的一组，明文只返回这一次
+func (s *MFAService) RegenerateRecoveryCodes(userID int) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw, err := randomToken(5)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = fmt.Sprintf("%s-%s", raw[:5], raw[5:])
+		if _, err := tx.Exec("INSERT INTO user_recovery_codes (user_id, code_hash, created_at) VALUES (?, ?, ?)",
+			userID, hashToken(normalizeRecoveryCode(codes[i])), time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable 关闭两步验证并删除恢复码
+func (s *MFAService) Disable(userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM user_totp WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTOTPDB 只支持 Verify 用到的语句：一个已启用两步验证的用户，没有恢复码
+type fakeTOTPDB struct {
+	mu       sync.Mutex
+	secret   string
+	lastStep int64
+}
+
+func (d *fakeTOTPDB) Open(string) (driver.Conn, error) { return d, nil }
+func (d *fakeTOTPDB) Prepare(query string) (driver.Stmt, error) {
+	return &fakeTOTPStmt{db: d, query: query}, nil
+}
+func (d *fakeTOTPDB) Close() error              { return nil }
+func (d *fakeTOTPDB) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type fakeTOTPStmt struct {
+	db    *fakeTOTPDB
+	query string
+}
+
+func (s *fakeTOTPStmt) Close() error  { return nil }
+func (s *fakeTOTPStmt) NumInput() int { return -1 }
+
+// Exec 按 last_used_step < ? 的条件更新，与数据库的防重放行为一致；恢复码一律不匹配
+func (s *fakeTOTPStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if !strings.Contains(s.query, "UPDATE user_totp SET last_used_step") {
+		return driver.RowsAffected(0), nil
+	}
+	step := args[0].(int64)
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.db.lastStep >= step {
+		return driver.RowsAffected(0), nil
+	}
+	s.db.lastStep = step
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeTOTPStmt) Query([]driver.Value) (driver.Rows, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	return &fakeTOTPRows{secret: s.db.secret, lastStep: s.db.lastStep}, nil
+}
+
+type fakeTOTPRows struct {
+	secret   string
+	lastStep int64
+	done     bool
+}
+
+func (r *fakeTOTPRows) Columns() []string { return []string{"secret", "last_used_step"} }
+func (r *fakeTOTPRows) Close() error      { return nil }
+func (r *fakeTOTPRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0], dest[1] = r.secret, r.lastStep
+	return nil
+}
+
+func newTestMFAService(t *testing.T, secret string, lastStep int64) *MFAService {
+	t.Helper()
+	name := "faketotp-" + t.Name()
+	sql.Register(name, &fakeTOTPDB{secret: secret, lastStep: lastStep})
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &MFAService{db: db}
+}
+
+func TestMFAServiceVerify(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name     string
+		lastStep int64
+		codeStep int64
+		wantErr  error
+	}{
+		{name: "current step", codeStep: step},
+		{name: "previous step within skew", codeStep: step - 1},
+		{name: "next step within skew", codeStep: step + 1},
+		{name: "outside skew", codeStep: step - 2, wantErr: ErrInvalidMFACode},
+		{name: "reused step", lastStep: step, codeStep: step, wantErr: ErrInvalidMFACode},
+		{name: "step older than the last used", lastStep: step, codeStep: step - 1, wantErr: ErrInvalidMFACode},
+		{name: "step after the last used", lastStep: step - 1, codeStep: step},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestMFAService(t, rfc6238Secret, tt.lastStep)
+			code, err := totpCode(rfc6238Secret, tt.codeStep)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.verify(1, code, now); err != tt.wantErr {
+				t.Errorf("verify = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMFAServiceVerifyRejectsReplay(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	s := newTestMFAService(t, rfc6238Secret, 0)
+
+	if err := s.verify(1, "050471", now); err != nil {
+		t.Fatalf("first use = %v, want nil", err)
+	}
+	// 同一验证码在偏差窗口内仍然有效，但时间步已用过
+	if err := s.verify(1, "050471", now.Add(totpPeriod*time.Second)); err != ErrInvalidMFACode {
+		t.Errorf("replay = %v, want %v", err, ErrInvalidMFACode)
+	}
+}
+
+func TestMFAServiceVerifyEmptyCode(t *testing.T) {
+	s := newTestMFAService(t, rfc6238Secret, 0)
+	if err := s.verify(1, "  ", time.Unix(1111111111, 0)); err != ErrInvalidMFACode {
+		t.Errorf("verify empty code = %v, want %v", err, ErrInvalidMFACode)
+	}
+}
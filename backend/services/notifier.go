@@ -0,0 +1,80 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// 通知事件类型
+const (
+	NotifyAccountExpiring      = "account.expiring"
+	NotifyAccountExpired       = "account.expired"
+	NotifyAccountDeprovisioned = "account.deprovisioned"
+	NotifyGPULeaseStarting     = "gpu_lease.starting"
+	NotifyGPULeaseStarted      = "gpu_lease.started"
+	NotifyGPULeaseEnding       = "gpu_lease.ending"
+	NotifyGPULeaseEnded        = "gpu_lease.ended"
+	NotifyGPULeaseFailed       = "gpu_lease.failed"
+)
+
+// Notification 发给外部系统（邮件网关、IM 机器人等）的通知
+type Notification struct {
+	Event    string                 `json:"event"`
+	UserID   int                    `json:"user_id"`
+	Username string                 `json:"username"`
+	Email    string                 `json:"email,omitempty"`
+	Message  string                 `json:"message"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+	Time     time.Time              `json:"time"`
+}
+
+// Notifier 通知钩子，平台本身不发邮件，由外部系统负责投递
+type Notifier interface {
+	Notify(n *Notification) error
+}
+
+// NewNotifier 配置了 NOTIFY_WEBHOOK_URL 时以 JSON POST 到该地址，否则只写日志
+func NewNotifier() Notifier {
+	if url := os.Getenv("NOTIFY_WEBHOOK_URL"); url != "" {
+		return &webhookNotifier{
+			url:    url,
+			client: &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+	return logNotifier{}
+}
+
+type logNotifier struct{}
+
+func (logNotifier) Notify(n *Notification) error {
+	log.Printf("notification %s for %s: %s", n.Event, n.Username, n.Message)
+	return nil
+}
+
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (w *webhookNotifier) Notify(n *Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,344 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OIDCConfig OpenID Connect 单点登录配置，OIDC_ISSUER 为空时不启用
+type OIDCConfig struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string
+	Scopes        []string
+	UsernameClaim string
+	GroupsClaim   string
+	AdminGroup    string
+	PostLoginURL  string
+}
+
+func LoadOIDCConfig() *OIDCConfig {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil
+	}
+
+	return &OIDCConfig{
+		Issuer:        strings.TrimSuffix(issuer, "/"),
+		ClientID:      os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:   os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:        strings.Fields(getEnvWithDefault("OIDC_SCOPES", "openid profile email groups")),
+		UsernameClaim: getEnvWithDefault("OIDC_USERNAME_CLAIM", "preferred_username"),
+		GroupsClaim:   getEnvWithDefault("OIDC_GROUPS_CLAIM", "groups"),
+		AdminGroup:    os.Getenv("OIDC_ADMIN_GROUP"),
+		PostLoginURL:  getEnvWithDefault("OIDC_POST_LOGIN_URL", "/admin-login"),
+	}
+}
+
+// OIDCIdentity 从ID token中提取的用户信息
+type OIDCIdentity struct {
+	Subject  string
+	Username string
+	Email    string
+	Groups   []string
+	IsAdmin  *bool // 未配置 OIDC_ADMIN_GROUP 时为nil，不改变平台上的管理员标记
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider 封装授权码流程：发现文档、换取token、验证ID token
+type OIDCProvider struct {
+	Config     *OIDCConfig
+	HTTPClient *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]interface{}
+
+	// 未知kid重新拉取JWKS的最小间隔，防止伪造kid的token让每次请求都访问IdP
+	keyRefreshInterval time.Duration
+	keysFetchedAt      time.Time
+}
+
+func NewOIDCProvider(cfg *OIDCConfig) *OIDCProvider {
+	return &OIDCProvider{
+		Config:             cfg,
+		HTTPClient:         &http.Client{Timeout: 10 * time.Second},
+		keyRefreshInterval: time.Minute,
+	}
+}
+
+// discover 首次使用时拉取发现文档，IdP暂时不可用不影响平台启动
+func (p *OIDCProvider) discover() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var doc oidcDiscovery
+	if err := p.getJSON(p.Config.Issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %v", err)
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != p.Config.Issuer {
+		return nil, fmt.Errorf("OIDC issuer mismatch: %s", doc.Issuer)
+	}
+
+	p.discovery = &doc
+	return p.discovery, nil
+}
+
+// AuthCodeURL 生成跳转到IdP的授权地址，使用PKCE (S256)
+func (p *OIDCProvider) AuthCodeURL(state, nonce, codeVerifier string) (string, error) {
+	doc, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.Config.ClientID},
+		"redirect_uri":          {p.Config.RedirectURL},
+		"scope":                 {strings.Join(p.Config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	return doc.AuthorizationEndpoint + "?" + params.Encode(), nil
+}
+
+// Exchange 用授权码换取并验证ID token
+func (p *OIDCProvider) Exchange(code, nonce, codeVerifier string) (*OIDCIdentity, error) {
+	doc, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.Config.RedirectURL},
+		"client_id":     {p.Config.ClientID},
+		"client_secret": {p.Config.ClientSecret},
+		"code_verifier": {codeVerifier},
+	}
+
+	resp, err := p.HTTPClient.PostForm(doc.TokenEndpoint, form)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OIDC token endpoint returned %s", resp.Status)
+	}
+
+	var tokenResp struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return nil, fmt.Errorf("invalid OIDC token response: %v", err)
+	}
+	if tokenResp.IDToken == "" {
+		return nil, fmt.Errorf("OIDC token response has no id_token")
+	}
+
+	return p.VerifyIDToken(tokenResp.IDToken, nonce)
+}
+
+// VerifyIDToken 校验签名、issuer、audience、有效期和nonce，并提取身份
+func (p *OIDCProvider) VerifyIDToken(idToken, nonce string) (*OIDCIdentity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, p.keyfunc,
+		jwt.WithIssuer(p.Config.Issuer),
+		jwt.WithAudience(p.Config.ClientID),
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %v", err)
+	}
+
+	if exp, _ := claims.GetExpirationTime(); exp == nil {
+		return nil, fmt.Errorf("ID token has no exp claim")
+	}
+
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, fmt.Errorf("ID token nonce mismatch")
+	}
+
+	identity := &OIDCIdentity{}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Username, _ = claims[p.Config.UsernameClaim].(string)
+	identity.Email, _ = claims["email"].(string)
+
+	switch groups := claims[p.Config.GroupsClaim].(type) {
+	case []interface{}:
+		for _, g := range groups {
+			if name, ok := g.(string); ok {
+				identity.Groups = append(identity.Groups, name)
+			}
+		}
+	case string:
+		identity.Groups = strings.Fields(groups)
+	}
+
+	if p.Config.AdminGroup != "" {
+		isAdmin := false
+		for _, g := range identity.Groups {
+			if g == p.Config.AdminGroup {
+				isAdmin = true
+				break
+			}
+		}
+		identity.IsAdmin = &isAdmin
+	}
+
+	// 账户按 (issuer, sub) 关联，缺少sub的token无法确定身份
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("ID token has no sub claim")
+	}
+	if identity.Username == "" {
+		return nil, fmt.Errorf("ID token has no %s claim", p.Config.UsernameClaim)
+	}
+
+	return identity, nil
+}
+
+// keyfunc 按kid选择IdP公钥，遇到未知kid时重新拉取JWKS以支持IdP轮换密钥。
+// 距上次拉取不足 keyRefreshInterval 时只在已缓存的密钥中查找
+func (p *OIDCProvider) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	refresh := !ok && (p.keys == nil || time.Since(p.keysFetchedAt) >= p.keyRefreshInterval)
+	if refresh {
+		p.keysFetchedAt = time.Now()
+	}
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	if refresh {
+		if err := p.refreshKeys(); err != nil {
+			return nil, err
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	// IdP只有一把密钥且未设置kid
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown ID token kid %q", kid)
+}
+
+func (p *OIDCProvider) refreshKeys() error {
+	doc, err := p.discover()
+	if err != nil {
+		return err
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(doc.JWKSURI, &jwks); err != nil {
+		return fmt.Errorf("failed to fetch OIDC JWKS: %v", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, k := range jwks.Keys {
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		case "OKP":
+			x, err := base64.RawURLEncoding.DecodeString(k.X)
+			if err != nil || k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+				continue
+			}
+			keys[k.Kid] = ed25519.PublicKey(x)
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *OIDCProvider) getJSON(u string, v interface{}) error {
+	resp, err := p.HTTPClient.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,358 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockIdP 提供发现文档、token和JWKS端点的模拟身份提供方
+type mockIdP struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	kid      string
+	claims   jwt.MapClaims // token端点签发的ID token内容
+	verifier string        // token端点要求的PKCE code_verifier
+
+	jwksFetches atomic.Int32
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{key: key, kid: "key-1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("code") != "good-code" ||
+			r.PostFormValue("code_verifier") != idp.verifier {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.sign(t, idp.claims)})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.jwksFetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": idp.kid,
+				"n":   base64.RawURLEncoding.EncodeToString(idp.key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.PublicKey.E)).Bytes()),
+			}},
+		})
+	})
+
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *mockIdP) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = idp.kid
+	signed, err := token.SignedString(idp.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func (idp *mockIdP) provider(adminGroup string) *OIDCProvider {
+	return NewOIDCProvider(&OIDCConfig{
+		Issuer:        idp.server.URL,
+		ClientID:      "platform",
+		ClientSecret:  "secret",
+		RedirectURL:   "https://platform.example.com/api/oidc/callback",
+		Scopes:        []string{"openid", "profile"},
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+		AdminGroup:    adminGroup,
+	})
+}
+
+func (idp *mockIdP) validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":                idp.server.URL,
+		"aud":                "platform",
+		"sub":                "0f6c5a1e-user",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"iat":                time.Now().Unix(),
+		"nonce":              "nonce-1",
+		"preferred_username": "alice",
+		"email":              "alice@example.com",
+		"groups":             []string{"dev", "platform-admins"},
+	}
+}
+
+func TestOIDCAuthCodeURL(t *testing.T) {
+	idp := newMockIdP(t)
+
+	authURL, err := idp.provider("").AuthCodeURL("state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Scheme + "://" + u.Host + u.Path; got != idp.server.URL+"/authorize" {
+		t.Errorf("authorization endpoint = %s", got)
+	}
+
+	sum := sha256.Sum256([]byte("verifier-1"))
+	q := u.Query()
+	if q.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(sum[:]) || q.Get("code_challenge_method") != "S256" {
+		t.Errorf("PKCE challenge = %s (%s)", q.Get("code_challenge"), q.Get("code_challenge_method"))
+	}
+	if q.Get("state") != "state-1" || q.Get("nonce") != "nonce-1" || q.Get("client_id") != "platform" {
+		t.Errorf("unexpected query %v", q)
+	}
+}
+
+func TestOIDCExchange(t *testing.T) {
+	idp := newMockIdP(t)
+
+	tests := []struct {
+		name       string
+		adminGroup string
+		code       string
+		nonce      string
+		modify     func(jwt.MapClaims)
+		wantErr    string
+		wantAdmin  *bool
+	}{
+		{name: "admin group member", adminGroup: "platform-admins", code: "good-code", nonce: "nonce-1", wantAdmin: boolPtr(true)},
+		{name: "not in admin group", adminGroup: "ops", code: "good-code", nonce: "nonce-1", wantAdmin: boolPtr(false)},
+		{name: "admin group not configured", code: "good-code", nonce: "nonce-1"},
+		{name: "space separated groups", adminGroup: "platform-admins", code: "good-code", nonce: "nonce-1",
+			modify: func(c jwt.MapClaims) { c["groups"] = "dev platform-admins" }, wantAdmin: boolPtr(true)},
+		{name: "bad code", code: "bad-code", nonce: "nonce-1", wantErr: "token endpoint"},
+		{name: "nonce mismatch", code: "good-code", nonce: "other", wantErr: "nonce"},
+		{name: "wrong audience", code: "good-code", nonce: "nonce-1",
+			modify: func(c jwt.MapClaims) { c["aud"] = "someone-else" }, wantErr: "invalid ID token"},
+		{name: "wrong issuer", code: "good-code", nonce: "nonce-1",
+			modify: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, wantErr: "invalid ID token"},
+		{name: "expired", code: "good-code", nonce: "nonce-1",
+			modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, wantErr: "invalid ID token"},
+		{name: "missing exp", code: "good-code", nonce: "nonce-1",
+			modify: func(c jwt.MapClaims) { delete(c, "exp") }, wantErr: "exp"},
+		{name: "missing sub", code: "good-code", nonce: "nonce-1",
+			modify: func(c jwt.MapClaims) { delete(c, "sub") }, wantErr: "sub"},
+		{name: "missing username", code: "good-code", nonce: "nonce-1",
+			modify: func(c jwt.MapClaims) { delete(c, "preferred_username") }, wantErr: "preferred_username"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp.claims = idp.validClaims()
+			if tt.modify != nil {
+				tt.modify(idp.claims)
+			}
+			idp.verifier = "verifier-1"
+
+			identity, err := idp.provider(tt.adminGroup).Exchange(tt.code, tt.nonce, "verifier-1")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if identity.Subject != "0f6c5a1e-user" || identity.Username != "alice" || identity.Email != "alice@example.com" {
+				t.Errorf("identity = %+v", identity)
+			}
+			if (identity.IsAdmin == nil) != (tt.wantAdmin == nil) ||
+				(identity.IsAdmin != nil && *identity.IsAdmin != *tt.wantAdmin) {
+				t.Errorf("IsAdmin = %v, want %v", identity.IsAdmin, tt.wantAdmin)
+			}
+		})
+	}
+}
+
+func TestOIDCExchangeRejectsWrongVerifier(t *testing.T) {
+	idp := newMockIdP(t)
+	idp.claims = idp.validClaims()
+	idp.verifier = "verifier-1"
+
+	if _, err := idp.provider("").Exchange("good-code", "nonce-1", "stolen"); err == nil {
+		t.Fatal("expected PKCE verifier mismatch to fail")
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider("")
+
+	idp.claims = idp.validClaims()
+	idp.verifier = "verifier-1"
+	if _, err := p.Exchange("good-code", "nonce-1", "verifier-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// IdP轮换密钥后，未知kid应触发重新拉取JWKS
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.key, idp.kid = key, "key-2"
+
+	// 距上次拉取不足最小间隔时不重新拉取，新密钥暂时无法验证
+	if _, err := p.Exchange("good-code", "nonce-1", "verifier-1"); err == nil {
+		t.Fatal("token with a new kid accepted before the refresh interval")
+	}
+	if n := idp.jwksFetches.Load(); n != 1 {
+		t.Fatalf("JWKS fetched %d times within the refresh interval, want 1", n)
+	}
+
+	p.keysFetchedAt = p.keysFetchedAt.Add(-p.keyRefreshInterval)
+	if _, err := p.Exchange("good-code", "nonce-1", "verifier-1"); err != nil {
+		t.Fatalf("after rotation: %v", err)
+	}
+	if n := idp.jwksFetches.Load(); n != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", n)
+	}
+}
+
+func TestOIDCUnknownKidRefetchIsRateLimited(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider("")
+
+	for i := 0; i < 5; i++ {
+		token := &jwt.Token{Header: map[string]interface{}{"kid": fmt.Sprintf("forged-%d", i)}}
+		if _, err := p.keyfunc(token); err == nil {
+			t.Fatalf("forged kid %d accepted", i)
+		}
+	}
+	if n := idp.jwksFetches.Load(); n != 1 {
+		t.Errorf("JWKS fetched %d times for forged kids, want 1", n)
+	}
+
+	// 已缓存的密钥不受影响
+	if _, err := p.keyfunc(&jwt.Token{Header: map[string]interface{}{"kid": "key-1"}}); err != nil {
+		t.Errorf("cached kid rejected: %v", err)
+	}
+
+	p.keysFetchedAt = p.keysFetchedAt.Add(-p.keyRefreshInterval)
+	if _, err := p.keyfunc(&jwt.Token{Header: map[string]interface{}{"kid": "forged-5"}}); err == nil {
+		t.Fatal("forged kid accepted after refresh")
+	}
+	if n := idp.jwksFetches.Load(); n != 2 {
+		t.Errorf("JWKS fetched %d times after the refresh interval, want 2", n)
+	}
+}
+
+// emptyDB 所有查询都没有结果、写入一律失败的数据库，记录收到的写入语句
+type emptyDB struct{ execs []string }
+
+func (d *emptyDB) Open(string) (driver.Conn, error) { return d, nil }
+func (d *emptyDB) Prepare(query string) (driver.Stmt, error) {
+	return &emptyStmt{db: d, query: query}, nil
+}
+func (d *emptyDB) Close() error              { return nil }
+func (d *emptyDB) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type emptyStmt struct {
+	db    *emptyDB
+	query string
+}
+
+func (s *emptyStmt) Close() error  { return nil }
+func (s *emptyStmt) NumInput() int { return -1 }
+func (s *emptyStmt) Exec([]driver.Value) (driver.Result, error) {
+	s.db.execs = append(s.db.execs, s.query)
+	return nil, errors.New("not supported")
+}
+func (s *emptyStmt) Query([]driver.Value) (driver.Rows, error) { return emptyRows{}, nil }
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string         { return []string{"id"} }
+func (emptyRows) Close() error              { return nil }
+func (emptyRows) Next([]driver.Value) error { return io.EOF }
+
+func TestOIDCInvalidUsernameIsNotProvisioned(t *testing.T) {
+	idp := newMockIdP(t)
+	fake := &emptyDB{}
+	sql.Register("emptydb-"+t.Name(), fake)
+	db, err := sql.Open("emptydb-"+t.Name(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	users := &UserService{db: db}
+
+	for _, username := range []string{"a/../b", "../../etc", "alice@corp", ".hidden", "-rf", "with space",
+		strings.Repeat("a", 51)} {
+		t.Run(username, func(t *testing.T) {
+			idp.claims = idp.validClaims()
+			idp.claims["preferred_username"] = username
+			idp.verifier = "verifier-1"
+
+			identity, err := idp.provider("").Exchange("good-code", "nonce-1", "verifier-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = users.ProvisionExternalUser(ExternalIdentity{
+				Provider: OIDCIdentityProvider(idp.server.URL),
+				Subject:  identity.Subject,
+				Username: identity.Username,
+			})
+			if err != ErrInvalidExternalUsername {
+				t.Fatalf("ProvisionExternalUser(%q) = %v, want ErrInvalidExternalUsername", username, err)
+			}
+			if len(fake.execs) > 0 {
+				t.Fatalf("unexpected writes: %v", fake.execs)
+			}
+		})
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider("")
+	p.Config.Issuer = idp.server.URL + "/other"
+
+	if _, err := p.AuthCodeURL("s", "n", "v"); err == nil {
+		t.Fatal("expected discovery to fail")
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
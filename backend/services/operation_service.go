@@ -0,0 +1,147 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"gpu-dev-platform/database"
+	"gpu-dev-platform/models"
+)
+
+// OperationService 记录后台操作及各步骤结果，供调用方轮询
+type OperationService struct {
+	db *sql.DB
+}
+
+func NewOperationService() *OperationService {
+	return &OperationService{db: database.DB}
+}
+
+// Create 以 running 状态写入新操作
+func (s *OperationService) Create(op *models.Operation) error {
+	op.Status = models.OperationRunning
+	op.CreatedAt = time.Now()
+	if op.Steps == nil {
+		op.Steps = []*models.OperationStep{}
+	}
+
+	result, err := s.db.Exec(`
+		INSERT INTO operations (type, target_type, target_id, target_name, status, steps, created_by, created_by_name, created_at)
+		VALUES (?, ?, ?, ?, ?, '[]', ?, ?, ?)
+	`, op.Type, op.TargetType, op.TargetID, op.TargetName, op.Status, op.CreatedBy, op.CreatedByName, op.CreatedAt)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	op.ID = int(id)
+	return nil
+}
+
+// Finish 根据步骤结果确定最终状态并保存：全部成功为 completed，
+// 有失败但也有成功步骤为 partial，否则为 failed
+func (s *OperationService) Finish(op *models.Operation) {
+	succeeded, failed := 0, 0
+	for _, step := range op.Steps {
+		switch step.Status {
+		case models.StepSucceeded:
+			succeeded++
+		case models.StepFailed:
+			failed++
+		}
+	}
+	switch {
+	case failed == 0:
+		op.Status = models.OperationCompleted
+	case succeeded > 0:
+		op.Status = models.OperationPartial
+	default:
+		op.Status = models.OperationFailed
+	}
+
+	now := time.Now()
+	op.FinishedAt = &now
+	s.Save(op)
+}
+
+// Save 写回当前状态和步骤，失败只记录日志
+func (s *OperationService) Save(op *models.Operation) {
+	steps, err := json.Marshal(op.Steps)
+	if err != nil {
+		log.Printf("failed to encode operation %d steps: %v", op.ID, err)
+		return
+	}
+	_, err = s.db.Exec("UPDATE operations SET status = ?, steps = ?, finished_at = ? WHERE id = ?",
+		op.Status, string(steps), op.FinishedAt, op.ID)
+	if err != nil {
+		log.Printf("failed to save operation %d: %v", op.ID, err)
+	}
+}
+
+func (s *OperationService) Get(id int) (*models.Operation, error) {
+	rows, err := s.db.Query(operationSelect+" WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, sql.ErrNoRows
+	}
+	return scanOperation(rows)
+}
+
+// List 按时间倒序返回最近的操作，targetType/targetID 为空时不过滤
+func (s *OperationService) List(targetType, targetID string, limit int) ([]*models.Operation, error) {
+	query := operationSelect + " WHERE (? = '' OR target_type = ?) AND (? = '' OR target_id = ?) ORDER BY id DESC LIMIT ?"
+	rows, err := s.db.Query(query, targetType, targetType, targetID, targetID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ops := []*models.Operation{}
+	for rows.Next() {
+		op, err := scanOperation(rows)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	return ops, rows.Err()
+}
+
+// MarkInterrupted 服务重启时把仍在执行的操作标记为失败
+func (s *OperationService) MarkInterrupted() error {
+	_, err := s.db.Exec("UPDATE operations SET status = ?, finished_at = ? WHERE status = ?",
+		models.OperationFailed, time.Now(), models.OperationRunning)
+	return err
+}
+
+const operationSelect = `
+	SELECT id, type, target_type, target_id, target_name, status, COALESCE(steps, '[]'),
+	       created_by, created_by_name, created_at, finished_at
+	FROM operations`
+
+func scanOperation(rows *sql.Rows) (*models.Operation, error) {
+	op := &models.Operation{}
+	var steps string
+	var finishedAt sql.NullTime
+	err := rows.Scan(&op.ID, &op.Type, &op.TargetType, &op.TargetID, &op.TargetName, &op.Status, &steps,
+		&op.CreatedBy, &op.CreatedByName, &op.CreatedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+	if finishedAt.Valid {
+		op.FinishedAt = &finishedAt.Time
+	}
+	json.Unmarshal([]byte(steps), &op.Steps)
+	return op, nil
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// PasswordPolicy 账户密码和容器服务密码共用的强度要求
+type PasswordPolicy struct {
+	MinLength      int
+	MinCharClasses int // 大写、小写、数字、符号四类中至少包含几类
+	WordlistFile   string
+
+	// 泄露密码字典，统一小写
+	wordlist map[string]struct{}
+}
+
+// PasswordPolicyError 密码不满足策略，Error() 可直接返回给用户
+type PasswordPolicyError struct {
+	Reason string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return e.Reason
+}
+
+var (
+	defaultPasswordPolicy     *PasswordPolicy
+	defaultPasswordPolicyOnce sync.Once
+)
+
+// DefaultPasswordPolicy 返回按环境变量加载的密码策略，字典文件只加载一次
+func DefaultPasswordPolicy() *PasswordPolicy {
+	defaultPasswordPolicyOnce.Do(func() {
+		defaultPasswordPolicy = LoadPasswordPolicy()
+	})
+	return defaultPasswordPolicy
+}
+
+func LoadPasswordPolicy() *PasswordPolicy {
+	policy := &PasswordPolicy{
+		MinLength:      envInt("PASSWORD_MIN_LENGTH", 8),
+		MinCharClasses: envInt("PASSWORD_MIN_CHAR_CLASSES", 2),
+		WordlistFile:   os.Getenv("PASSWORD_WORDLIST_FILE"),
+	}
+
+	if policy.WordlistFile != "" {
+		wordlist, err := loadWordlist(policy.WordlistFile)
+		if err != nil {
+			// 字典缺失不应阻止服务启动，但要明确提示
+			log.Printf("WARNING: failed to load password wordlist %s: %v", policy.WordlistFile, err)
+		} else {
+			policy.wordlist = wordlist
+			log.Printf("Loaded %d entries from password wordlist %s", len(wordlist), policy.WordlistFile)
+		}
+	}
+
+	return policy
+}
+
+// Validate 检查密码是否满足策略，username 非空时不允许密码包含用户名
+func (p *PasswordPolicy) Validate(password, username string) error {
+	if len([]rune(password)) < p.MinLength {
+		return &PasswordPolicyError{Reason: fmt.Sprintf("密码长度至少%d位", p.MinLength)}
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, present := range []bool{upper, lower, digit, symbol} {
+		if present {
+			classes++
+		}
+	}
+	if classes < p.MinCharClasses {
+		return &PasswordPolicyError{Reason: fmt.Sprintf("密码需至少包含大写字母、小写字母、数字、符号中的%d类", p.MinCharClasses)}
+	}
+
+	lowered := strings.ToLower(password)
+	if username != "" && strings.Contains(lowered, strings.ToLower(username)) {
+		return &PasswordPolicyError{Reason: "密码不能包含用户名"}
+	}
+
+	if _, found := p.wordlist[lowered]; found {
+		return &PasswordPolicyError{Reason: "该密码出现在已泄露密码列表中，请更换"}
+	}
+
+	return nil
+}
+
+// 生成密码使用的字符集，去掉了 0/O、1/l/I 等容易看错的字符
+const generatedPasswordAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz23456789"
+
+// Generate 生成满足当前策略的随机密码，用于批量开通等需要下发初始凭据的场景
+func (p *PasswordPolicy) Generate(username string) (string, error) {
+	length := p.MinLength
+	if length < 16 {
+		length = 16
+	}
+	alphabet := generatedPasswordAlphabet
+	if p.MinCharClasses >= 4 {
+		alphabet += "@#%+=-_"
+	}
+	max := big.NewInt(int64(len(alphabet)))
+
+	for attempt := 0; attempt < 100; attempt++ {
+		buf := make([]byte, length)
+		for i := range buf {
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return "", err
+			}
+			buf[i] = alphabet[n.Int64()]
+		}
+		password := string(buf)
+		if p.Validate(password, username) == nil {
+			return password, nil
+		}
+	}
+	return "", fmt.Errorf("无法生成满足密码策略的密码")
+}
+
+// loadWordlist 读取每行一个密码的字典文件，忽略空行和 # 开头的注释
+func loadWordlist(path string) (map[string]struct{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	wordlist := make(map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		wordlist[strings.ToLower(line)] = struct{}{}
+	}
+	return wordlist, scanner.Err()
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := &PasswordPolicy{
+		MinLength:      8,
+		MinCharClasses: 2,
+		wordlist:       map[string]struct{}{"password1": {}},
+	}
+
+	tests := []struct {
+		name     string
+		policy   *PasswordPolicy
+		password string
+		username string
+		wantErr  string // 错误信息中应包含的内容，空表示通过
+	}{
+		{name: "valid", password: "correct-horse", username: "alice"},
+		{name: "too short", password: "Ab1!", wantErr: "长度"},
+		{name: "length counts runes", password: "密码密码密码密1", username: "alice"},
+		{name: "single class", password: "abcdefghij", wantErr: "2类"},
+		{name: "contains username", password: "alice-2024", username: "alice", wantErr: "用户名"},
+		{name: "username check is case-insensitive", password: "xxALICE99", username: "alice", wantErr: "用户名"},
+		{name: "empty username skips the check", password: "alice-2024", username: ""},
+		{name: "leaked password", password: "Password1", username: "bob", wantErr: "泄露"},
+		{name: "four classes required", policy: &PasswordPolicy{MinLength: 8, MinCharClasses: 4},
+			password: "Abcdefg1", wantErr: "4类"},
+		{name: "four classes present", policy: &PasswordPolicy{MinLength: 8, MinCharClasses: 4},
+			password: "Abcdef1!"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.policy
+			if p == nil {
+				p = policy
+			}
+			err := p.Validate(tt.password, tt.username)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate(%q) = %v, want nil", tt.password, err)
+				}
+				return
+			}
+
+			var policyErr *PasswordPolicyError
+			if !errors.As(err, &policyErr) {
+				t.Fatalf("Validate(%q) = %v, want PasswordPolicyError", tt.password, err)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate(%q) = %q, want it to mention %q", tt.password, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyGenerate(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    *PasswordPolicy
+		username  string
+		minLength int
+	}{
+		{name: "default policy", policy: &PasswordPolicy{MinLength: 8, MinCharClasses: 2}, username: "alice", minLength: 16},
+		{name: "long minimum", policy: &PasswordPolicy{MinLength: 24, MinCharClasses: 2}, username: "alice", minLength: 24},
+		{name: "all four classes", policy: &PasswordPolicy{MinLength: 8, MinCharClasses: 4}, username: "alice", minLength: 16},
+		{name: "short username", policy: &PasswordPolicy{MinLength: 8, MinCharClasses: 3}, username: "a", minLength: 16},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen := map[string]bool{}
+			for i := 0; i < 20; i++ {
+				password, err := tt.policy.Generate(tt.username)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(password) < tt.minLength {
+					t.Errorf("len(%q) = %d, want at least %d", password, len(password), tt.minLength)
+				}
+				if err := tt.policy.Validate(password, tt.username); err != nil {
+					t.Errorf("generated %q fails its own policy: %v", password, err)
+				}
+				if strings.ContainsAny(password, "0O1lI") {
+					t.Errorf("generated %q contains ambiguous characters", password)
+				}
+				if seen[password] {
+					t.Errorf("generated %q twice", password)
+				}
+				seen[password] = true
+			}
+		})
+	}
+}
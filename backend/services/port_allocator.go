@@ -0,0 +1,284 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"gpu-dev-platform/database"
+	"gpu-dev-platform/models"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+var (
+	ErrPortRangeExhausted = errors.New("端口范围已用尽")
+	ErrPortConflict       = errors.New("端口段已被占用")
+	ErrPortOutOfRange     = errors.New("端口段超出分配范围")
+	ErrUserHasContainer   = errors.New("用户已有容器，端口映射在创建容器时确定，请先删除容器再重新分配端口")
+)
+
+// PortAllocator 在 PORT_RANGE_START-PORT_RANGE_END 范围内按 PORT_STEP 为用户分配端口段。
+// 分配在事务中锁定 users 表的端口记录，已释放的端口段会被重新使用，
+// 并跳过已被主机上其他容器（可选：其他进程）占用的端口
+type PortAllocator struct {
+	db     *sql.DB
+	docker *client.Client
+
+	start       int
+	end         int
+	step        int
+	probeListen bool
+}
+
+var (
+	portAllocatorOnce sync.Once
+	portAllocator     *PortAllocator
+)
+
+// defaultPortAllocator 所有 UserService 共用一个分配器，配置只在首次使用时读取
+func defaultPortAllocator() *PortAllocator {
+	portAllocatorOnce.Do(func() {
+		portAllocator = newPortAllocator()
+	})
+	return portAllocator
+}
+
+func newPortAllocator() *PortAllocator {
+	start := envInt("PORT_RANGE_START", envInt("DEFAULT_PORT_PREFIX", 9000))
+	end := envInt("PORT_RANGE_END", 19999)
+	step := envInt("PORT_STEP", models.UserPortCount)
+
+	if step < models.UserPortCount {
+		log.Printf("WARNING: PORT_STEP %d is smaller than the %d ports each user needs, using %d", step, models.UserPortCount, models.UserPortCount)
+		step = models.UserPortCount
+	}
+	if start < 1024 {
+		log.Printf("WARNING: PORT_RANGE_START %d is a privileged port, using 1024", start)
+		start = 1024
+	}
+	if end > 65535 {
+		log.Printf("WARNING: PORT_RANGE_END %d exceeds 65535, using 65535", end)
+		end = 65535
+	}
+	if end < start+models.UserPortCount-1 {
+		log.Printf("WARNING: port range %d-%d cannot hold a single user, no ports can be allocated", start, end)
+	}
+
+	// 主机端口检查依赖 Docker，客户端不可用时只按数据库分配
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		log.Printf("WARNING: docker client unavailable, host port conflicts will not be checked: %v", err)
+		cli = nil
+	}
+
+	return &PortAllocator{
+		db:          database.DB,
+		docker:      cli,
+		start:       start,
+		end:         end,
+		step:        step,
+		probeListen: envBool("PORT_PROBE_LISTEN", false),
+	}
+}
+
+// Allocate 在事务中选出编号最小的空闲端口段。调用方应在同一事务中写入 base_port 后提交，
+// 提交前其他分配会等待锁。excludeUserID 为重新分配时的用户，其原端口段不计为占用
+func (a *PortAllocator) Allocate(tx *sql.Tx, excludeUserID int) (int, error) {
+	hostPorts := a.hostBoundPorts()
+
+	taken, err := a.lockAllocations(tx, excludeUserID)
+	if err != nil {
+		return 0, err
+	}
+
+	for base := a.start; base+models.UserPortCount-1 <= a.end; base += a.step {
+		if a.overlaps(base, taken) || a.hostConflict(base, hostPorts) != 0 {
+			continue
+		}
+		return base, nil
+	}
+
+	return 0, fmt.Errorf("%w：%d-%d 中已没有空闲的端口段（每用户 %d 个端口，步长 %d，已分配 %d 个），请扩大 PORT_RANGE_END 或彻底删除回收站中的用户",
+		ErrPortRangeExhausted, a.start, a.end, models.UserPortCount, a.step, len(taken))
+}
+
+// Reserve 在事务中检查指定的端口段是否可用，供管理员手动指定端口
+func (a *PortAllocator) Reserve(tx *sql.Tx, basePort, excludeUserID int) error {
+	if basePort < a.start || basePort+models.UserPortCount-1 > a.end {
+		return fmt.Errorf("%w：%d-%d 不在 %d-%d 内", ErrPortOutOfRange, basePort, basePort+models.UserPortCount-1, a.start, a.end)
+	}
+
+	hostPorts := a.hostBoundPorts()
+
+	taken, err := a.lockAllocations(tx, excludeUserID)
+	if err != nil {
+		return err
+	}
+	for _, b := range taken {
+		if rangesOverlap(basePort, b) {
+			return fmt.Errorf("%w：%d-%d 与已分配的 %d-%d 重叠", ErrPortConflict,
+				basePort, basePort+models.UserPortCount-1, b, b+models.UserPortCount-1)
+		}
+	}
+	if port := a.hostConflict(basePort, hostPorts); port != 0 {
+		owner := hostPorts[port]
+		if owner == "" {
+			owner = "其他进程"
+		}
+		return fmt.Errorf("%w：主机端口 %d 正被 %s 使用", ErrPortConflict, port, owner)
+	}
+	return nil
+}
+
+// Usage 返回分配范围、各用户的端口段以及范围内被外部占用的主机端口
+func (a *PortAllocator) Usage() (*models.PortUsage, error) {
+	rows, err := a.db.Query(`
+		SELECT id, username, base_port, COALESCE(container_id, ''), deleted_at IS NOT NULL
+		FROM users WHERE base_port IS NOT NULL ORDER BY base_port
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := &models.PortUsage{
+		RangeStart:  a.start,
+		RangeEnd:    a.end,
+		Step:        a.step,
+		Allocations: []*models.PortAllocation{},
+		Conflicts:   []*models.PortConflict{},
+	}
+	var taken []int
+	for rows.Next() {
+		alloc := &models.PortAllocation{}
+		if err := rows.Scan(&alloc.UserID, &alloc.Username, &alloc.BasePort, &alloc.ContainerID, &alloc.Deleted); err != nil {
+			return nil, err
+		}
+		alloc.EndPort = alloc.BasePort + models.UserPortCount - 1
+		alloc.InRange = alloc.BasePort >= a.start && alloc.EndPort <= a.end
+		usage.Allocations = append(usage.Allocations, alloc)
+		taken = append(taken, alloc.BasePort)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	hostPorts := a.hostBoundPorts()
+	for port := a.start; port <= a.end; port++ {
+		owner, bound := hostPorts[port]
+		if !bound || a.overlapsPort(port, taken) {
+			continue
+		}
+		usage.Conflicts = append(usage.Conflicts, &models.PortConflict{Port: port, Owner: owner})
+	}
+
+	for base := a.start; base+models.UserPortCount-1 <= a.end; base += a.step {
+		usage.TotalBlocks++
+		if !a.overlaps(base, taken) && a.hostConflict(base, hostPorts) == 0 {
+			usage.FreeBlocks++
+		}
+	}
+	return usage, nil
+}
+
+// lockAllocations 以 FOR UPDATE 读取所有已分配的端口段，并发分配会在此串行化
+func (a *PortAllocator) lockAllocations(tx *sql.Tx, excludeUserID int) ([]int, error) {
+	rows, err := tx.Query("SELECT base_port FROM users WHERE base_port IS NOT NULL AND id <> ? ORDER BY base_port FOR UPDATE", excludeUserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var taken []int
+	for rows.Next() {
+		var base int
+		if err := rows.Scan(&base); err != nil {
+			return nil, err
+		}
+		taken = append(taken, base)
+	}
+	return taken, rows.Err()
+}
+
+func (a *PortAllocator) overlaps(base int, taken []int) bool {
+	for _, b := range taken {
+		if rangesOverlap(base, b) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *PortAllocator) overlapsPort(port int, taken []int) bool {
+	for _, b := range taken {
+		if port >= b && port < b+models.UserPortCount {
+			return true
+		}
+	}
+	return false
+}
+
+// rangesOverlap 判断两个起始端口对应的端口段是否重叠，旧数据中的端口段可能未按步长对齐
+func rangesOverlap(a, b int) bool {
+	return a < b+models.UserPortCount && b < a+models.UserPortCount
+}
+
+// hostConflict 返回端口段中第一个已被主机占用的端口，没有冲突时返回 0
+func (a *PortAllocator) hostConflict(base int, hostPorts map[int]string) int {
+	for port := base; port < base+models.UserPortCount; port++ {
+		if _, bound := hostPorts[port]; bound {
+			return port
+		}
+		if a.probeListen && !listenable(port) {
+			return port
+		}
+	}
+	return 0
+}
+
+// hostBoundPorts 汇总所有容器（包括已停止的）发布到主机的端口，值为容器名称。
+// 已停止的容器启动时仍会绑定这些端口，所以同样视为占用
+func (a *PortAllocator) hostBoundPorts() map[int]string {
+	bound := make(map[int]string)
+	if a.docker == nil {
+		return bound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	containers, err := a.docker.ContainerList(ctx, types.ContainerListOptions{All: true})
+	if err != nil {
+		log.Printf("port allocator: failed to list containers, host port conflicts not checked: %v", err)
+		return bound
+	}
+	for _, c := range containers {
+		name := c.ID
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		for _, p := range c.Ports {
+			if p.PublicPort != 0 {
+				bound[int(p.PublicPort)] = name
+			}
+		}
+	}
+	return bound
+}
+
+// listenable 尝试监听端口以发现非容器进程占用的端口，仅在后端使用主机网络时有意义
+func listenable(port int) bool {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
@@ -0,0 +1,233 @@
+package services
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"gpu-dev-platform/models"
+)
+
+// fakePortDB 只支持 lockAllocations 的查询：按用户ID保存端口段，查询时排除参数中的用户
+type fakePortDB struct {
+	mu    sync.Mutex
+	ports map[int]int // 用户ID -> base_port
+}
+
+var (
+	fakePortDBs   = map[string]*fakePortDB{}
+	fakePortDBsMu sync.Mutex
+)
+
+func init() {
+	sql.Register("fakeports", fakePortDriver{})
+}
+
+type fakePortDriver struct{}
+
+func (fakePortDriver) Open(name string) (driver.Conn, error) {
+	fakePortDBsMu.Lock()
+	defer fakePortDBsMu.Unlock()
+	db, ok := fakePortDBs[name]
+	if !ok {
+		return nil, errors.New("unknown fake port database " + name)
+	}
+	return &fakePortConn{db: db}, nil
+}
+
+type fakePortConn struct{ db *fakePortDB }
+
+func (c *fakePortConn) Prepare(query string) (driver.Stmt, error) { return &fakePortStmt{db: c.db}, nil }
+func (c *fakePortConn) Close() error                              { return nil }
+func (c *fakePortConn) Begin() (driver.Tx, error)                 { return fakePortTx{}, nil }
+
+type fakePortTx struct{}
+
+func (fakePortTx) Commit() error   { return nil }
+func (fakePortTx) Rollback() error { return nil }
+
+type fakePortStmt struct{ db *fakePortDB }
+
+func (s *fakePortStmt) Close() error  { return nil }
+func (s *fakePortStmt) NumInput() int { return -1 }
+func (s *fakePortStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s *fakePortStmt) Query(args []driver.Value) (driver.Rows, error) {
+	var exclude int64
+	if len(args) > 0 {
+		exclude, _ = args[0].(int64)
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	rows := &fakePortRows{}
+	for userID, base := range s.db.ports {
+		if int64(userID) != exclude {
+			rows.ports = append(rows.ports, base)
+		}
+	}
+	return rows, nil
+}
+
+type fakePortRows struct {
+	ports []int
+	next  int
+}
+
+func (r *fakePortRows) Columns() []string { return []string{"base_port"} }
+func (r *fakePortRows) Close() error      { return nil }
+func (r *fakePortRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.ports) {
+		return io.EOF
+	}
+	dest[0] = int64(r.ports[r.next])
+	r.next++
+	return nil
+}
+
+// newTestPortAllocator 不连接 Docker，只按数据库中的端口段分配
+func newTestPortAllocator(t *testing.T, start, end, step int, ports map[int]int) *PortAllocator {
+	t.Helper()
+	name := t.Name()
+	fakePortDBsMu.Lock()
+	fakePortDBs[name] = &fakePortDB{ports: ports}
+	fakePortDBsMu.Unlock()
+
+	db, err := sql.Open("fakeports", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &PortAllocator{db: db, start: start, end: end, step: step}
+}
+
+func withTx(t *testing.T, a *PortAllocator, fn func(tx *sql.Tx)) {
+	t.Helper()
+	tx, err := a.db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	fn(tx)
+}
+
+func TestRangesOverlap(t *testing.T) {
+	n := models.UserPortCount
+
+	tests := []struct {
+		name string
+		a, b int
+		want bool
+	}{
+		{name: "same base", a: 20000, b: 20000, want: true},
+		{name: "adjacent ranges", a: 20000, b: 20000 + n, want: false},
+		{name: "adjacent ranges reversed", a: 20000 + n, b: 20000, want: false},
+		{name: "unaligned overlap at the end", a: 20000, b: 20000 + n - 1, want: true},
+		{name: "unaligned overlap at the start", a: 20000 + n - 1, b: 20000, want: true},
+		{name: "one port apart", a: 20000, b: 20001, want: true},
+		{name: "far apart", a: 20000, b: 30000, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rangesOverlap(tt.a, tt.b); got != tt.want {
+				t.Errorf("rangesOverlap(%d, %d) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPortAllocatorAllocate(t *testing.T) {
+	n := models.UserPortCount
+
+	tests := []struct {
+		name    string
+		start   int
+		end     int
+		step    int
+		ports   map[int]int
+		exclude int
+		want    int
+		wantErr error
+	}{
+		{name: "empty range starts at the beginning", start: 9000, end: 9099, step: n, want: 9000},
+		{name: "next free block", start: 9000, end: 9099, step: n,
+			ports: map[int]int{1: 9000, 2: 9010}, want: 9020},
+		{name: "freed block is reused", start: 9000, end: 9099, step: n,
+			ports: map[int]int{1: 9000, 3: 9020}, want: 9010},
+		{name: "blocks follow the step", start: 9000, end: 9099, step: 20,
+			ports: map[int]int{1: 9000}, want: 9020},
+		{name: "unaligned legacy block skips overlapping slots", start: 9000, end: 9099, step: n,
+			ports: map[int]int{1: 9000, 2: 9015}, want: 9030},
+		{name: "reassignment ignores the user's own block", start: 9000, end: 9099, step: n,
+			ports: map[int]int{1: 9000, 2: 9010}, exclude: 1, want: 9000},
+		{name: "last block fits exactly", start: 9000, end: 9029, step: n,
+			ports: map[int]int{1: 9000, 2: 9010}, want: 9020},
+		{name: "exhausted range", start: 9000, end: 9029, step: n,
+			ports: map[int]int{1: 9000, 2: 9010, 3: 9020}, wantErr: ErrPortRangeExhausted},
+		{name: "partial block at the end is not used", start: 9000, end: 9025, step: n,
+			ports: map[int]int{1: 9000, 2: 9010}, wantErr: ErrPortRangeExhausted},
+		{name: "range too small for one user", start: 9000, end: 9005, step: n, wantErr: ErrPortRangeExhausted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestPortAllocator(t, tt.start, tt.end, tt.step, tt.ports)
+			withTx(t, a, func(tx *sql.Tx) {
+				got, err := a.Allocate(tx, tt.exclude)
+				if tt.wantErr != nil {
+					if !errors.Is(err, tt.wantErr) {
+						t.Fatalf("Allocate = %d, %v; want %v", got, err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != tt.want {
+					t.Errorf("Allocate = %d, want %d", got, tt.want)
+				}
+			})
+		})
+	}
+}
+
+func TestPortAllocatorReserve(t *testing.T) {
+	tests := []struct {
+		name    string
+		ports   map[int]int
+		base    int
+		exclude int
+		wantErr error
+	}{
+		{name: "free block", ports: map[int]int{1: 9000}, base: 9010},
+		{name: "freed block can be reserved", ports: map[int]int{1: 9000, 3: 9020}, base: 9010},
+		{name: "unaligned block inside the range", ports: map[int]int{1: 9000}, base: 9013},
+		{name: "taken block", ports: map[int]int{1: 9000}, base: 9000, wantErr: ErrPortConflict},
+		{name: "partially overlapping block", ports: map[int]int{1: 9010}, base: 9005, wantErr: ErrPortConflict},
+		{name: "user's own block", ports: map[int]int{1: 9000}, base: 9000, exclude: 1},
+		{name: "below the range", base: 8990, wantErr: ErrPortOutOfRange},
+		{name: "runs past the end", base: 9095, wantErr: ErrPortOutOfRange},
+		{name: "last block", base: 9090},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestPortAllocator(t, 9000, 9099, models.UserPortCount, tt.ports)
+			withTx(t, a, func(tx *sql.Tx) {
+				err := a.Reserve(tx, tt.base, tt.exclude)
+				if tt.wantErr == nil && err != nil {
+					t.Fatalf("Reserve(%d) = %v, want nil", tt.base, err)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Reserve(%d) = %v, want %v", tt.base, err, tt.wantErr)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gpu-dev-platform/models"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+)
+
+var ErrInvalidResources = errors.New("invalid container resources")
+
+// 内存下限，过小的限制会让容器内的 sshd 等服务无法启动
+const minContainerMemory = 64 * units.MiB
+
+// ResourcePolicy 用户容器资源限制的默认值和上限，由环境变量配置
+type ResourcePolicy struct {
+	DefaultCPUs   float64
+	MaxCPUs       float64
+	DefaultMemory int64
+	MaxMemory     int64
+	DefaultSwap   int64
+	MaxSwap       int64
+	DefaultPids   int64
+	MaxPids       int64
+	DefaultShm    int64
+	MaxShm        int64
+}
+
+// ContainerLimits 校验并补全默认值后的资源限制
+type ContainerLimits struct {
+	CPUs   float64
+	Memory int64
+	Swap   int64
+	Pids   int64
+	Shm    int64
+}
+
+var (
+	defaultResourcePolicy     *ResourcePolicy
+	defaultResourcePolicyOnce sync.Once
+)
+
+// DefaultResourcePolicy 返回按环境变量加载的资源策略
+func DefaultResourcePolicy() *ResourcePolicy {
+	defaultResourcePolicyOnce.Do(func() {
+		defaultResourcePolicy = LoadResourcePolicy()
+	})
+	return defaultResourcePolicy
+}
+
+func LoadResourcePolicy() *ResourcePolicy {
+	p := &ResourcePolicy{
+		DefaultCPUs:   envFloat("CONTAINER_DEFAULT_CPUS", 2),
+		MaxCPUs:       envFloat("CONTAINER_MAX_CPUS", 16),
+		DefaultMemory: envBytes("CONTAINER_DEFAULT_MEMORY", 4*units.GiB),
+		MaxMemory:     envBytes("CONTAINER_MAX_MEMORY", 64*units.GiB),
+		DefaultSwap:   envBytes("CONTAINER_DEFAULT_SWAP", 0),
+		MaxSwap:       envBytes("CONTAINER_MAX_SWAP", 8*units.GiB),
+		DefaultPids:   int64(envInt("CONTAINER_DEFAULT_PIDS", 4096)),
+		MaxPids:       int64(envInt("CONTAINER_MAX_PIDS", 32768)),
+		DefaultShm:    envBytes("CONTAINER_DEFAULT_SHM_SIZE", 1*units.GiB),
+		MaxShm:        envBytes("CONTAINER_MAX_SHM_SIZE", 16*units.GiB),
+	}
+
+	// 默认值超过上限时以默认值为准，避免不带参数的创建请求被拒绝
+	if p.DefaultCPUs > p.MaxCPUs {
+		log.Printf("WARNING: CONTAINER_DEFAULT_CPUS exceeds CONTAINER_MAX_CPUS, raising the maximum to %g", p.DefaultCPUs)
+		p.MaxCPUs = p.DefaultCPUs
+	}
+	if p.DefaultMemory > p.MaxMemory {
+		log.Printf("WARNING: CONTAINER_DEFAULT_MEMORY exceeds CONTAINER_MAX_MEMORY, raising the maximum to %s", formatBytes(p.DefaultMemory))
+		p.MaxMemory = p.DefaultMemory
+	}
+	if p.DefaultSwap > p.MaxSwap {
+		log.Printf("WARNING: CONTAINER_DEFAULT_SWAP exceeds CONTAINER_MAX_SWAP, raising the maximum to %s", formatBytes(p.DefaultSwap))
+		p.MaxSwap = p.DefaultSwap
+	}
+	if p.DefaultPids > p.MaxPids {
+		log.Printf("WARNING: CONTAINER_DEFAULT_PIDS exceeds CONTAINER_MAX_PIDS, raising the maximum to %d", p.DefaultPids)
+		p.MaxPids = p.DefaultPids
+	}
+	if p.DefaultShm > p.MaxShm {
+		log.Printf("WARNING: CONTAINER_DEFAULT_SHM_SIZE exceeds CONTAINER_MAX_SHM_SIZE, raising the maximum to %s", formatBytes(p.DefaultShm))
+		p.MaxShm = p.DefaultShm
+	}
+
+	return p
+}
+
+// Defaults 未指定资源时使用的限制
+func (p *ResourcePolicy) Defaults() *models.ContainerResources {
+	return (&ContainerLimits{CPUs: p.DefaultCPUs, Memory: p.DefaultMemory, Swap: p.DefaultSwap, Pids: p.DefaultPids, Shm: p.DefaultShm}).Spec()
+}
+
+// Maxima 允许设置的上限
+func (p *ResourcePolicy) Maxima() *models.ContainerResources {
+	return (&ContainerLimits{CPUs: p.MaxCPUs, Memory: p.MaxMemory, Swap: p.MaxSwap, Pids: p.MaxPids, Shm: p.MaxShm}).Spec()
+}
+
+// Resolve 为未指定的项填入默认值并按上限校验，req 为 nil 时全部使用默认值
+func (p *ResourcePolicy) Resolve(req *models.ContainerResources) (*ContainerLimits, error) {
+	limits := &ContainerLimits{
+		CPUs:   p.DefaultCPUs,
+		Memory: p.DefaultMemory,
+		Swap:   p.DefaultSwap,
+		Pids:   p.DefaultPids,
+		Shm:    p.DefaultShm,
+	}
+	if req == nil {
+		return limits, nil
+	}
+
+	var err error
+	if req.CPUs != "" {
+		limits.CPUs, err = strconv.ParseFloat(strings.TrimSpace(req.CPUs), 64)
+		// ParseFloat 接受 NaN 和 Inf，NaN 与任何数比较都为假，会绕过上限检查
+		if err != nil || math.IsNaN(limits.CPUs) || math.IsInf(limits.CPUs, 0) || limits.CPUs <= 0 {
+			return nil, fmt.Errorf("%w: CPU 数量无效: %s", ErrInvalidResources, req.CPUs)
+		}
+	}
+	if req.Memory != "" {
+		if limits.Memory, err = parseBytes("内存", req.Memory); err != nil {
+			return nil, err
+		}
+	}
+	if req.Swap != "" {
+		if limits.Swap, err = parseBytes("交换空间", req.Swap); err != nil {
+			return nil, err
+		}
+	}
+	if req.PidsLimit < 0 {
+		return nil, fmt.Errorf("%w: 进程数限制无效: %d", ErrInvalidResources, req.PidsLimit)
+	}
+	if req.PidsLimit > 0 {
+		limits.Pids = req.PidsLimit
+	}
+	if req.ShmSize != "" {
+		if limits.Shm, err = parseBytes("共享内存", req.ShmSize); err != nil {
+			return nil, err
+		}
+	}
+
+	return limits, p.validate(limits)
+}
+
+func (p *ResourcePolicy) validate(l *ContainerLimits) error {
+	switch {
+	case l.CPUs > p.MaxCPUs:
+		return fmt.Errorf("%w: CPU 数量 %g 超过上限 %g", ErrInvalidResources, l.CPUs, p.MaxCPUs)
+	case l.Memory < minContainerMemory:
+		return fmt.Errorf("%w: 内存不能小于 %s", ErrInvalidResources, formatBytes(minContainerMemory))
+	case l.Memory > p.MaxMemory:
+		return fmt.Errorf("%w: 内存 %s 超过上限 %s", ErrInvalidResources, formatBytes(l.Memory), formatBytes(p.MaxMemory))
+	case l.Swap > p.MaxSwap:
+		return fmt.Errorf("%w: 交换空间 %s 超过上限 %s", ErrInvalidResources, formatBytes(l.Swap), formatBytes(p.MaxSwap))
+	case l.Pids > p.MaxPids:
+		return fmt.Errorf("%w: 进程数限制 %d 超过上限 %d", ErrInvalidResources, l.Pids, p.MaxPids)
+	case l.Shm <= 0:
+		return fmt.Errorf("%w: 共享内存必须大于 0", ErrInvalidResources)
+	case l.Shm > p.MaxShm:
+		return fmt.Errorf("%w: 共享内存 %s 超过上限 %s", ErrInvalidResources, formatBytes(l.Shm), formatBytes(p.MaxShm))
+	case l.Shm > l.Memory:
+		// /dev/shm 计入容器内存，超过内存限制没有意义
+		return fmt.Errorf("%w: 共享内存 %s 不能超过内存 %s", ErrInvalidResources, formatBytes(l.Shm), formatBytes(l.Memory))
+	}
+	return nil
+}
+
+// Spec 以配置文件中的写法表示资源限制，用于保存到容器记录和返回给前端
+func (l *ContainerLimits) Spec() *models.ContainerResources {
+	return &models.ContainerResources{
+		CPUs:      strconv.FormatFloat(l.CPUs, 'f', -1, 64),
+		Memory:    formatBytes(l.Memory),
+		Swap:      formatBytes(l.Swap),
+		PidsLimit: l.Pids,
+		ShmSize:   formatBytes(l.Shm),
+	}
+}
+
+// apply 写入 Docker 的 HostConfig。MemorySwap 是内存与交换空间之和，等于 Memory 时不使用交换空间
+func (l *ContainerLimits) apply(hostConfig *container.HostConfig) {
+	pids := l.Pids
+	hostConfig.Resources.NanoCPUs = int64(l.CPUs * 1e9)
+	hostConfig.Resources.Memory = l.Memory
+	hostConfig.Resources.MemorySwap = l.Memory + l.Swap
+	hostConfig.Resources.PidsLimit = &pids
+	hostConfig.ShmSize = l.Shm
+}
+
+func parseBytes(name, value string) (int64, error) {
+	n, err := units.RAMInBytes(strings.TrimSpace(value))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w: %s大小无效: %s", ErrInvalidResources, name, value)
+	}
+	return n, nil
+}
+
+// formatBytes 尽量以 g/m/k 为单位输出，与 docker run 的参数写法一致
+func formatBytes(n int64) string {
+	switch {
+	case n == 0:
+		return "0"
+	case n%units.GiB == 0:
+		return fmt.Sprintf("%dg", n/units.GiB)
+	case n%units.MiB == 0:
+		return fmt.Sprintf("%dm", n/units.MiB)
+	case n%units.KiB == 0:
+		return fmt.Sprintf("%dk", n/units.KiB)
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+func envFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return f
+		}
+	}
+	return defaultValue
+}
+
+func envBytes(key string, defaultValue int64) int64 {
+	if value := os.Getenv(key); value != "" {
+		if n, err := units.RAMInBytes(value); err == nil {
+			return n
+		}
+		log.Printf("WARNING: invalid %s %q, using %s", key, value, formatBytes(defaultValue))
+	}
+	return defaultValue
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"gpu-dev-platform/models"
+	"github.com/docker/go-units"
+)
+
+func TestResourcePolicyResolve(t *testing.T) {
+	policy := &ResourcePolicy{
+		DefaultCPUs: 2, MaxCPUs: 16,
+		DefaultMemory: 4 * units.GiB, MaxMemory: 64 * units.GiB,
+		DefaultSwap: 0, MaxSwap: 8 * units.GiB,
+		DefaultPids: 4096, MaxPids: 32768,
+		DefaultShm: 1 * units.GiB, MaxShm: 16 * units.GiB,
+	}
+	defaults := ContainerLimits{CPUs: 2, Memory: 4 * units.GiB, Swap: 0, Pids: 4096, Shm: 1 * units.GiB}
+
+	tests := []struct {
+		name    string
+		req     *models.ContainerResources
+		want    ContainerLimits
+		wantErr bool
+	}{
+		{name: "nil request uses defaults", req: nil, want: defaults},
+		{name: "empty request uses defaults", req: &models.ContainerResources{}, want: defaults},
+		{name: "all fields", req: &models.ContainerResources{CPUs: "4.5", Memory: "16g", Swap: "2g", PidsLimit: 8192, ShmSize: "8g"},
+			want: ContainerLimits{CPUs: 4.5, Memory: 16 * units.GiB, Swap: 2 * units.GiB, Pids: 8192, Shm: 8 * units.GiB}},
+		{name: "partial request keeps other defaults", req: &models.ContainerResources{CPUs: " 8 "},
+			want: ContainerLimits{CPUs: 8, Memory: 4 * units.GiB, Swap: 0, Pids: 4096, Shm: 1 * units.GiB}},
+		{name: "at the maxima", req: &models.ContainerResources{CPUs: "16", Memory: "64g", Swap: "8g", PidsLimit: 32768, ShmSize: "16g"},
+			want: ContainerLimits{CPUs: 16, Memory: 64 * units.GiB, Swap: 8 * units.GiB, Pids: 32768, Shm: 16 * units.GiB}},
+		{name: "CPUs above maximum", req: &models.ContainerResources{CPUs: "32"}, wantErr: true},
+		{name: "zero CPUs", req: &models.ContainerResources{CPUs: "0"}, wantErr: true},
+		{name: "negative CPUs", req: &models.ContainerResources{CPUs: "-1"}, wantErr: true},
+		{name: "NaN CPUs", req: &models.ContainerResources{CPUs: "NaN"}, wantErr: true},
+		{name: "infinite CPUs", req: &models.ContainerResources{CPUs: "+Inf"}, wantErr: true},
+		{name: "non-numeric CPUs", req: &models.ContainerResources{CPUs: "two"}, wantErr: true},
+		{name: "memory above maximum", req: &models.ContainerResources{Memory: "128g"}, wantErr: true},
+		{name: "memory below minimum", req: &models.ContainerResources{Memory: "32m", ShmSize: "16m"}, wantErr: true},
+		{name: "invalid memory", req: &models.ContainerResources{Memory: "lots"}, wantErr: true},
+		{name: "swap above maximum", req: &models.ContainerResources{Swap: "16g"}, wantErr: true},
+		{name: "negative pids", req: &models.ContainerResources{PidsLimit: -1}, wantErr: true},
+		{name: "pids above maximum", req: &models.ContainerResources{PidsLimit: 65536}, wantErr: true},
+		{name: "zero shm", req: &models.ContainerResources{ShmSize: "0"}, wantErr: true},
+		{name: "shm larger than memory", req: &models.ContainerResources{Memory: "2g", ShmSize: "4g"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := policy.Resolve(tt.req)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidResources) {
+					t.Fatalf("Resolve(%+v) = %+v, %v; want ErrInvalidResources", tt.req, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve(%+v): %v", tt.req, err)
+			}
+			if *got != tt.want {
+				t.Errorf("Resolve(%+v) = %+v, want %+v", tt.req, *got, tt.want)
+			}
+		})
+	}
+}
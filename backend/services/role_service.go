@@ -0,0 +1,279 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"gpu-dev-platform/database"
+	"gpu-dev-platform/models"
+)
+
+var (
+	ErrBuiltinRole       = errors.New("builtin role cannot be modified")
+	ErrUnknownPermission = errors.New("unknown permission")
+)
+
+// RoleService 角色、权限和用户角色分配
+// is_admin 账户视为拥有 platform-admin 角色，兼容旧数据和管理界面中的管理员开关
+type RoleService struct {
+	db *sql.DB
+}
+
+func NewRoleService() *RoleService {
+	return &RoleService{db: database.DB}
+}
+
+// EnsureBuiltinRoles 写入内置角色，已存在时按当前版本覆盖其权限
+func (s *RoleService) EnsureBuiltinRoles() error {
+	if err := s.renameBuiltinRoles(); err != nil {
+		return err
+	}
+
+	for _, role := range models.BuiltinRoles {
+		_, err := s.db.Exec(`
+			INSERT INTO roles (name, description, is_builtin) VALUES (?, ?, TRUE)
+			ON DUPLICATE KEY UPDATE description = VALUES(description), is_builtin = TRUE
+		`, role.Name, role.Description)
+		if err != nil {
+			return err
+		}
+
+		var id int
+		if err := s.db.QueryRow("SELECT id FROM roles WHERE name = ?", role.Name).Scan(&id); err != nil {
+			return err
+		}
+		if err := s.setPermissions(id, role.Permissions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renameBuiltinRoles 把旧名称的内置角色改为新名称，新名称已存在时保持不变
+func (s *RoleService) renameBuiltinRoles() error {
+	for oldName, newName := range models.RenamedBuiltinRoles {
+		var exists int
+		err := s.db.QueryRow("SELECT COUNT(*) FROM roles WHERE name = ?", newName).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists > 0 {
+			continue
+		}
+		if _, err := s.db.Exec("UPDATE roles SET name = ? WHERE name = ? AND is_builtin = TRUE", newName, oldName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RoleService) ListRoles() ([]*models.Role, error) {
+	rows, err := s.db.Query("SELECT id, name, COALESCE(description, ''), is_builtin, created_at FROM roles ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	roles, err := scanRoles(rows)
+	if err != nil {
+		return nil, err
+	}
+	return roles, s.loadPermissions(roles)
+}
+
+func (s *RoleService) GetRole(id int) (*models.Role, error) {
+	role := &models.Role{}
+	err := s.db.QueryRow("SELECT id, name, COALESCE(description, ''), is_builtin, created_at FROM roles WHERE id = ?", id).
+		Scan(&role.ID, &role.Name, &role.Description, &role.IsBuiltin, &role.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return role, s.loadPermissions([]*models.Role{role})
+}
+
+func (s *RoleService) CreateRole(name, description string, permissions []string) (*models.Role, error) {
+	if err := validatePermissions(permissions); err != nil {
+		return nil, err
+	}
+
+	result, err := s.db.Exec("INSERT INTO roles (name, description, is_builtin, created_at) VALUES (?, ?, FALSE, ?)",
+		name, description, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.setPermissions(int(id), permissions); err != nil {
+		return nil, err
+	}
+	return s.GetRole(int(id))
+}
+
+// UpdateRole 修改自定义角色的描述和权限，permissions 为 nil 时不修改权限
+func (s *RoleService) UpdateRole(id int, description *string, permissions []string) error {
+	role, err := s.GetRole(id)
+	if err != nil {
+		return err
+	}
+	if role.IsBuiltin {
+		return ErrBuiltinRole
+	}
+
+	if description != nil {
+		if _, err := s.db.Exec("UPDATE roles SET description = ? WHERE id = ?", *description, id); err != nil {
+			return err
+		}
+	}
+	if permissions != nil {
+		if err := validatePermissions(permissions); err != nil {
+			return err
+		}
+		return s.setPermissions(id, permissions)
+	}
+	return nil
+}
+
+// DeleteRole 删除自定义角色，已分配的用户随之失去该角色
+func (s *RoleService) DeleteRole(id int) error {
+	role, err := s.GetRole(id)
+	if err != nil {
+		return err
+	}
+	if role.IsBuiltin {
+		return ErrBuiltinRole
+	}
+	_, err = s.db.Exec("DELETE FROM roles WHERE id = ?", id)
+	return err
+}
+
+func (s *RoleService) GetUserRoles(userID int) ([]*models.Role, error) {
+	rows, err := s.db.Query(`
+		SELECT r.id, r.name, COALESCE(r.description, ''), r.is_builtin, r.created_at
+		FROM roles r JOIN user_roles ur ON ur.role_id = r.id
+		WHERE ur.user_id = ? ORDER BY r.id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := scanRoles(rows)
+	if err != nil {
+		return nil, err
+	}
+	return roles, s.loadPermissions(roles)
+}
+
+// SetUserRoles 用给定角色整体替换用户的角色，角色不存在时返回 sql.ErrNoRows
+func (s *RoleService) SetUserRoles(userID int, roleIDs []int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM user_roles WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, roleID := range roleIDs {
+		var exists int
+		if err := tx.QueryRow("SELECT 1 FROM roles WHERE id = ?", roleID).Scan(&exists); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT IGNORE INTO user_roles (user_id, role_id) VALUES (?, ?)", userID, roleID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UserPermissions 返回用户通过角色获得的全部权限点
+func (s *RoleService) UserPermissions(userID int, isAdmin bool) ([]string, error) {
+	if isAdmin {
+		return models.AllPermissions, nil
+	}
+
+	rows, err := s.db.Query(`
+		SELECT DISTINCT rp.permission
+		FROM role_permissions rp JOIN user_roles ur ON ur.role_id = rp.role_id
+		WHERE ur.user_id = ?
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []string{}
+	for rows.Next() {
+		var perm string
+		if err := rows.Scan(&perm); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, perm)
+	}
+	return permissions, rows.Err()
+}
+
+func (s *RoleService) setPermissions(roleID int, permissions []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM role_permissions WHERE role_id = ?", roleID); err != nil {
+		return err
+	}
+	for _, perm := range permissions {
+		if _, err := tx.Exec("INSERT IGNORE INTO role_permissions (role_id, permission) VALUES (?, ?)", roleID, perm); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *RoleService) loadPermissions(roles []*models.Role) error {
+	for _, role := range roles {
+		rows, err := s.db.Query("SELECT permission FROM role_permissions WHERE role_id = ? ORDER BY permission", role.ID)
+		if err != nil {
+			return err
+		}
+		role.Permissions = []string{}
+		for rows.Next() {
+			var perm string
+			if err := rows.Scan(&perm); err != nil {
+				rows.Close()
+				return err
+			}
+			role.Permissions = append(role.Permissions, perm)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanRoles(rows *sql.Rows) ([]*models.Role, error) {
+	defer rows.Close()
+
+	roles := []*models.Role{}
+	for rows.Next() {
+		role := &models.Role{}
+		if err := rows.Scan(&role.ID, &role.Name, &role.Description, &role.IsBuiltin, &role.CreatedAt); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+func validatePermissions(permissions []string) error {
+	for _, perm := range permissions {
+		if !models.ValidPermission(perm) {
+			return ErrUnknownPermission
+		}
+	}
+	return nil
+}
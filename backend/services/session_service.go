@@ -0,0 +1,158 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"os"
+	"time"
+
+	"gpu-dev-platform/database"
+)
+
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// 访问token有效期短，刷新token存库可随时吊销
+var (
+	AccessTokenTTL  = parseDurationEnv("ACCESS_TOKEN_TTL", 15*time.Minute)
+	RefreshTokenTTL = parseDurationEnv("REFRESH_TOKEN_TTL", 7*24*time.Hour)
+)
+
+type SessionService struct {
+	db *sql.DB
+}
+
+func NewSessionService() *SessionService {
+	return &SessionService{db: database.DB}
+}
+
+// CreateSession 为用户创建登录会话，返回会话ID和刷新token（明文只返回这一次）
+func (s *SessionService) CreateSession(userID int, userAgent, ip string) (string, string, error) {
+	sessionID, err := randomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	_, err = s.db.Exec(`
+		INSERT INTO sessions (id, user_id, refresh_token_hash, user_agent, ip_address, created_at, last_used_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, sessionID, userID, hashToken(refreshToken), truncate(userAgent, 255), ip, now, now, now.Add(RefreshTokenTTL))
+	if err != nil {
+		return "", "", err
+	}
+
+	// 顺便清理该用户已过期的会话
+	_, _ = s.db.Exec("DELETE FROM sessions WHERE user_id = ? AND expires_at < ?", userID, now)
+
+	return sessionID, refreshToken, nil
+}
+
+// Refresh 校验刷新token并轮换为新的刷新token，旧token立即失效
+func (s *SessionService) Refresh(refreshToken string) (userID int, sessionID string, newRefreshToken string, err error) {
+	now := time.Now()
+	err = s.db.QueryRow(`
+		SELECT id, user_id FROM sessions
+		WHERE refresh_token_hash = ? AND revoked_at IS NULL AND expires_at > ?
+	`, hashToken(refreshToken), now).Scan(&sessionID, &userID)
+	if err == sql.ErrNoRows {
+		return 0, "", "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	newRefreshToken, err = randomToken(32)
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	// 以旧hash为条件更新，防止同一刷新token被并发使用两次
+	result, err := s.db.Exec(`
+		UPDATE sessions SET refresh_token_hash = ?, last_used_at = ?, expires_at = ?
+		WHERE id = ? AND refresh_token_hash = ?
+	`, hashToken(newRefreshToken), now, now.Add(RefreshTokenTTL), sessionID, hashToken(refreshToken))
+	if err != nil {
+		return 0, "", "", err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, "", "", ErrInvalidRefreshToken
+	}
+
+	return userID, sessionID, newRefreshToken, nil
+}
+
+// IsSessionActive 检查会话未被吊销且未过期
+func (s *SessionService) IsSessionActive(sessionID string, userID int) (bool, error) {
+	var count int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM sessions
+		WHERE id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > ?
+	`, sessionID, userID, time.Now()).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// RevokeSession 吊销单个会话（退出登录）
+func (s *SessionService) RevokeSession(sessionID string) error {
+	_, err := s.db.Exec("UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL",
+		time.Now(), sessionID)
+	return err
+}
+
+// RevokeUserSessions 吊销用户的全部会话，返回吊销数量
+func (s *SessionService) RevokeUserSessions(userID int) (int64, error) {
+	result, err := s.db.Exec("UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL",
+		time.Now(), userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RevokeOtherSessions 吊销用户除 keepSessionID 以外的全部会话（修改密码后使其他设备下线），返回吊销数量
+func (s *SessionService) RevokeOtherSessions(userID int, keepSessionID string) (int64, error) {
+	result, err := s.db.Exec("UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND id <> ? AND revoked_at IS NULL",
+		time.Now(), userID, keepSessionID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+func parseDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return defaultValue
+}
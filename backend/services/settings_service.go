@@ -0,0 +1,59 @@
+package services
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"gpu-dev-platform/database"
+)
+
+// 平台级设置项
+const (
+	SettingRequireAdmin2FA = "require_admin_2fa"
+)
+
+// SettingsService 读写 platform_settings 表中的键值配置
+type SettingsService struct {
+	db *sql.DB
+}
+
+func NewSettingsService() *SettingsService {
+	return &SettingsService{db: database.DB}
+}
+
+func (s *SettingsService) Get(key string) (string, bool, error) {
+	var value string
+	err := s.db.QueryRow("SELECT value FROM platform_settings WHERE name = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+func (s *SettingsService) Set(key, value string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO platform_settings (name, value, updated_at) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE value = VALUES(value), updated_at = VALUES(updated_at)
+	`, key, value, time.Now())
+	return err
+}
+
+func (s *SettingsService) GetBool(key string, defaultValue bool) (bool, error) {
+	value, ok, err := s.Get(key)
+	if err != nil || !ok {
+		return defaultValue, err
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultValue, nil
+	}
+	return b, nil
+}
+
+func (s *SettingsService) SetBool(key string, value bool) error {
+	return s.Set(key, strconv.FormatBool(value))
+}
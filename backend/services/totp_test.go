@@ -0,0 +1,85 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret RFC 6238 附录B SHA-1 测试向量的密钥 "12345678901234567890"
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// RFC 6238 附录B的 SHA-1 测试向量。RFC 中为8位验证码，6位验证码取其后6位
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{unix: 59, code: "287082"},
+	{unix: 1111111109, code: "081804"},
+	{unix: 1111111111, code: "050471"},
+	{unix: 1234567890, code: "005924"},
+	{unix: 2000000000, code: "279037"},
+	{unix: 20000000000, code: "353130"},
+}
+
+func TestTOTPCode(t *testing.T) {
+	for _, tt := range rfc6238Vectors {
+		t.Run(tt.code, func(t *testing.T) {
+			got, err := totpCode(rfc6238Secret, tt.unix/totpPeriod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.code {
+				t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.code)
+			}
+		})
+	}
+}
+
+func TestTOTPCodeLowercaseSecret(t *testing.T) {
+	got, err := totpCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1)
+	if err != nil || got != "287082" {
+		t.Errorf("totpCode with lowercase secret = %s, %v; want 287082", got, err)
+	}
+}
+
+func TestTOTPCodeInvalidSecret(t *testing.T) {
+	if _, err := totpCode("not base32!", 1); err == nil {
+		t.Error("totpCode accepted an invalid secret")
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	for _, tt := range rfc6238Vectors {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := ValidateTOTP(rfc6238Secret, tt.code, time.Unix(tt.unix, 0)); got != tt.unix/totpPeriod {
+				t.Errorf("ValidateTOTP at %d = %d, want %d", tt.unix, got, tt.unix/totpPeriod)
+			}
+		})
+	}
+
+	// 用 1111111111 所在时间步的验证码检查允许的时钟偏差
+	step := int64(1111111111 / totpPeriod)
+	tests := []struct {
+		name string
+		now  time.Time
+		code string
+		want int64
+	}{
+		{name: "one step behind", now: time.Unix(1111111111+totpPeriod, 0), code: "050471", want: step},
+		{name: "one step ahead", now: time.Unix(1111111111-totpPeriod, 0), code: "050471", want: step},
+		{name: "two steps behind", now: time.Unix(1111111111+2*totpPeriod, 0), code: "050471", want: -1},
+		{name: "two steps ahead", now: time.Unix(1111111111-2*totpPeriod, 0), code: "050471", want: -1},
+		{name: "surrounding spaces", now: time.Unix(1111111111, 0), code: " 050471 ", want: step},
+		{name: "wrong code", now: time.Unix(1111111111, 0), code: "050472", want: -1},
+		{name: "eight digit code", now: time.Unix(1111111111, 0), code: "14050471", want: -1},
+		{name: "empty code", now: time.Unix(1111111111, 0), code: "", want: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidateTOTP(rfc6238Secret, tt.code, tt.now); got != tt.want {
+				t.Errorf("ValidateTOTP(%q) = %d, want %d", tt.code, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"gpu-dev-platform/database"
+	"gpu-dev-platform/models"
+)
+
+// TrashPurger 定期彻底删除在回收站中超过保留期的用户
+type TrashPurger struct {
+	db          *sql.DB
+	userService *UserService
+	cleanup     *UserCleanupService
+
+	interval  time.Duration
+	retention time.Duration
+	data      string
+}
+
+func NewTrashPurger() (*TrashPurger, error) {
+	containerService, err := NewContainerService()
+	if err != nil {
+		return nil, err
+	}
+
+	data := getEnvWithDefault("TRASH_PURGE_DATA", UserDataArchive)
+	if !ValidUserDataOption(data) {
+		log.Printf("WARNING: invalid TRASH_PURGE_DATA %q, using %s", data, UserDataArchive)
+		data = UserDataArchive
+	}
+
+	return &TrashPurger{
+		db:          database.DB,
+		userService: NewUserService(),
+		cleanup:     NewUserCleanupService(containerService),
+		interval:    parseDurationEnv("TRASH_PURGE_INTERVAL", time.Hour),
+		retention:   time.Duration(envInt("TRASH_RETENTION_DAYS", 30)) * 24 * time.Hour,
+		data:        data,
+	}, nil
+}
+
+// Run 启动后立即检查一次，之后按间隔循环，应在独立 goroutine 中调用
+func (p *TrashPurger) Run() {
+	log.Printf("Trash purger started (interval %s, retention %s, data %s)", p.interval, p.retention, p.data)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.RunOnce()
+		<-ticker.C
+	}
+}
+
+// RunOnce 彻底删除一轮，失败的用户保留在回收站中，下一轮重试
+func (p *TrashPurger) RunOnce() {
+	rows, err := p.db.Query("SELECT id FROM users WHERE deleted_at IS NOT NULL AND deleted_at <= ?",
+		time.Now().Add(-p.retention))
+	if err != nil {
+		log.Printf("trash purger: query failed: %v", err)
+		return
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		user, err := p.userService.GetUserByID(id)
+		if err != nil {
+			continue
+		}
+
+		op, err := p.cleanup.Purge(user, DeleteUserOptions{Data: p.data, ActorName: "system"})
+		if err != nil {
+			log.Printf("trash purger: failed to purge %s: %v", user.Username, err)
+			continue
+		}
+		if op.Status != models.OperationCompleted {
+			log.Printf("trash purger: purge of %s finished with status %s (operation %d)", user.Username, op.Status, op.ID)
+		}
+	}
+}
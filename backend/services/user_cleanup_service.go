@@ -0,0 +1,204 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"gpu-dev-platform/database"
+	"gpu-dev-platform/models"
+)
+
+// 删除用户时家目录的处理方式
+const (
+	UserDataArchive = "archive" // 打包到 USER_ARCHIVE_PATH 后删除原目录
+	UserDataDelete  = "delete"
+	UserDataKeep    = "keep"
+)
+
+// DeleteUserOptions 删除用户的参数，操作人信息用于记录操作和审计日志
+type DeleteUserOptions struct {
+	Data      string
+	ActorID   int
+	ActorName string
+	SourceIP  string
+}
+
+// UserCleanupService 用户回收站，以及彻底删除用户并清理其容器、端口和家目录
+type UserCleanupService struct {
+	db               *sql.DB
+	userService      *UserService
+	sessionService   *SessionService
+	containerService *ContainerService
+	operations       *OperationService
+	audit            *AuditService
+}
+
+func NewUserCleanupService(containerService *ContainerService) *UserCleanupService {
+	return &UserCleanupService{
+		db:               database.DB,
+		userService:      NewUserService(),
+		sessionService:   NewSessionService(),
+		containerService: containerService,
+		operations:       NewOperationService(),
+		audit:            NewAuditService(),
+	}
+}
+
+// ValidUserDataOption 判断家目录处理方式是否受支持
+func ValidUserDataOption(data string) bool {
+	return data == UserDataArchive || data == UserDataDelete || data == UserDataKeep
+}
+
+// Trash 将用户移入回收站：禁止登录、吊销会话并停止容器，账户记录、容器和数据都保留以便恢复。
+// 未开始的 GPU 租约取消，进行中的租约立即结束，由 GPULeaseWorker 卸载 GPU
+func (s *UserCleanupService) Trash(user *models.User) error {
+	now := time.Now()
+	if _, err := s.db.Exec("UPDATE users SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL", now, user.ID); err != nil {
+		return err
+	}
+	s.sessionService.RevokeUserSessions(user.ID)
+
+	// 回收站中的用户不再占用预约的 GPU
+	if _, err := s.db.Exec("UPDATE gpu_leases SET status = ? WHERE user_id = ? AND status = ?",
+		models.GPULeaseCancelled, user.ID, models.GPULeaseScheduled); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec("UPDATE gpu_leases SET ends_at = ? WHERE user_id = ? AND status = ? AND ends_at > ?",
+		now, user.ID, models.GPULeaseActive, now); err != nil {
+		return err
+	}
+
+	if user.ContainerID != "" {
+		if err := s.containerService.StopContainer(user.ContainerID); err != nil {
+			// 容器可能本来就没有运行，账户已在回收站中
+			log.Printf("failed to stop container of trashed user %s: %v", user.Username, err)
+		}
+	}
+	return nil
+}
+
+// Restore 从回收站恢复用户，容器保持停止状态，由用户或管理员自行启动。
+// 移入回收站时取消或结束的 GPU 租约不会恢复，需要时重新申请
+func (s *UserCleanupService) Restore(user *models.User) error {
+	_, err := s.db.Exec("UPDATE users SET deleted_at = NULL WHERE id = ?", user.ID)
+	return err
+}
+
+// StartPurge 创建彻底删除操作并在后台执行，立即返回操作记录供轮询
+func (s *UserCleanupService) StartPurge(user *models.User, opts DeleteUserOptions) (*models.Operation, error) {
+	op, err := s.newPurgeOperation(user, opts)
+	if err != nil {
+		return nil, err
+	}
+	go s.runPurge(op, user, opts)
+	return op, nil
+}
+
+// Purge 同步彻底删除，供回收站清理任务使用
+func (s *UserCleanupService) Purge(user *models.User, opts DeleteUserOptions) (*models.Operation, error) {
+	op, err := s.newPurgeOperation(user, opts)
+	if err != nil {
+		return nil, err
+	}
+	s.runPurge(op, user, opts)
+	return op, nil
+}
+
+func (s *UserCleanupService) newPurgeOperation(user *models.User, opts DeleteUserOptions) (*models.Operation, error) {
+	op := &models.Operation{
+		Type:          models.OperationUserPurge,
+		TargetType:    "user",
+		TargetID:      strconv.Itoa(user.ID),
+		TargetName:    user.Username,
+		CreatedBy:     opts.ActorID,
+		CreatedByName: opts.ActorName,
+	}
+	if err := s.operations.Create(op); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+func (s *UserCleanupService) runPurge(op *models.Operation, user *models.User, opts DeleteUserOptions) {
+	err := s.RunDelete(op, user, opts.Data)
+	s.operations.Finish(op)
+	if err == nil && op.Status != models.OperationCompleted {
+		err = fmt.Errorf("部分清理步骤失败，详见操作 %d", op.ID)
+	}
+
+	event := &models.AuditEvent{
+		ActorID:    opts.ActorID,
+		ActorName:  opts.ActorName,
+		Action:     models.AuditUserPurge,
+		TargetType: "user",
+		TargetID:   strconv.Itoa(user.ID),
+		SourceIP:   opts.SourceIP,
+	}
+	event.SetChange("username", user.Username, nil)
+	event.SetChange("email", user.Email, nil)
+	event.SetChange("data", nil, opts.Data)
+	event.SetChange("operation_id", nil, op.ID)
+	s.audit.Record(event, err)
+}
+
+// RunDelete 依次删除容器、处理家目录、删除账户记录。删除容器或处理家目录失败时保留账户，
+// 管理员可以修复后重新删除；已完成的步骤不回滚
+func (s *UserCleanupService) RunDelete(op *models.Operation, user *models.User, data string) error {
+	// 数据库中没有容器记录时仍按命名约定清理，避免遗留的容器继续占用端口
+	containerRef := user.ContainerID
+	if containerRef == "" {
+		containerRef = fmt.Sprintf("dev-%s", user.Username)
+	}
+	err := s.containerService.RemoveContainer(containerRef)
+	op.AddStep("remove_container", containerRef, err)
+	s.operations.Save(op)
+	if err != nil {
+		return err
+	}
+
+	dir, dirErr := userDataDir(user.Username)
+	if dirErr != nil && data != UserDataKeep {
+		op.AddStep("delete_data", user.Username, dirErr)
+		return dirErr
+	}
+	switch data {
+	case UserDataArchive:
+		archive, err := archiveUserData(user.Username)
+		if err != nil {
+			op.AddStep("archive_data", dir, err)
+			return err
+		}
+		if archive == "" {
+			op.SkipStep("archive_data", "家目录不存在")
+			break
+		}
+		op.AddStep("archive_data", archive, nil)
+		err = os.RemoveAll(dir)
+		op.AddStep("delete_data", dir, err)
+		if err != nil {
+			return err
+		}
+	case UserDataDelete:
+		err := os.RemoveAll(dir)
+		op.AddStep("delete_data", dir, err)
+		if err != nil {
+			return err
+		}
+	default:
+		op.SkipStep("delete_data", "保留家目录 "+dir)
+	}
+	s.operations.Save(op)
+
+	// 删除账户记录后端口段不再被占用，会话、令牌等随外键级联删除
+	err = s.userService.DeleteUser(user.ID)
+	op.AddStep("delete_account", user.Username, err)
+	if err != nil {
+		return err
+	}
+	op.AddStep("release_ports", fmt.Sprintf("%d-%d", user.BasePort, user.BasePort+9), nil)
+	return nil
+}
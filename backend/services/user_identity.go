@@ -0,0 +1,151 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"gpu-dev-platform/models"
+)
+
+var (
+	ErrIdentityNotLinked = errors.New("username is taken by an account not linked to this identity")
+	ErrIdentityLinked    = errors.New("identity is already linked to an account")
+	ErrIdentityNotFound  = errors.New("identity link not found")
+	ErrInvalidIdentity   = errors.New("identity provider and subject are required")
+
+	// 身份源给出的用户名会成为容器名和家目录名，不符合平台用户名格式时不自动开通
+	ErrInvalidExternalUsername = errors.New("external username is not a valid platform username")
+)
+
+// ExternalIdentity 外部身份源认证通过的用户
+type ExternalIdentity struct {
+	Provider string // "ldap" 或 "oidc:<issuer>"
+	Subject  string // 身份源内不变的唯一标识，如OIDC的sub
+	Username string // 首次登录开通账户时使用的用户名
+	Email    string
+	IsAdmin  *bool // nil 表示身份源未配置管理员组，保留平台上的管理员标记
+}
+
+// OIDCIdentityProvider OIDC身份在关联表中的 provider 值，不同issuer的sub互不相干
+func OIDCIdentityProvider(issuer string) string {
+	return "oidc:" + strings.TrimSuffix(issuer, "/")
+}
+
+// ProvisionExternalUser 按 (provider, subject) 关联查找外部身份对应的本地账户，并同步邮箱和管理员标记。
+// 没有关联时才以身份源给出的用户名开通新账户；同名账户已存在时拒绝登录，
+// 避免身份源中同名的人接管本地账户，需由管理员确认后手动关联
+func (s *UserService) ProvisionExternalUser(identity ExternalIdentity) (*models.User, error) {
+	if identity.Provider == "" || identity.Subject == "" {
+		return nil, ErrInvalidIdentity
+	}
+
+	user, err := s.userByIdentity(identity.Provider, identity.Subject)
+	if err == sql.ErrNoRows {
+		user, err = s.createExternalUser(identity)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	updates := make(map[string]interface{})
+	if identity.Email != "" && identity.Email != user.Email {
+		updates["email"] = identity.Email
+		user.Email = identity.Email
+	}
+	if identity.IsAdmin != nil && *identity.IsAdmin != user.IsAdmin {
+		updates["is_admin"] = *identity.IsAdmin
+		user.IsAdmin = *identity.IsAdmin
+	}
+	if err := s.UpdateUser(user.ID, updates); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (s *UserService) userByIdentity(provider, subject string) (*models.User, error) {
+	var userID int
+	err := s.db.QueryRow("SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?",
+		provider, subject).Scan(&userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetUserByID(userID)
+}
+
+// createExternalUser 开通外部身份的本地账户，本地密码随机生成，无法用于本地登录
+func (s *UserService) createExternalUser(identity ExternalIdentity) (*models.User, error) {
+	if !models.ValidUsername(identity.Username) {
+		return nil, ErrInvalidExternalUsername
+	}
+	if _, err := s.GetUserByUsername(identity.Username); err == nil {
+		return nil, ErrIdentityNotLinked
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	password, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.CreateUser(identity.Username, password, identity.Email, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.LinkIdentity(user.ID, identity.Provider, identity.Subject); err != nil {
+		// 同一身份并发首次登录时另一请求已完成关联，撤销本次开通的账户
+		s.db.Exec("DELETE FROM users WHERE id = ?", user.ID)
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// LinkIdentity 将外部身份关联到已有账户，之后该身份登录即进入此账户
+func (s *UserService) LinkIdentity(userID int, provider, subject string) error {
+	if provider == "" || subject == "" {
+		return ErrInvalidIdentity
+	}
+
+	_, err := s.db.Exec("INSERT INTO user_identities (provider, subject, user_id) VALUES (?, ?, ?)",
+		provider, subject, userID)
+	if err != nil && strings.Contains(err.Error(), "Duplicate entry") {
+		return ErrIdentityLinked
+	}
+	return err
+}
+
+// UnlinkIdentity 解除账户与外部身份的关联
+func (s *UserService) UnlinkIdentity(userID int, provider, subject string) error {
+	result, err := s.db.Exec("DELETE FROM user_identities WHERE user_id = ? AND provider = ? AND subject = ?",
+		userID, provider, subject)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrIdentityNotFound
+	}
+	return nil
+}
+
+// ListIdentities 账户已关联的外部身份
+func (s *UserService) ListIdentities(userID int) ([]models.UserIdentity, error) {
+	rows, err := s.db.Query(`SELECT provider, subject, user_id, created_at FROM user_identities
+		WHERE user_id = ? ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []models.UserIdentity{}
+	for rows.Next() {
+		var identity models.UserIdentity
+		if err := rows.Scan(&identity.Provider, &identity.Subject, &identity.UserID, &identity.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
@@ -3,8 +3,7 @@ package services
 import (
 	"database/sql"
 	"fmt"
-	"os"
-	"strconv"
+	"strings"
 	"time"
 	
 	"gpu-dev-platform/database"
@@ -12,51 +11,47 @@ import (
 )
 
 type UserService struct {
-	db *sql.DB
+	db    *sql.DB
+	ports *PortAllocator
 }
 
 func NewUserService() *UserService {
-	return &UserService{db: database.DB}
+	return &UserService{db: database.DB, ports: defaultPortAllocator()}
 }
 
-func (s *UserService) CreateUser(username, password, email string) (*models.User, error) {
+// CreateUser 创建用户，mustChangePassword 为 true 时用户首次登录后必须修改密码
+func (s *UserService) CreateUser(username, password, email string, mustChangePassword bool) (*models.User, error) {
+	return s.CreateUserWithExpiry(username, password, email, mustChangePassword, nil)
+}
+
+// CreateUserWithExpiry 创建用户并设置到期时间，到期时间与账户记录一起写入，expiresAt 为空表示长期有效
+func (s *UserService) CreateUserWithExpiry(username, password, email string, mustChangePassword bool, expiresAt *time.Time) (*models.User, error) {
 	user := &models.User{
-		Username:  username,
-		Email:     email,
-		IsActive:  true,
-		IsAdmin:   false,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		Username:           username,
+		Email:              email,
+		IsActive:           true,
+		IsAdmin:            false,
+		CreatedAt:          time.Now(),
+		UpdatedAt:          time.Now(),
+		MustChangePassword: mustChangePassword,
+		ExpiresAt:          expiresAt,
 	}
 
 	if err := user.HashPassword(password); err != nil {
 		return nil, err
 	}
 
-	// 分配端口
-	basePort, err := s.allocatePort()
-	if err != nil {
-		return nil, err
+	// 端口段与账户记录在同一事务中写入；旧数据或并发写入导致 base_port 唯一约束冲突时重新分配
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		if err = s.insertUser(user); err == nil || !isBasePortConflict(err) {
+			break
+		}
 	}
-	user.BasePort = basePort
-
-	query := `
-		INSERT INTO users (username, password, email, is_active, is_admin, created_at, updated_at, base_port)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-	`
-	
-	result, err := s.db.Exec(query, user.Username, user.Password, user.Email, 
-		user.IsActive, user.IsAdmin, user.CreatedAt, user.UpdatedAt, user.BasePort)
 	if err != nil {
 		return nil, err
 	}
 
-	id, err := result.LastInsertId()
-	if err != nil {
-		return nil, err
-	}
-	user.ID = int(id)
-
 	return user, nil
 }
 
@@ -65,14 +60,14 @@ func (s *UserService) GetUserByID(id int) (*models.User, error) {
 	query := `
 		SELECT id, username, password, email, is_active, is_admin, 
 		       created_at, updated_at, COALESCE(container_id, ''), 
-		       base_port, COALESCE(last_login, created_at)
+		       base_port, COALESCE(last_login, created_at), must_change_password, expires_at, deleted_at
 		FROM users WHERE id = ?
 	`
 	
 	err := s.db.QueryRow(query, id).Scan(
 		&user.ID, &user.Username, &user.Password, &user.Email,
 		&user.IsActive, &user.IsAdmin, &user.CreatedAt, &user.UpdatedAt,
-		&user.ContainerID, &user.BasePort, &user.LastLogin,
+		&user.ContainerID, &user.BasePort, &user.LastLogin, &user.MustChangePassword, &user.ExpiresAt, &user.DeletedAt,
 	)
 	
 	if err != nil {
@@ -87,14 +82,14 @@ func (s *UserService) GetUserByUsername(username string) (*models.User, error) {
 	query := `
 		SELECT id, username, password, email, is_active, is_admin, 
 		       created_at, updated_at, COALESCE(container_id, ''), 
-		       base_port, COALESCE(last_login, created_at)
+		       base_port, COALESCE(last_login, created_at), must_change_password, expires_at, deleted_at
 		FROM users WHERE username = ?
 	`
 	
 	err := s.db.QueryRow(query, username).Scan(
 		&user.ID, &user.Username, &user.Password, &user.Email,
 		&user.IsActive, &user.IsAdmin, &user.CreatedAt, &user.UpdatedAt,
-		&user.ContainerID, &user.BasePort, &user.LastLogin,
+		&user.ContainerID, &user.BasePort, &user.LastLogin, &user.MustChangePassword, &user.ExpiresAt, &user.DeletedAt,
 	)
 	
 	if err != nil {
@@ -104,40 +99,146 @@ func (s *UserService) GetUserByUsername(username string) (*models.User, error) {
 	return user, nil
 }
 
-func (s *UserService) ListUsers() ([]*models.User, error) {
+// UserFilter 用户列表查询条件，nil 或零值字段不参与过滤
+type UserFilter struct {
+	Search       string // 按用户名或邮箱模糊匹配
+	IsActive     *bool
+	IsAdmin      *bool
+	HasContainer *bool
+	Deleted      bool   // true 时只查回收站中的用户，否则排除回收站
+	Sort         string // 见 userSortColumns，默认 created_at
+	Desc         bool
+	Page         int
+	PageSize     int
+}
+
+// userSortColumns 允许排序的字段，避免把用户输入拼进 ORDER BY
+var userSortColumns = map[string]string{
+	"id":         "id",
+	"username":   "username",
+	"email":      "email",
+	"created_at": "created_at",
+	"last_login": "COALESCE(last_login, created_at)",
+	"base_port":  "base_port",
+	"expires_at": "expires_at",
+	"deleted_at": "deleted_at",
+}
+
+// ValidUserSort 判断排序字段是否受支持
+func ValidUserSort(field string) bool {
+	_, ok := userSortColumns[field]
+	return ok
+}
+
+// ListUsers 按条件分页查询用户，返回当前页和符合条件的总数
+func (s *UserService) ListUsers(filter UserFilter) ([]*models.User, int, error) {
+	where, args := filter.where()
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM users"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	if filter.PageSize <= 0 {
+		filter.PageSize = 50
+	}
+	if filter.Page <= 0 {
+		filter.Page = 1
+	}
+
+	// 确保返回空数组而不是nil
+	users := []*models.User{}
+	err := s.eachUser(where, filter.orderBy()+" LIMIT ? OFFSET ?",
+		append(args, filter.PageSize, (filter.Page-1)*filter.PageSize), func(user *models.User) error {
+			users = append(users, user)
+			return nil
+		})
+	if err != nil {
+		return nil, 0, err
+	}
+	
+	return users, total, nil
+}
+
+// EachUser 按排序遍历全部匹配的用户（忽略分页），用于导出
+func (s *UserService) EachUser(filter UserFilter, fn func(*models.User) error) error {
+	where, args := filter.where()
+	return s.eachUser(where, filter.orderBy(), args, fn)
+}
+
+func (s *UserService) eachUser(where, suffix string, args []interface{}, fn func(*models.User) error) error {
 	query := `
 		SELECT id, username, password, email, is_active, is_admin, 
 		       created_at, updated_at, COALESCE(container_id, ''), 
-		       base_port, COALESCE(last_login, created_at)
-		FROM users ORDER BY created_at DESC
-	`
-	
-	rows, err := s.db.Query(query)
+		       base_port, COALESCE(last_login, created_at), must_change_password, expires_at, deleted_at
+		FROM users` + where + suffix
+
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 	
-	var users []*models.User
 	for rows.Next() {
 		user := &models.User{}
 		err := rows.Scan(
 			&user.ID, &user.Username, &user.Password, &user.Email,
 			&user.IsActive, &user.IsAdmin, &user.CreatedAt, &user.UpdatedAt,
-			&user.ContainerID, &user.BasePort, &user.LastLogin,
+			&user.ContainerID, &user.BasePort, &user.LastLogin, &user.MustChangePassword, &user.ExpiresAt, &user.DeletedAt,
 		)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
 		}
-		users = append(users, user)
 	}
-	
-	// 确保返回空数组而不是nil
-	if users == nil {
-		users = []*models.User{}
+	return rows.Err()
+}
+
+// orderBy 生成排序子句，追加 id 保证排序字段相同时分页结果稳定
+func (f UserFilter) orderBy() string {
+	column, ok := userSortColumns[f.Sort]
+	if !ok {
+		column = "created_at"
 	}
-	
-	return users, nil
+	direction := "ASC"
+	if f.Desc {
+		direction = "DESC"
+	}
+	return fmt.Sprintf(" ORDER BY %s %s, id %s", column, direction, direction)
+}
+
+func (f UserFilter) where() (string, []interface{}) {
+	conditions := []string{"deleted_at IS NULL"}
+	if f.Deleted {
+		conditions[0] = "deleted_at IS NOT NULL"
+	}
+	args := []interface{}{}
+
+	if f.Search != "" {
+		// 转义 LIKE 通配符，按字面匹配用户输入
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(f.Search) + "%"
+		conditions = append(conditions, "(username LIKE ? OR email LIKE ?)")
+		args = append(args, pattern, pattern)
+	}
+	if f.IsActive != nil {
+		conditions = append(conditions, "is_active = ?")
+		args = append(args, *f.IsActive)
+	}
+	if f.IsAdmin != nil {
+		conditions = append(conditions, "is_admin = ?")
+		args = append(args, *f.IsAdmin)
+	}
+	if f.HasContainer != nil {
+		if *f.HasContainer {
+			conditions = append(conditions, "COALESCE(container_id, '') <> ''")
+		} else {
+			conditions = append(conditions, "COALESCE(container_id, '') = ''")
+		}
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND "), args
 }
 
 func (s *UserService) UpdateUser(id int, updates map[string]interface{}) error {
@@ -165,7 +266,7 @@ func (s *UserService) UpdateUser(id int, updates map[string]interface{}) error {
 	args = append(args, id)
 	
 	query := fmt.Sprintf("UPDATE users SET %s WHERE id = ?", 
-		fmt.Sprintf("%s", setParts))
+		strings.Join(setParts, ", "))
 	
 	_, err := s.db.Exec(query, args...)
 	return err
@@ -182,32 +283,77 @@ func (s *UserService) UpdateLastLogin(userID int) error {
 	return err
 }
 
-// allocatePort 分配可用端口
-func (s *UserService) allocatePort() (int, error) {
-	// 从环境变量获取端口前缀和步长
-	defaultPortPrefix := 9000
-	if prefix := os.Getenv("DEFAULT_PORT_PREFIX"); prefix != "" {
-		if p, err := strconv.Atoi(prefix); err == nil {
-			defaultPortPrefix = p
-		}
+// insertUser 在事务中分配端口段并写入账户记录
+func (s *UserService) insertUser(user *models.User) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
 	}
-	
-	portStep := 100
-	if step := os.Getenv("PORT_STEP"); step != "" {
-		if s, err := strconv.Atoi(step); err == nil {
-			portStep = s
-		}
+	defer tx.Rollback()
+
+	basePort, err := s.ports.Allocate(tx, 0)
+	if err != nil {
+		return err
 	}
-	
-	var maxPort sql.NullInt64
-	err := s.db.QueryRow("SELECT MAX(base_port) FROM users").Scan(&maxPort)
-	if err != nil && err != sql.ErrNoRows {
+	user.BasePort = basePort
+
+	query := `
+		INSERT INTO users (username, password, email, is_active, is_admin, created_at, updated_at, base_port, must_change_password, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	result, err := tx.Exec(query, user.Username, user.Password, user.Email,
+		user.IsActive, user.IsAdmin, user.CreatedAt, user.UpdatedAt, user.BasePort, user.MustChangePassword, user.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	user.ID = int(id)
+
+	return tx.Commit()
+}
+
+// ReassignPorts 为用户重新分配端口段，basePort 为 0 时自动选择空闲端口段。
+// 容器的端口映射在创建时确定，已有容器的用户需先删除容器
+func (s *UserService) ReassignPorts(user *models.User, basePort int) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
 		return 0, err
 	}
-	
-	if !maxPort.Valid {
-		return defaultPortPrefix, nil // 使用配置的起始端口
+	defer tx.Rollback()
+
+	if basePort == 0 {
+		basePort, err = s.ports.Allocate(tx, user.ID)
+	} else {
+		err = s.ports.Reserve(tx, basePort, user.ID)
 	}
-	
-	return int(maxPort.Int64) + portStep, nil // 使用配置的步长
-}
\ No newline at end of file
+	if err != nil {
+		return 0, err
+	}
+
+	// 只在用户仍没有容器时更新，避免与并发创建容器冲突
+	result, err := tx.Exec("UPDATE users SET base_port = ?, updated_at = ? WHERE id = ? AND (container_id IS NULL OR container_id = '')",
+		basePort, time.Now(), user.ID)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, ErrUserHasContainer
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return basePort, nil
+}
+
+// PortUsage 端口分配范围的使用情况
+func (s *UserService) PortUsage() (*models.PortUsage, error) {
+	return s.ports.Usage()
+}
+
+func isBasePortConflict(err error) bool {
+	return strings.Contains(err.Error(), "Duplicate entry") && strings.Contains(err.Error(), "base_port")
+}
@@ -54,6 +54,16 @@ fi
 chown -R $DEV_UID:$DEV_GID /home/$DEV_USER
     echo "用户主目录权限设置成功"
 
+# 加入用户所在的平台组，组共享目录只对组内GID开放
+for entry in $(echo "$DEV_GROUPS" | tr ',' ' '); do
+    group_name=${entry%%:*}
+    group_gid=${entry##*:}
+    if ! getent group $group_gid > /dev/null 2>&1; then
+        groupadd -g $group_gid "grp-$group_name" 2>/dev/null || echo "警告: 组 $group_name 创建失败"
+    fi
+    usermod -aG $group_gid $DEV_USER && echo "加入组: $group_name ($group_gid)"
+done
+
 # 创建必要的目录
 mkdir -p /home/$DEV_USER/.jupyter
 mkdir -p /home/$DEV_USER/.vscode-server
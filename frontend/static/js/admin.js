@@ -17,8 +17,8 @@ function checkAdminAuth() {
     
     try {
         const adminData = JSON.parse(admin);
-        if (!adminData.is_admin) {
-            // 不是管理员，跳转到管理员登录页面
+        if (!adminData.is_admin && !(adminData.permissions || []).length) {
+            // 没有任何管理权限，跳转到管理员登录页面
             sessionStorage.removeItem('admin');
             sessionStorage.removeItem('adminToken');
             window.location.href = '/admin-login';
@@ -41,6 +41,50 @@ function getAdminHeaders() {
     };
 }
 
+// 访问token有效期较短，收到401时用刷新token换取新token后重试一次
+const originalFetch = window.fetch.bind(window);
+let refreshPromise = null;
+
+function refreshAdminToken() {
+    if (!refreshPromise) {
+        const refreshToken = sessionStorage.getItem('adminRefreshToken');
+        refreshPromise = (async () => {
+            if (!refreshToken) return false;
+            const response = await originalFetch(`${API_BASE}/token/refresh`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ refresh_token: refreshToken })
+            });
+            if (!response.ok) return false;
+            const data = await response.json();
+            sessionStorage.setItem('adminToken', data.token);
+            sessionStorage.setItem('adminRefreshToken', data.refresh_token);
+            return true;
+        })().finally(() => { refreshPromise = null; });
+    }
+    return refreshPromise;
+}
+
+window.fetch = async function(url, options = {}) {
+    const response = await originalFetch(url, options);
+    if (response.status !== 401 || !String(url).startsWith(API_BASE) || String(url).startsWith(`${API_BASE}/token/refresh`)) {
+        return response;
+    }
+
+    if (!(await refreshAdminToken())) {
+        sessionStorage.removeItem('admin');
+        sessionStorage.removeItem('adminToken');
+        sessionStorage.removeItem('adminRefreshToken');
+        window.location.href = '/admin-login';
+        return response;
+    }
+
+    const headers = Object.assign({}, options.headers, {
+        'Authorization': `Bearer ${sessionStorage.getItem('adminToken')}`
+    });
+    return originalFetch(url, Object.assign({}, options, { headers }));
+};
+
 // 防抖变量
 let containerLoadTimeout = null;
 let isContainerLoading = false;
@@ -65,6 +109,7 @@ document.addEventListener('DOMContentLoaded', function() {
             console.log('创建容器模态框打开，刷新用户列表...');
             loadUserOptions(0); // 实时获取最新用户列表，重置重试计数
             generateSecurePassword(); // 自动生成密码
+            loadResourceLimits();
         });
         
         // 备用事件监听（防止Bootstrap事件失效）
@@ -166,13 +211,38 @@ function showSection(sectionName) {
     }
 }
 
+// 用户列表的当前页码
+let usersPage = 1;
+
 // 加载用户列表
-async function loadUsers() {
+async function loadUsers(page) {
+    if (page) {
+        usersPage = page;
+    }
+    
+    const params = new URLSearchParams({ page: usersPage, page_size: 20 });
+    const search = document.getElementById('users-search').value.trim();
+    if (search) {
+        params.set('search', search);
+    }
+    // 状态筛选选择“回收站”时改为查询回收站中的用户
+    const status = document.getElementById('users-filter-active').value;
+    const trash = status === 'trash';
+    if (status && !trash) {
+        params.set('is_active', status);
+    }
+    const [sort, order] = document.getElementById('users-sort').value.split(':');
+    if (!trash) {
+        params.set('sort', sort);
+        params.set('order', order);
+    }
+    
     try {
-        const response = await fetch(`${API_BASE}/users`, {
+        const response = await fetch(`${API_BASE}/users${trash ? '/trash' : ''}?${params}`, {
             headers: getAdminHeaders()
         });
-        const users = await response.json();
+        const data = await response.json();
+        const users = data.users;
         
         const tbody = document.getElementById('users-table-body');
         tbody.innerHTML = '';
@@ -189,6 +259,11 @@ async function loadUsers() {
             row.innerHTML = '<td colspan="8" class="text-center text-muted">暂无用户</td>';
             tbody.appendChild(row);
         }
+        
+        const totalPages = Math.max(1, Math.ceil(data.total / data.page_size));
+        document.getElementById('users-page-info').textContent = `共 ${data.total} 个用户，第 ${data.page}/${totalPages} 页`;
+        document.getElementById('users-prev-page').disabled = data.page <= 1;
+        document.getElementById('users-next-page').disabled = !data.has_more;
     } catch (error) {
         console.error('加载用户失败:', error);
         showAlert('加载用户失败', 'danger');
@@ -199,8 +274,8 @@ async function loadUsers() {
 function createUserRow(user) {
     const row = document.createElement('tr');
     
-    const statusClass = user.is_active ? 'status-active' : 'status-inactive';
-    const statusText = user.is_active ? '活跃' : '禁用';
+    const statusClass = user.is_active && !user.deleted_at ? 'status-active' : 'status-inactive';
+    const statusText = user.deleted_at ? '已删除' : (user.is_active ? '活跃' : '禁用');
     const adminText = user.is_admin ? '是' : '否';
     const ports = `${user.base_port}-${user.base_port + 9}`;
     
@@ -212,7 +287,10 @@ function createUserRow(user) {
         <td>${adminText}</td>
         <td>${ports}</td>
         <td>${new Date(user.created_at).toLocaleDateString()}</td>
-        <td>
+        ${user.deleted_at ? `<td>
+            <button class="btn btn-sm btn-outline-success" onclick="restoreUser(${user.id}, '${user.username}')">恢复</button>
+            <button class="btn btn-sm btn-outline-danger" onclick="purgeUser(${user.id}, '${user.username}')">彻底删除</button>
+        </td>` : `<td>
             <button class="btn btn-action btn-action-primary" onclick="editUser(${user.id})" title="编辑用户">
                 <svg width="14" height="14" viewBox="0 0 24 24" fill="none" xmlns="http://www.w3.org/2000/svg">
                     <path d="M11 4H4a2 2 0 0 0-2 2v14a2 2 0 0 0 2 2h14a2 2 0 0 0 2-2v-7" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"/>
@@ -237,7 +315,7 @@ function createUserRow(user) {
                  </button>` : 
                 '<span class="text-muted small">系统管理员</span>'
             }
-        </td>
+        </td>`}
     `;
     
     return row;
@@ -288,7 +366,7 @@ async function deleteUser(id, username) {
         return;
     }
     
-    if (!confirm(`确定要删除用户 "${username}" 吗？`)) {
+    if (!confirm(`确定要删除用户 "${username}" 吗？\n用户将移入回收站并停止容器，保留期内可以恢复。`)) {
         return;
     }
     
@@ -299,17 +377,84 @@ async function deleteUser(id, username) {
         });
         
         if (response.ok) {
-            showAlert('用户删除成功', 'success');
+            showAlert('用户已移入回收站', 'success');
+            loadUsers();
+        } else {
+            showAlert('删除失败', 'danger');
+        }
+    } catch (error) {
+        console.error('删除用户失败:', error);
+        showAlert('删除用户失败', 'danger');
+    }
+}
+
+// 从回收站恢复用户
+async function restoreUser(id, username) {
+    try {
+        const response = await fetch(`${API_BASE}/users/${id}/restore`, {
+            method: 'POST',
+            headers: getAdminHeaders()
+        });
+        
+        if (response.ok) {
+            showAlert(`用户 "${username}" 已恢复，容器需要手动启动`, 'success');
             loadUsers();
         } else {
+            showAlert('恢复失败: ' + await response.text(), 'danger');
+        }
+    } catch (error) {
+        console.error('恢复用户失败:', error);
+        showAlert('恢复用户失败', 'danger');
+    }
+}
+
+// 彻底删除用户：删除容器、释放端口，家目录归档后删除
+async function purgeUser(id, username) {
+    if (!confirm(`确定要彻底删除用户 "${username}" 吗？\n将删除其容器，家目录会打包归档后删除，此操作无法撤销。`)) {
+        return;
+    }
+    
+    try {
+        const response = await fetch(`${API_BASE}/users/${id}?purge=true&data=archive`, {
+            method: 'DELETE',
+            headers: getAdminHeaders()
+        });
+        
+        if (!response.ok) {
             showAlert('删除失败', 'danger');
+            return;
+        }
+        
+        showAlert('正在删除用户并清理容器和数据...', 'info');
+        const operation = await waitForOperation((await response.json()).id);
+        if (operation.status === 'completed') {
+            showAlert('用户已彻底删除', 'success');
+        } else {
+            const failed = (operation.steps || []).filter(step => step.status === 'failed');
+            const detail = failed.map(step => `${step.name}: ${step.error}`).join('; ');
+            showAlert(`删除未完全成功（${operation.status}）${detail ? '：' + detail : ''}`, 'warning');
         }
+        loadUsers();
     } catch (error) {
         console.error('删除用户失败:', error);
         showAlert('删除用户失败', 'danger');
     }
 }
 
+// 轮询后台操作直到结束
+async function waitForOperation(id) {
+    for (;;) {
+        const response = await fetch(`${API_BASE}/operations/${id}`, {
+            headers: getAdminHeaders()
+        });
+        const operation = await response.json();
+        if (operation.status !== 'running') {
+            return operation;
+        }
+        await new Promise(resolve => setTimeout(resolve, 1000));
+    }
+}
+
 // 加载容器列表（优化防闪烁）
 async function loadContainers(forceRefresh = false) {
     // 防止重复加载
@@ -476,6 +621,12 @@ async function createContainerRow(container) {
                     <path d="m5 15H4a2 2 0 0 1-2-2V4a2 2 0 0 1 2-2h9a2 2 0 0 1 2 2v1" stroke="currentColor" stroke-width="2"/>
                 </svg>
             </button>
+            <button class="btn btn-action btn-action-secondary" onclick="resizeContainerDialog('${container.id}')" title="调整资源">
+                <svg width="14" height="14" viewBox="0 0 24 24" fill="none" xmlns="http://www.w3.org/2000/svg">
+                    <rect x="4" y="4" width="16" height="16" rx="2" ry="2" stroke="currentColor" stroke-width="2"/>
+                    <rect x="9" y="9" width="6" height="6" stroke="currentColor" stroke-width="2"/>
+                </svg>
+            </button>
             <button class="btn btn-action btn-action-secondary" onclick="resetContainerPasswordDialog('${container.id}', '${container.name}')" title="重置服务密码">
                 <svg width="14" height="14" viewBox="0 0 24 24" fill="none" xmlns="http://www.w3.org/2000/svg">
                     <rect x="3" y="11" width="18" height="11" rx="2" ry="2" stroke="currentColor" stroke-width="2"/>
@@ -620,7 +771,7 @@ async function loadUserOptions(retryCount = 0) {
         const controller = new AbortController();
         const timeoutId = setTimeout(() => controller.abort(), 10000); // 10秒超时
         
-        const response = await fetch(`${API_BASE}/users`, {
+        const response = await fetch(`${API_BASE}/users?has_container=false&sort=username&order=asc&page_size=500`, {
             signal: controller.signal,
             headers: {
                 'Cache-Control': 'no-cache, no-store, must-revalidate',
@@ -636,7 +787,7 @@ async function loadUserOptions(retryCount = 0) {
             throw new Error(`HTTP ${response.status}: ${response.statusText}`);
         }
         
-        const users = await response.json();
+        const users = (await response.json()).users;
         
         const select = document.getElementById('container-user-id');
         if (!select) {
@@ -716,10 +867,33 @@ async function loadUserOptions(retryCount = 0) {
     }
 }
 
+// 加载容器资源限制的默认值和上限，显示在创建容器表单中
+async function loadResourceLimits() {
+    try {
+        const response = await fetch(`${API_BASE}/containers/limits`, {
+            headers: getAdminHeaders()
+        });
+        if (!response.ok) return;
+        const limits = await response.json();
+        const d = limits.defaults;
+        const m = limits.maxima;
+        document.getElementById('container-cpus').placeholder = `CPU (默认${d.cpus})`;
+        document.getElementById('container-memory').placeholder = `内存 (默认${d.memory})`;
+        document.getElementById('container-swap').placeholder = `交换空间 (默认${d.swap})`;
+        document.getElementById('container-pids').placeholder = `进程数 (默认${d.pids_limit})`;
+        document.getElementById('container-shm').placeholder = `共享内存 (默认${d.shm_size})`;
+        document.getElementById('container-limits-hint').textContent =
+            `可选，留空使用默认值。上限：CPU ${m.cpus}，内存 ${m.memory}，交换空间 ${m.swap}，进程数 ${m.pids_limit}，共享内存 ${m.shm_size}`;
+    } catch (error) {
+        console.error('加载资源限制失败:', error);
+    }
+}
+
 // 创建容器
 async function createContainer() {
     const userId = document.getElementById('container-user-id').value;
     const gpuDevices = document.getElementById('gpu-devices').value;
+    const gpuMode = document.getElementById('gpu-mode').value;
     const password = document.getElementById('service-password').value;
     
     if (!userId) {
@@ -742,6 +916,24 @@ async function createContainer() {
         gpu_devices: gpuDevices,
         password: password
     };
+    if (gpuMode) requestBody.gpu_mode = gpuMode;
+    const gpuCount = document.getElementById('gpu-count').value.trim();
+    const gpuModel = document.getElementById('gpu-model').value.trim();
+    const gpuMinMemory = document.getElementById('gpu-min-memory').value.trim();
+    if (gpuCount) requestBody.gpu_count = parseInt(gpuCount);
+    if (gpuModel) requestBody.gpu_model = gpuModel;
+    if (gpuMinMemory) requestBody.min_memory_gb = parseFloat(gpuMinMemory);
+    // 留空的资源限制不提交，由后端使用默认值
+    const cpus = document.getElementById('container-cpus').value.trim();
+    const memory = document.getElementById('container-memory').value.trim();
+    const swap = document.getElementById('container-swap').value.trim();
+    const pids = document.getElementById('container-pids').value.trim();
+    const shm = document.getElementById('container-shm').value.trim();
+    if (cpus) requestBody.cpus = cpus;
+    if (memory) requestBody.memory = memory;
+    if (swap) requestBody.swap = swap;
+    if (pids) requestBody.pids_limit = parseInt(pids);
+    if (shm) requestBody.shm_size = shm;
     
     try {
         const response = await fetch(`${API_BASE}/containers`, {
@@ -755,7 +947,11 @@ async function createContainer() {
         
         if (response.ok) {
             const responseData = await response.json();
-            showAlert('容器创建成功！', 'success');
+            if (responseData.warnings && responseData.warnings.length > 0) {
+                showAlert(`容器已创建，但GPU存在冲突：${responseData.warnings.join('；')}`, 'warning');
+            } else {
+                showAlert('容器创建成功！', 'success');
+            }
             document.getElementById('createContainerForm').reset();
             
             bootstrap.Modal.getInstance(document.getElementById('createContainerModal')).hide();
@@ -773,6 +969,11 @@ async function createContainer() {
             }, 500);
             loadUserOptions(); // 刷新用户选项
         } else {
+            if (response.status === 409) {
+                const data = await response.json();
+                showAlert(`创建失败: ${data.error}`, 'danger');
+                return;
+            }
             const error = await response.text();
             showAlert(`创建失败: ${error}`, 'danger');
         }
@@ -786,14 +987,11 @@ async function createContainer() {
 async function loadDashboard() {
     try {
         // 加载用户统计
-        const usersResponse = await fetch(`${API_BASE}/users`, {
+        const usersResponse = await fetch(`${API_BASE}/users?is_active=true&page_size=1`, {
             headers: getAdminHeaders()
         });
-        const users = await usersResponse.json();
-        const activeUsers = (users && Array.isArray(users)) 
-            ? users.filter(user => user.is_active).length 
-            : 0;
-        document.getElementById('active-users').textContent = activeUsers;
+        const usersData = await usersResponse.json();
+        document.getElementById('active-users').textContent = usersData.total || 0;
         
         // 加载容器统计
         const containersResponse = await fetch(`${API_BASE}/containers`, {
@@ -894,9 +1092,12 @@ function showAlert(message, type = 'info') {
 // 退出登录
 function logout() {
     if (confirm('确定要退出管理后台吗？')) {
+        // 通知服务端吊销当前会话
+        originalFetch(`${API_BASE}/logout`, { method: 'POST', headers: getAdminHeaders() }).catch(() => {});
         // 清除管理员认证信息
         sessionStorage.removeItem('admin');
         sessionStorage.removeItem('adminToken');
+        sessionStorage.removeItem('adminRefreshToken');
         // 跳转到管理员登录页面
         window.location.href = '/admin-login';
     }
@@ -1046,6 +1247,113 @@ function resetContainerPasswordDialog(containerId, containerName) {
     new bootstrap.Modal(document.getElementById('resetContainerPasswordModal')).show();
 }
 
+// 打开调整资源对话框，填入容器当前的资源限制
+async function resizeContainerDialog(containerId) {
+    try {
+        const response = await fetch(`${API_BASE}/containers/${containerId}`, {
+            headers: getAdminHeaders()
+        });
+        if (!response.ok) {
+            showAlert('获取容器信息失败: ' + await response.text(), 'danger');
+            return;
+        }
+        const container = await response.json();
+        const unlimited = value => value === 'unlimited' ? '' : value;
+
+        document.getElementById('resize-container-id').value = container.id;
+        document.getElementById('resize-user-id').value = container.user_id;
+        document.getElementById('resize-container-name').value = container.name;
+        document.getElementById('resize-cpus').value = unlimited(container.cpu_limit);
+        document.getElementById('resize-memory').value = unlimited(container.memory_limit);
+        document.getElementById('resize-swap').value = unlimited(container.swap_limit);
+        document.getElementById('resize-pids').value = container.pids_limit || '';
+        document.getElementById('resize-shm').value = container.shm_size;
+        document.getElementById('resize-gpu-devices').value = container.gpu_devices || '';
+        document.getElementById('resize-gpu-devices').dataset.current = container.gpu_devices || '';
+        document.getElementById('resize-password').value = '';
+        document.getElementById('resize-recreate-section').classList.add('d-none');
+        document.getElementById('resize-submit').textContent = '应用';
+
+        new bootstrap.Modal(document.getElementById('resizeContainerModal')).show();
+    } catch (error) {
+        console.error('获取容器信息失败:', error);
+        showAlert('获取容器信息失败: ' + error.message, 'danger');
+    }
+}
+
+// 调整容器资源；需要重建时先展示原因并要求设置新密码，确认后再次提交
+async function resizeContainer() {
+    const containerId = document.getElementById('resize-container-id').value;
+    const userId = document.getElementById('resize-user-id').value;
+    const recreateSection = document.getElementById('resize-recreate-section');
+    const recreate = !recreateSection.classList.contains('d-none');
+    const password = document.getElementById('resize-password').value;
+
+    const body = {
+        cpus: document.getElementById('resize-cpus').value.trim(),
+        memory: document.getElementById('resize-memory').value.trim(),
+        swap: document.getElementById('resize-swap').value.trim(),
+        shm_size: document.getElementById('resize-shm').value.trim()
+    };
+    const pids = document.getElementById('resize-pids').value.trim();
+    if (pids) body.pids_limit = parseInt(pids);
+    const gpuInput = document.getElementById('resize-gpu-devices');
+    if (gpuInput.value.trim() !== gpuInput.dataset.current) {
+        body.gpu_devices = gpuInput.value.trim();
+    }
+    if (recreate) {
+        if (!password || password.length < 8) {
+            showAlert('请设置至少8位的服务登录密码', 'warning');
+            return;
+        }
+        body.recreate = true;
+        body.password = password;
+    }
+
+    try {
+        const response = await fetch(`${API_BASE}/containers/${containerId}/resources`, {
+            method: 'PATCH',
+            headers: {
+                'Content-Type': 'application/json',
+                ...getAdminHeaders()
+            },
+            body: JSON.stringify(body)
+        });
+
+        if (response.status === 409) {
+            const result = await response.json();
+            if (!result.requires_recreate) {
+                showAlert('调整资源失败: ' + result.error, 'danger');
+                return;
+            }
+            document.getElementById('resize-recreate-message').textContent = result.error;
+            recreateSection.classList.remove('d-none');
+            generateSecurePassword('resize-password');
+            document.getElementById('resize-submit').textContent = '重建容器并应用';
+            return;
+        }
+        if (!response.ok) {
+            showAlert('调整资源失败: ' + await response.text(), 'danger');
+            return;
+        }
+
+        const result = await response.json();
+        bootstrap.Modal.getInstance(document.getElementById('resizeContainerModal')).hide();
+        if (result.recreated) {
+            showAlert('容器已按新配置重建', 'success');
+            setTimeout(() => {
+                showUserNotificationModal(userId, password, result.container);
+            }, 300);
+        } else {
+            showAlert('资源限制已更新', 'success');
+        }
+        loadContainers(true);
+    } catch (error) {
+        console.error('调整资源失败:', error);
+        showAlert('调整资源失败: ' + error.message, 'danger');
+    }
+}
+
 // 重置容器服务密码
 async function resetContainerPassword() {
     const containerId = document.getElementById('reset-container-id').value;
@@ -1092,7 +1400,7 @@ async function resetContainerPassword() {
 }
 
 // 生成安全密码
-function generateSecurePassword() {
+function generateSecurePassword(targetId = 'service-password') {
     const upperCase = 'ABCDEFGHIJKLMNOPQRSTUVWXYZ';
     const lowerCase = 'abcdefghijklmnopqrstuvwxyz';
     const numbers = '0123456789';
@@ -1114,7 +1422,7 @@ function generateSecurePassword() {
     // 打乱密码字符顺序
     password = password.split('').sort(() => Math.random() - 0.5).join('');
     
-    document.getElementById('service-password').value = password;
+    document.getElementById(targetId).value = password;
     
     // 密码已生成，无需额外提示
 }
@@ -1145,10 +1453,10 @@ async function copyPassword() {
 async function copyUsageInstructions(containerId, username, containerName) {
     try {
         // 获取用户的端口信息
-        const userResponse = await fetch(`${API_BASE}/users`, {
+        const userResponse = await fetch(`${API_BASE}/users?search=${encodeURIComponent(username)}&page_size=500`, {
             headers: getAdminHeaders()
         });
-        const users = await userResponse.json();
+        const users = (await userResponse.json()).users || [];
         const user = users.find(u => u.username === username);
         
         if (!user) {